@@ -54,25 +54,28 @@ func main() {
 		panic(fmt.Errorf("failed to list topics: %v", err))
 	}
 
-	if topicsResult.Has("messages") {
-		fmt.Println("Topic messages already exists")
-		return
-	}
+	// Messages to analyze and tombstones of the ones removed by moderators
+	for _, topic := range []string{"messages", "moderation"} {
+		if topicsResult.Has(topic) {
+			fmt.Printf("Topic %v already exists\n", topic)
+			continue
+		}
 
-	partitions := int32(1)
-	replicationFactor := int16(1)
-	res, err := adminClient.CreateTopic(
-		context.Background(),
-		partitions,
-		replicationFactor,
-		map[string]*string{
-			"delete.retention.ms": strPtr("60000"),
-		}, "messages",
-	)
-	if err != nil {
-		panic(fmt.Errorf("failed to create topic: %v", err))
+		partitions := int32(1)
+		replicationFactor := int16(1)
+		res, err := adminClient.CreateTopic(
+			context.Background(),
+			partitions,
+			replicationFactor,
+			map[string]*string{
+				"delete.retention.ms": strPtr("60000"),
+			}, topic,
+		)
+		if err != nil {
+			panic(fmt.Errorf("failed to create topic: %v", err))
+		}
+		fmt.Printf("Successfully created topic %v\n", res.Topic)
 	}
-	fmt.Printf("Successfully created topic %v\n", res.Topic)
 }
 
 func strPtr(v string) *string {
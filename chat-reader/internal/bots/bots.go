@@ -0,0 +1,359 @@
+package bots
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	// Window of the message rate
+	rateWindow = time.Minute
+	// Messages per minute with no rate score, and with the full one
+	normalRate = 6
+	botRate    = 30
+	// Messages of a user compared for repetition and links
+	recentMessages = 10
+	// Users not seen for this long are forgotten
+	userTTL = 10 * time.Minute
+	// Flagged users exported as metrics and shown in the debug endpoint by default
+	topUsers = 10
+)
+
+// How much each signal alone makes a user a bot, the signals are combined as independent evidence
+var weights = Features{Rate: 0.6, Repetition: 0.75, Links: 0.6, Name: 0.4}
+
+var (
+	botMessagesCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "twitch_bot_messages_total",
+		},
+		[]string{"channel", "result"},
+	)
+	flaggedUsersGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "twitch_flagged_users",
+	})
+	// Score of the topUsers flagged users, the labels of the users leaving the top are deleted
+	topFlaggedUserScoreGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "twitch_top_flagged_user_score",
+		},
+		[]string{"user"},
+	)
+)
+
+var (
+	// Chat bots of streamers, always scored as bots
+	knownBots = map[string]bool{
+		"nightbot": true, "streamelements": true, "streamlabs": true, "moobot": true, "fossabot": true,
+		"wizebot": true, "botrixoficial": true, "soundalerts": true, "sery_bot": true, "pokemoncommunitygame": true,
+	}
+	linkPattern = regexp.MustCompile(`(?i)(https?://|www\.|\b[a-z0-9-]+\.(com|net|org|ru|tv|gg|io|xyz|shop|store|live|me|ly)\b)`)
+	// Names like "bot_store" or "viewerbot"
+	botNamePattern = regexp.MustCompile(`(^bot_?|_?bot$)`)
+	// Names like "hoss00312"
+	digitSuffixPattern = regexp.MustCompile(`\d{4,}$`)
+)
+
+type Mode string
+
+const (
+	// Messages of flagged users are dropped
+	ModeExclude Mode = "exclude"
+	// Messages of flagged users are kept with a chance of one minus their score
+	ModeDownweight Mode = "downweight"
+	// Messages are only scored, the score is stored with the results.
+	// The website weighs every result by one minus its score, whatever the mode.
+	ModeFlag Mode = "flag"
+)
+
+// Signals of a user, from 0 to 1
+type Features struct {
+	// Messages per minute
+	Rate float64 `json:"rate"`
+	// Share of the recent messages that repeat an earlier one
+	Repetition float64 `json:"repetition"`
+	// Share of the recent messages with a link
+	Links float64 `json:"links"`
+	// How much the account name looks generated
+	Name float64 `json:"name"`
+}
+
+// User flagged as a likely bot
+type Suspect struct {
+	User     string    `json:"user"`
+	Score    float64   `json:"score"`
+	Features Features  `json:"features"`
+	Messages int64     `json:"messages"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type userState struct {
+	// Times of the messages in the rate window
+	times []time.Time
+	// Last messages, normalized, and whether they had a link
+	recent   []string
+	links    []bool
+	features Features
+	score    float64
+	messages int64
+	lastSeen time.Time
+}
+
+// Scores how likely each user is a bot from their messages and name
+type Scorer struct {
+	mu        sync.Mutex
+	users     map[string]*userState
+	threshold float64
+	mode      Mode
+	random    func() float64
+	logger    *zap.SugaredLogger
+	// Users with a top score label, only used by the update loop
+	exported map[string]bool
+}
+
+func NewScorer(logger *zap.SugaredLogger) *Scorer {
+	mode := ModeExclude
+	if value, found := os.LookupEnv("BOT_FILTER"); found {
+		switch Mode(value) {
+		case ModeExclude, ModeDownweight, ModeFlag:
+			mode = Mode(value)
+		default:
+			logger.Panicf("Invalid BOT_FILTER %q, expected exclude, downweight or flag", value)
+		}
+	}
+
+	threshold := 0.8
+	if value, found := os.LookupEnv("BOT_SCORE_THRESHOLD"); found {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			logger.Panicf("Invalid BOT_SCORE_THRESHOLD %q, expected a number in (0, 1]", value)
+		}
+		threshold = parsed
+	}
+
+	return &Scorer{
+		users:     map[string]*userState{},
+		threshold: threshold,
+		mode:      mode,
+		random:    rand.Float64,
+		logger:    logger,
+		exported:  map[string]bool{},
+	}
+}
+
+// Adds the message of the user and returns the new score of the user, from 0 to 1.
+// user identifies the user in the output, name is the login matched against the name patterns.
+func (s *Scorer) Score(user, name, message string, at time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, found := s.users[user]
+	if !found {
+		state = &userState{}
+		s.users[user] = state
+	}
+	state.messages++
+	state.lastSeen = at
+
+	cutoff := at.Add(-rateWindow)
+	state.times = slices.DeleteFunc(append(state.times, at), func(t time.Time) bool { return !t.After(cutoff) })
+	state.recent = append(state.recent, normalize(message))
+	state.links = append(state.links, linkPattern.MatchString(message))
+	if len(state.recent) > recentMessages {
+		state.recent = state.recent[1:]
+		state.links = state.links[1:]
+	}
+
+	state.features = Features{
+		Rate:       clamp(float64(len(state.times)-normalRate) / (botRate - normalRate)),
+		Repetition: repetition(state.recent),
+		Links:      float64(countTrue(state.links)) / float64(len(state.links)),
+		Name:       nameScore(name),
+	}
+	state.score = combine(state.features)
+	if knownBots[strings.ToLower(name)] {
+		state.score = 1
+	}
+	return state.score
+}
+
+// Whether a message with the score is sent for analysis, by the mode of the scorer
+func (s *Scorer) Keep(channel string, score float64) bool {
+	if score < s.threshold {
+		return true
+	}
+
+	keep := false
+	switch s.mode {
+	case ModeFlag:
+		keep = true
+	case ModeDownweight:
+		keep = s.random() >= score
+	}
+	result := "dropped"
+	if keep {
+		result = "kept"
+	}
+	botMessagesCounter.With(prometheus.Labels{"channel": channel, "result": result}).Inc()
+	return keep
+}
+
+// Flagged users seen since the TTL, highest score first
+func (s *Scorer) Top(limit int) []Suspect {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	suspects := []Suspect{}
+	for user, state := range s.users {
+		if state.score >= s.threshold {
+			suspects = append(suspects, Suspect{User: user, Score: state.score, Features: state.features, Messages: state.messages, LastSeen: state.lastSeen})
+		}
+	}
+	slices.SortFunc(suspects, func(a, b Suspect) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(b.Messages, a.Messages), cmp.Compare(a.User, b.User))
+	})
+	return suspects[:min(limit, len(suspects))]
+}
+
+// Forgets the users not seen since the TTL and updates the metrics of the flagged users
+func (s *Scorer) Start(ctx context.Context) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.update(now)
+		}
+	}
+}
+
+func (s *Scorer) update(now time.Time) {
+	s.mu.Lock()
+	flagged := 0
+	for user, state := range s.users {
+		if now.Sub(state.lastSeen) > userTTL {
+			delete(s.users, user)
+		} else if state.score >= s.threshold {
+			flagged++
+		}
+	}
+	s.mu.Unlock()
+
+	flaggedUsersGauge.Set(float64(flagged))
+
+	// Labels are only kept for the current top, so the series stay bounded
+	exported := map[string]bool{}
+	for _, suspect := range s.Top(topUsers) {
+		topFlaggedUserScoreGauge.With(prometheus.Labels{"user": suspect.User}).Set(suspect.Score)
+		exported[suspect.User] = true
+	}
+	for user := range s.exported {
+		if !exported[user] {
+			topFlaggedUserScoreGauge.DeleteLabelValues(user)
+		}
+	}
+	s.exported = exported
+}
+
+// Serves the top flagged users as JSON
+func (s *Scorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := topUsers
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		Mode      Mode      `json:"mode"`
+		Threshold float64   `json:"threshold"`
+		Suspects  []Suspect `json:"suspects"`
+	}{s.mode, s.threshold, s.Top(limit)})
+	if err != nil {
+		s.logger.Errorf("Failed to encode flagged users: %v", err)
+	}
+}
+
+// Combines the signals as independent evidence, so any strong one flags the user and weak ones add up
+func combine(f Features) float64 {
+	notBot := (1 - weights.Rate*f.Rate) * (1 - weights.Repetition*f.Repetition) * (1 - weights.Links*f.Links) * (1 - weights.Name*f.Name)
+	return 1 - notBot
+}
+
+// Share of the messages after the first that repeat an earlier one, 0 with fewer than 3 messages
+func repetition(messages []string) float64 {
+	if len(messages) < 3 {
+		return 0
+	}
+	repeated := 0
+	for i, message := range messages {
+		if slices.Contains(messages[:i], message) {
+			repeated++
+		}
+	}
+	return float64(repeated) / float64(len(messages)-1)
+}
+
+func nameScore(name string) float64 {
+	name = strings.ToLower(name)
+	if name == "" {
+		return 0
+	}
+	digits := 0
+	for _, r := range name {
+		if unicode.IsDigit(r) {
+			digits++
+		}
+	}
+
+	score := 0.0
+	if botNamePattern.MatchString(name) || float64(digits)/float64(len(name)) >= 0.5 {
+		score = 1
+	} else if digitSuffixPattern.MatchString(name) {
+		score = 0.6
+	}
+	return score
+}
+
+// Lowercase letters and digits of the message, so spam with other punctuation or spacing still repeats
+func normalize(message string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(message), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func countTrue(values []bool) int {
+	count := 0
+	for _, value := range values {
+		if value {
+			count++
+		}
+	}
+	return count
+}
+
+func clamp(value float64) float64 {
+	return max(0, min(1, value))
+}
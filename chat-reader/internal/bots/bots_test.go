@@ -0,0 +1,118 @@
+package bots
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = zap.NewNop().Sugar()
+}
+
+func TestScore(t *testing.T) {
+	scorer := NewScorer(logger)
+	start := time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC)
+
+	// A viewer chatting now and then is not flagged
+	var score float64
+	for i := range 5 {
+		score = scorer.Score("viewer", "viewer", fmt.Sprintf("que jogada %d", i), start.Add(time.Duration(i)*20*time.Second))
+	}
+	require.Zero(t, score)
+
+	// Repeating a link every few seconds is
+	for i := range 15 {
+		score = scorer.Score("spammer", "hoss00312", "Best viewers on www.example.shop !!", start.Add(time.Duration(i)*3*time.Second))
+	}
+	require.Greater(t, score, 0.95)
+	require.Equal(t, Features{Rate: 9.0 / 24, Repetition: 1, Links: 1, Name: 1}, scorer.users["spammer"].features)
+
+	// Known bots are always flagged
+	require.EqualValues(t, 1, scorer.Score("nightbot", "Nightbot", "Follow the channel", start))
+
+	top := scorer.Top(10)
+	require.Len(t, top, 2)
+	require.Equal(t, "nightbot", top[0].User)
+	require.Equal(t, "spammer", top[1].User)
+	require.EqualValues(t, 15, top[1].Messages)
+
+	// The top flagged users are exported until they are forgotten
+	scorer.update(start)
+	require.Equal(t, 2, testutil.CollectAndCount(topFlaggedUserScoreGauge))
+	require.EqualValues(t, 1, testutil.ToFloat64(topFlaggedUserScoreGauge.WithLabelValues("nightbot")))
+
+	// Users not seen for a while are forgotten
+	scorer.update(start.Add(userTTL + 2*time.Minute))
+	require.Empty(t, scorer.users)
+	require.Zero(t, testutil.CollectAndCount(topFlaggedUserScoreGauge))
+}
+
+func TestNameScore(t *testing.T) {
+	require.Zero(t, nameScore("gaules"))
+	require.Zero(t, nameScore("abbott"))
+	require.Equal(t, 0.6, nameScore("viewer2024"))
+	require.EqualValues(t, 1, nameScore("hoss00312"))
+	require.EqualValues(t, 1, nameScore("viewer_bot"))
+	require.EqualValues(t, 1, nameScore("x12345"))
+}
+
+func TestRepetition(t *testing.T) {
+	require.Zero(t, repetition([]string{"gg", "gg"}))
+	require.Equal(t, 0.5, repetition([]string{"gg", "wp", "gg"}))
+	require.Equal(t, "gg wp", normalize("GG,   wp!!!"))
+}
+
+func TestKeep(t *testing.T) {
+	t.Run("exclude", func(t *testing.T) {
+		scorer := NewScorer(logger)
+		require.True(t, scorer.Keep("gaules", 0.79))
+		before := testutil.ToFloat64(botMessagesCounter.WithLabelValues("gaules", "dropped"))
+		require.False(t, scorer.Keep("gaules", 0.8))
+		require.Equal(t, before+1, testutil.ToFloat64(botMessagesCounter.WithLabelValues("gaules", "dropped")))
+	})
+	t.Run("downweight", func(t *testing.T) {
+		t.Setenv("BOT_FILTER", "downweight")
+		scorer := NewScorer(logger)
+		scorer.random = func() float64 { return 0.9 }
+		require.True(t, scorer.Keep("gaules", 0.85))
+		require.False(t, scorer.Keep("gaules", 0.95))
+	})
+	t.Run("flag", func(t *testing.T) {
+		t.Setenv("BOT_FILTER", "flag")
+		require.True(t, NewScorer(logger).Keep("gaules", 1))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("BOT_SCORE_THRESHOLD", "2")
+		require.Panics(t, func() { NewScorer(logger) })
+	})
+}
+
+func TestServeHTTP(t *testing.T) {
+	scorer := NewScorer(logger)
+	scorer.Score("nightbot", "nightbot", "Follow the channel", time.Now())
+
+	recorder := httptest.NewRecorder()
+	scorer.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/bots?limit=5", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var body struct {
+		Mode     Mode      `json:"mode"`
+		Suspects []Suspect `json:"suspects"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	require.Equal(t, ModeExclude, body.Mode)
+	require.Len(t, body.Suspects, 1)
+
+	recorder = httptest.NewRecorder()
+	scorer.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/bots?limit=0", nil))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -15,13 +15,20 @@ var (
 	messagesCounter = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "kafka_messages_processed_total",
 	})
+	deletionsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_deletions_processed_total",
+	})
 )
 
+// How long a record waits in the buffer to be produced
+const produceTimeout = 1 * time.Second
+
 type Client struct {
 	client *kgo.Client
 	logger *zap.SugaredLogger
 
-	topic string
+	topic           string
+	moderationTopic string
 }
 
 func NewKafkaClient(logger *zap.SugaredLogger) *Client {
@@ -55,9 +62,10 @@ func NewKafkaClient(logger *zap.SugaredLogger) *Client {
 	}
 
 	return &Client{
-		client: cl,
-		logger: logger,
-		topic:  "messages",
+		client:          cl,
+		logger:          logger,
+		topic:           "messages",
+		moderationTopic: "moderation",
 	}
 }
 
@@ -71,8 +79,20 @@ func (c *Client) Cleanup() {
 }
 
 func (c *Client) AsyncProduce(ctx context.Context, value []byte) {
-	record := &kgo.Record{Topic: c.topic, Value: value}
+	c.produce(ctx, &kgo.Record{Topic: c.topic, Value: value}, messagesCounter)
+}
+
+// Produces a deletion tombstone to the moderation topic, keyed by the ID of the deleted message
+func (c *Client) AsyncProduceDeletion(ctx context.Context, messageID string, value []byte) {
+	c.produce(ctx, &kgo.Record{Topic: c.moderationTopic, Key: []byte(messageID), Value: value}, deletionsCounter)
+}
+
+// Buffers the record until the next flush, failing it when not produced within produceTimeout.
+// The timeout is released once the record is produced, canceling it earlier would fail the buffered record.
+func (c *Client) produce(ctx context.Context, record *kgo.Record, counter prometheus.Counter) {
+	ctx, cancel := context.WithTimeout(ctx, produceTimeout)
 	c.client.Produce(ctx, record, func(_ *kgo.Record, err error) {
+		cancel()
 		if err != nil {
 			if err == context.DeadlineExceeded {
 				c.logger.Debugf("Took to long to produce: %v", err)
@@ -80,7 +100,7 @@ func (c *Client) AsyncProduce(ctx context.Context, value []byte) {
 				c.logger.Errorf("Failed to produce record: %v\n", err)
 			}
 		} else {
-			messagesCounter.Inc()
+			counter.Inc()
 		}
 	})
 }
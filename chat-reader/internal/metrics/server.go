@@ -15,9 +15,11 @@ type MetricsServer struct {
 	server *http.Server
 }
 
-func NewMetricsServer(logger *zap.SugaredLogger) *MetricsServer {
+// Serves the metrics and, at /debug/bots, the users flagged as likely bots
+func NewMetricsServer(bots http.Handler, logger *zap.SugaredLogger) *MetricsServer {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("GET /debug/bots", bots)
 	server := &http.Server{Addr: ":8080", Handler: mux}
 
 	go func() {
@@ -1,6 +1,7 @@
 package reader
 
 import (
+	"chat-reader/internal/bots"
 	"chat-reader/internal/kafka"
 	"chat-reader/internal/metrics"
 	"chat-reader/internal/twitch"
@@ -13,9 +14,12 @@ import (
 
 func Start(ctx context.Context, logger *zap.SugaredLogger) {
 	messageChan := make(chan *twitch.Message)
+	deletionChan := make(chan *twitch.Deletion)
 	kafkaClient := kafka.NewKafkaClient(logger.Named("kafka-client"))
-	twitchClient := twitch.NewTwitchClient(messageChan, logger.Named("twitch-client"))
-	metricsServer := metrics.NewMetricsServer(logger.Named("metrics-server"))
+	scorer := bots.NewScorer(logger.Named("bots"))
+	go scorer.Start(ctx)
+	twitchClient := twitch.NewTwitchClient(messageChan, deletionChan, scorer, logger.Named("twitch-client"))
+	metricsServer := metrics.NewMetricsServer(scorer, logger.Named("metrics-server"))
 
 	flushTicker := time.NewTicker(1 * time.Second)
 
@@ -34,9 +38,7 @@ func Start(ctx context.Context, logger *zap.SugaredLogger) {
 				continue
 			}
 
-			ctx, stop := context.WithTimeout(context.Background(), 1*time.Second)
-			defer stop()
-			kafkaClient.Flush(ctx)
+			flush(kafkaClient)
 		case message := <-messageChan:
 			logger.Info(message)
 			b, err := json.Marshal(message)
@@ -46,14 +48,26 @@ func Start(ctx context.Context, logger *zap.SugaredLogger) {
 			}
 
 			if kafkaClient.BufferCount() > 100 {
-				ctx, stop := context.WithTimeout(context.Background(), 1*time.Second)
-				defer stop()
-				kafkaClient.Flush(ctx)
+				flush(kafkaClient)
 			}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-			defer cancel()
-			kafkaClient.AsyncProduce(ctx, b)
+			kafkaClient.AsyncProduce(context.Background(), b)
+		case deletion := <-deletionChan:
+			logger.Infof("Message %s of %s removed by %s", deletion.MessageID, deletion.Channel, deletion.Action)
+			b, err := json.Marshal(deletion)
+			if err != nil {
+				logger.Error("failed to encode deletion to JSON", err)
+				continue
+			}
+
+			kafkaClient.AsyncProduceDeletion(context.Background(), deletion.MessageID, b)
 		}
 	}
 }
+
+// Flushes the buffered records, waiting at most a second
+func flush(kafkaClient *kafka.Client) {
+	ctx, stop := context.WithTimeout(context.Background(), 1*time.Second)
+	defer stop()
+	kafkaClient.Flush(ctx)
+}
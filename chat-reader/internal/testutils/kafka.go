@@ -91,11 +91,14 @@ func CreateTopics(broker string) error {
 		adminClient = kadm.NewClient(client)
 	}
 
-	_, err := adminClient.CreateTopic(context.Background(), 1, 1,
+	responses, err := adminClient.CreateTopics(context.Background(), 1, 1,
 		map[string]*string{
 			"delete.retention.ms": kadm.StringPtr("60000"),
-		}, "messages",
+		}, "messages", "moderation",
 	)
+	if err == nil {
+		err = responses.Error()
+	}
 	if err != nil {
 		return errors.Errorf("failed to create topic: %v", err)
 	}
@@ -1,6 +1,7 @@
 package twitch
 
 import (
+	"chat-reader/internal/bots"
 	"errors"
 	"fmt"
 	"os"
@@ -28,6 +29,10 @@ type Message struct {
 	Channel   string `json:"channel"`
 	User      string `json:"user"`
 	Timestamp int64  `json:"timestamp"`
+	// How likely the user is a bot, from 0 to 1
+	BotScore float64 `json:"bot_score"`
+	// Names of the Twitch emotes removed from the message, counted by the trends
+	Emotes []string `json:"emotes"`
 }
 
 func (m *Message) String() string {
@@ -39,7 +44,8 @@ type Client struct {
 	logger *zap.SugaredLogger
 }
 
-func NewTwitchClient(messageChan chan *Message, logger *zap.SugaredLogger) *Client {
+// Sends the messages read from the channels to messageChan and the tombstones of the ones moderators remove to deletionChan
+func NewTwitchClient(messageChan chan *Message, deletionChan chan *Deletion, scorer *bots.Scorer, logger *zap.SugaredLogger) *Client {
 	channelsEnv, found := os.LookupEnv("TWITCH_CHANNELS")
 	if !found {
 		logger.Panic("Missing TWITCH_CHANNELS environment variable")
@@ -56,9 +62,20 @@ func NewTwitchClient(messageChan chan *Message, logger *zap.SugaredLogger) *Clie
 		logger.Panic("Should have at least 1 Twitch channel")
 	}
 
+	// When set, logins are replaced with their pseudonym before leaving the reader
+	salt := os.Getenv("USER_HASH_SALT")
+	if salt != "" {
+		logger.Info("Pseudonymizing users")
+	}
+
 	client := twitch.NewAnonymousClient()
+	recent := newRecentMessages(recentMessagesPerChannel)
 
 	client.OnPrivateMessage(func(message twitch.PrivateMessage) {
+		// Recorded in the read loop, so a ban read right after the message finds it.
+		// Messages filtered out below get tombstones too, which match no result.
+		recent.add(message.Channel, message.ID, message.User.Name)
+
 		go func() {
 			// prevent trash
 			if strings.HasPrefix(message.Message, "!") || strings.HasPrefix(message.Message, "@") || message.User.IsMod || message.User.IsBroadcaster {
@@ -66,22 +83,55 @@ func NewTwitchClient(messageChan chan *Message, logger *zap.SugaredLogger) *Clie
 			}
 
 			processedMessage := message.Message
+			emotes := []string{}
 			for _, v := range message.Emotes {
 				processedMessage = strings.ReplaceAll(processedMessage, v.Name, "")
+				emotes = append(emotes, v.Name)
 			}
 			processedMessage = strings.TrimSpace(processedMessage)
 			if len(processedMessage) == 0 {
 				return
 			}
 
+			user := message.User.Name
+			if salt != "" {
+				user = Pseudonymize(salt, user)
+			}
+			// Emotes are left out, viewers repeating the same emotes are not spamming
+			botScore := scorer.Score(user, message.User.Name, processedMessage, message.Time)
+
 			channelMessagesReadCounter.With(prometheus.Labels{"channel": message.Channel}).Inc()
+			if !scorer.Keep(message.Channel, botScore) {
+				return
+			}
 
 			messageChan <- &Message{
 				ID:        message.ID,
 				Channel:   message.Channel,
 				Message:   processedMessage,
 				Timestamp: message.Time.Unix(),
-				User:      message.User.Name,
+				User:      user,
+				BotScore:  botScore,
+				Emotes:    emotes,
+			}
+		}()
+	})
+
+	client.OnClearMessage(func(message twitch.ClearMessage) {
+		recent.remove(message.Channel, message.TargetMsgID)
+		deletion := &Deletion{MessageID: message.TargetMsgID, Channel: message.Channel, Action: ActionDelete, Timestamp: time.Now().Unix()}
+		channelMessagesModeratedCounter.With(prometheus.Labels{"channel": message.Channel, "action": string(deletion.Action)}).Inc()
+		go func() {
+			deletionChan <- deletion
+		}()
+	})
+
+	client.OnClearChatMessage(func(message twitch.ClearChatMessage) {
+		deletions := recent.clearChat(message.Channel, message.TargetUsername, message.BanDuration, message.Time.Unix())
+		go func() {
+			for _, deletion := range deletions {
+				channelMessagesModeratedCounter.With(prometheus.Labels{"channel": deletion.Channel, "action": string(deletion.Action)}).Inc()
+				deletionChan <- deletion
 			}
 		}()
 	})
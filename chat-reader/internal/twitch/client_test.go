@@ -1,6 +1,7 @@
 package twitch
 
 import (
+	"chat-reader/internal/bots"
 	"context"
 	"testing"
 	"time"
@@ -19,7 +20,7 @@ func TestClient(t *testing.T) {
 	t.Setenv("TWITCH_CHANNELS", "gaules,xqc,kaicenat,piratesoftware,summit1g")
 	messageChan := make(chan *Message)
 
-	client := NewTwitchClient(messageChan, logger)
+	client := NewTwitchClient(messageChan, make(chan *Deletion), bots.NewScorer(logger), logger)
 	assert.NotNil(t, client)
 
 	timeout := 10 * time.Second
@@ -47,7 +48,7 @@ func TestClient2(t *testing.T) {
 	t.Setenv("TWITCH_CHANNELS", "gaules,xqc,kaicenat,piratesoftware,summit1g")
 	messageChan := make(chan *Message)
 
-	client := NewTwitchClient(messageChan, logger)
+	client := NewTwitchClient(messageChan, make(chan *Deletion), bots.NewScorer(logger), logger)
 	assert.NotNil(t, client)
 
 	timeout := 10 * time.Second
@@ -75,14 +76,14 @@ func TestClientWithoutChannel(t *testing.T) {
 	messageChan := make(chan *Message)
 	t.Run("without env", func(t *testing.T) {
 		assert.Panics(t, func() {
-			NewTwitchClient(messageChan, logger)
+			NewTwitchClient(messageChan, make(chan *Deletion), bots.NewScorer(logger), logger)
 		})
 	})
 	t.Run("empty env", func(t *testing.T) {
 		t.Setenv("TWITCH_CHANNELS", "")
 
 		assert.Panics(t, func() {
-			NewTwitchClient(messageChan, logger)
+			NewTwitchClient(messageChan, make(chan *Deletion), bots.NewScorer(logger), logger)
 		})
 	})
 }
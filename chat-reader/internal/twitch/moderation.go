@@ -0,0 +1,114 @@
+package twitch
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Messages remembered per channel, so bans and timeouts can be turned into the IDs of the messages they remove
+const recentMessagesPerChannel = 5000
+
+var (
+	channelMessagesModeratedCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "twitch_messages_moderated_total",
+		},
+		[]string{"channel", "action"},
+	)
+)
+
+type ModerationAction string
+
+const (
+	// A moderator deleted the message (CLEARMSG)
+	ActionDelete ModerationAction = "delete"
+	// The user was timed out or banned (CLEARCHAT with a user)
+	ActionTimeout ModerationAction = "timeout"
+	ActionBan     ModerationAction = "ban"
+)
+
+// Tombstone of a message removed by a moderator
+type Deletion struct {
+	MessageID string           `json:"message_id"`
+	Channel   string           `json:"channel"`
+	Action    ModerationAction `json:"action"`
+	Timestamp int64            `json:"timestamp"`
+}
+
+type recentMessage struct {
+	id, user string
+}
+
+// Last messages read from each channel, oldest first
+type recentMessages struct {
+	mu       sync.Mutex
+	size     int
+	channels map[string][]recentMessage
+}
+
+func newRecentMessages(size int) *recentMessages {
+	return &recentMessages{size: size, channels: map[string][]recentMessage{}}
+}
+
+func (r *recentMessages) add(channel, id, user string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := append(r.channels[channel], recentMessage{id: id, user: user})
+	if len(messages) > r.size {
+		messages = messages[len(messages)-r.size:]
+	}
+	r.channels[channel] = messages
+}
+
+// Forgets and returns the IDs of the messages of the user in the channel
+func (r *recentMessages) take(channel, user string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := []string{}
+	kept := r.channels[channel][:0]
+	for _, message := range r.channels[channel] {
+		if message.user == user {
+			ids = append(ids, message.id)
+		} else {
+			kept = append(kept, message)
+		}
+	}
+	r.channels[channel] = kept
+	return ids
+}
+
+// Forgets the message, it was deleted on its own
+func (r *recentMessages) remove(channel, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := r.channels[channel]
+	for i, message := range messages {
+		if message.id == id {
+			r.channels[channel] = append(messages[:i], messages[i+1:]...)
+			return
+		}
+	}
+}
+
+// Tombstones of the messages removed by a CLEARCHAT, a ban when it has no duration.
+// Clearing the whole chat, without a user, redacts nothing: moderators use it to tidy the chat, not to remove what was said.
+func (r *recentMessages) clearChat(channel, user string, banDuration int, timestamp int64) []*Deletion {
+	if user == "" {
+		return []*Deletion{}
+	}
+	action := ActionTimeout
+	if banDuration == 0 {
+		action = ActionBan
+	}
+
+	deletions := []*Deletion{}
+	for _, id := range r.take(channel, user) {
+		deletions = append(deletions, &Deletion{MessageID: id, Channel: channel, Action: action, Timestamp: timestamp})
+	}
+	return deletions
+}
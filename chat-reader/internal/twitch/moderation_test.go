@@ -0,0 +1,37 @@
+package twitch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecentMessages(t *testing.T) {
+	recent := newRecentMessages(3)
+	recent.add("gaules", "1", "viewer")
+	recent.add("gaules", "2", "troll")
+	recent.add("gaules", "3", "troll")
+	recent.add("gaules", "4", "viewer")
+	recent.add("xqc", "5", "troll")
+
+	// The oldest message was forgotten
+	deletions := recent.clearChat("gaules", "troll", 600, 100)
+	require.Equal(t, []*Deletion{
+		{MessageID: "2", Channel: "gaules", Action: ActionTimeout, Timestamp: 100},
+		{MessageID: "3", Channel: "gaules", Action: ActionTimeout, Timestamp: 100},
+	}, deletions)
+	// Messages are only removed once
+	require.Empty(t, recent.clearChat("gaules", "troll", 0, 100))
+
+	deletions = recent.clearChat("xqc", "troll", 0, 100)
+	require.Len(t, deletions, 1)
+	require.Equal(t, ActionBan, deletions[0].Action)
+
+	// Clearing the whole chat redacts nothing
+	require.Empty(t, recent.clearChat("gaules", "", 0, 100))
+	recent.remove("gaules", "4")
+	recent.add("gaules", "6", "viewer")
+	deletions = recent.clearChat("gaules", "viewer", 0, 100)
+	require.Len(t, deletions, 1)
+	require.Equal(t, "6", deletions[0].MessageID)
+}
@@ -0,0 +1,15 @@
+package twitch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Pseudonym of a Twitch login, the website computes the same one to find the rows of a user
+func Pseudonymize(salt, user string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(strings.ToLower(user)))
+	return "u_" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
@@ -0,0 +1,16 @@
+package twitch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPseudonymize(t *testing.T) {
+	pseudonym := Pseudonymize("salt", "Viewer_1")
+	require.Equal(t, Pseudonymize("salt", "viewer_1"), pseudonym)
+	require.NotEqual(t, Pseudonymize("other", "viewer_1"), pseudonym)
+	require.Regexp(t, `^u_[0-9a-f]{32}$`, pseudonym)
+	// Same vector as the website, so both find the same rows
+	require.Equal(t, "u_8e8ffd03fc42068a226227df758871aa", pseudonym)
+}
@@ -33,8 +33,9 @@ class DatabaseWriter:
         insert_query = sql.SQL("""
             INSERT INTO results (
                 channel, "user", message_id, "timestamp", message, 
-                sentiment_positive, sentiment_neutral, sentiment_negative
+                sentiment_positive, sentiment_neutral, sentiment_negative, bot_score, emotes
             ) VALUES %s
+            ON CONFLICT DO NOTHING
         """)
 
         values = [
@@ -46,15 +47,44 @@ class DatabaseWriter:
                 result['message'],
                 result['sentiment_positive'],
                 result['sentiment_neutral'],
-                result['sentiment_negative']
+                result['sentiment_negative'],
+                result['bot_score'],
+                result['emotes']
             )
             for result in results
         ]
 
         try:
-            execute_values(self.cursor, insert_query, values, template='(%s, %s, %s, to_timestamp(%s), %s, %s, %s, %s)')
+            execute_values(self.cursor, insert_query, values, template='(%s, %s, %s, to_timestamp(%s), %s, %s, %s, %s, %s, %s::TEXT[])')
             self.connection.commit()
             self.logger.info(f"Inserted {len(results)} records.")
         except Exception as e:
             self.logger.error(f"Error inserting multiple records: {e}")
             self.connection.rollback()
+
+    def insert_deletions(self, deletions: List[Dict]):
+        self.logger.info(f"Inserting {len(deletions)} deletions to database.")
+        insert_query = sql.SQL("""
+            INSERT INTO moderated_messages (
+                channel, message_id, action, moderated_at
+            ) VALUES %s
+            ON CONFLICT DO NOTHING
+        """)
+
+        values = [
+            (
+                deletion['channel'],
+                deletion['message_id'],
+                deletion['action'],
+                deletion['timestamp']
+            )
+            for deletion in deletions
+        ]
+
+        try:
+            execute_values(self.cursor, insert_query, values, template='(%s, %s, %s, to_timestamp(%s))')
+            self.connection.commit()
+            self.logger.info(f"Inserted {len(deletions)} deletions.")
+        except Exception as e:
+            self.logger.error(f"Error inserting multiple deletions: {e}")
+            self.connection.rollback()
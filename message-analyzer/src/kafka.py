@@ -1,15 +1,16 @@
 import logging
 import json
 import threading
-from typing import Generator, List
+from typing import Generator, List, Tuple
 from confluent_kafka import Consumer, KafkaError
-from model import Message
+from model import Deletion, Message
 
 class KafkaConsumerService:
-    def __init__(self, config: dict[str, any], shutdown_event: threading.Event, topic: str):
+    def __init__(self, config: dict[str, any], shutdown_event: threading.Event, topic: str, moderation_topic: str):
         self.logger = logging.getLogger(__name__)
         self.shutdown_event = shutdown_event
         self.topic = topic
+        self.moderation_topic = moderation_topic
         self.logger.info("Starting consumer")
         self.consumer = Consumer(config)
 
@@ -19,10 +20,16 @@ class KafkaConsumerService:
         except json.JSONDecodeError as e:
             raise Exception(f"Failed to decode JSON: {message}") from e
 
-    def consume(self) -> Generator[List[Message], None, None]:
+    def process_deletion(self, deletion: str) -> Deletion:
         try:
-            self.logger.info(f"Subscribing to {self.topic}")
-            self.consumer.subscribe([self.topic])
+            return Deletion.from_dict(json.loads(deletion))
+        except (json.JSONDecodeError, KeyError) as e:
+            raise Exception(f"Failed to decode deletion: {deletion}") from e
+
+    def consume(self) -> Generator[Tuple[List[Message], List[Deletion]], None, None]:
+        try:
+            self.logger.info(f"Subscribing to {self.topic} and {self.moderation_topic}")
+            self.consumer.subscribe([self.topic, self.moderation_topic])
 
             while not self.shutdown_event.is_set():
                 msgs = self.consumer.consume(num_messages=100, timeout=1.0)
@@ -34,6 +41,7 @@ class KafkaConsumerService:
                     continue
 
                 messages = []
+                deletions = []
                 for msg in msgs:
                     if msg is None:
                         continue
@@ -46,12 +54,15 @@ class KafkaConsumerService:
                             continue
                     else:
                         try:
-                            messages.append(self.process_message(msg.value().decode('utf-8')))
+                            if msg.topic() == self.moderation_topic:
+                                deletions.append(self.process_deletion(msg.value().decode('utf-8')))
+                            else:
+                                messages.append(self.process_message(msg.value().decode('utf-8')))
                         except Exception as e:
                             self.logger.info(e)
                             continue
 
-                yield messages
+                yield messages, deletions
                 
         except Exception as e:
             self.logger.error(f"Error in consumer loop: {e}")
@@ -30,7 +30,7 @@ if __name__ == "__main__":
             'bootstrap.servers': os.getenv("KAFKA_BROKER_HOST"),
             'group.id': 'analyzer',
             'auto.offset.reset': 'smallest'
-        }, topic="messages", shutdown_event=shutdown_event)
+        }, topic="messages", moderation_topic="moderation", shutdown_event=shutdown_event)
         analyzer = SentimentAnalyzer()
         writer = DatabaseWriter({
             'dbname': os.getenv("DATABASE_DATABASE"),
@@ -39,24 +39,31 @@ if __name__ == "__main__":
             'host': os.getenv("DATABASE_HOST"),
             'port': os.getenv("DATABASE_PORT")
         })
-        for messages in service.consume():
-            logger.info(f"Received {len(messages)} messages")
-            result = analyzer.analyze([msg.message for msg in messages])
-            writer.insert_results(
-                [
-                {
-                    'channel': messages[i].channel,
-                    'user': messages[i].user,
-                    'id': messages[i].id,
-                    'timestamp': messages[i].timestamp,
-                    'message': messages[i].message,
-                    'sentiment_positive': result[i].positive,
-                    'sentiment_neutral': result[i].neutral,
-                    'sentiment_negative': result[i].negative,
-                }
-                for i in range(len(messages))
-            ]
-            )
+        for messages, deletions in service.consume():
+            if messages:
+                logger.info(f"Received {len(messages)} messages")
+                result = analyzer.analyze([msg.message for msg in messages])
+                writer.insert_results(
+                    [
+                    {
+                        'channel': messages[i].channel,
+                        'user': messages[i].user,
+                        'id': messages[i].id,
+                        'timestamp': messages[i].timestamp,
+                        'message': messages[i].message,
+                        'sentiment_positive': result[i].positive,
+                        'sentiment_neutral': result[i].neutral,
+                        'sentiment_negative': result[i].negative,
+                        'bot_score': messages[i].bot_score,
+                        'emotes': messages[i].emotes,
+                    }
+                    for i in range(len(messages))
+                ]
+                )
+            # The results of deleted messages are redacted by the database, whether they are stored before or after
+            if deletions:
+                logger.info(f"Received {len(deletions)} deletions")
+                writer.insert_deletions([vars(deletion) for deletion in deletions])
     except Exception as e:
         logger.error(f"Fatal error: {e}")
     finally:
@@ -1,5 +1,5 @@
-from dataclasses import dataclass
-from typing import Any, Dict
+from dataclasses import dataclass, field
+from typing import Any, Dict, List
 
 @dataclass
 class Message:
@@ -8,6 +8,10 @@ class Message:
     channel: str
     user: str
     timestamp: int
+    # How likely the user is a bot, from 0 to 1, scored by the chat reader
+    bot_score: float = 0.0
+    # Names of the Twitch emotes removed from the message by the chat reader
+    emotes: List[str] = field(default_factory=list)
 
     @staticmethod
     def from_dict(data: dict[str, Any]) -> "Message":
@@ -16,7 +20,9 @@ class Message:
             message=data["message"],
             channel=data["channel"],
             user=data["user"],
-            timestamp=data["timestamp"]
+            timestamp=data["timestamp"],
+            bot_score=data.get("bot_score", 0.0),
+            emotes=data.get("emotes") or []
         )
 
     def to_dict(self) -> dict[str, Any]:
@@ -25,7 +31,9 @@ class Message:
             "message": self.message,
             "channel": self.channel,
             "user": self.user,
-            "timestamp": self.timestamp
+            "timestamp": self.timestamp,
+            "bot_score": self.bot_score,
+            "emotes": self.emotes
         }
     
     def __str__(self) -> str:
@@ -34,7 +42,25 @@ class Message:
             f"message='{self.message}', "
             f"channel='{self.channel}', "
             f"user='{self.user}', "
-            f"timestamp={self.timestamp})"
+            f"timestamp={self.timestamp}, "
+            f"bot_score={self.bot_score:.2f})"
+        )
+
+@dataclass
+class Deletion:
+    """Tombstone of a message removed by a moderator"""
+    message_id: str
+    channel: str
+    action: str
+    timestamp: int
+
+    @staticmethod
+    def from_dict(data: dict[str, Any]) -> "Deletion":
+        return Deletion(
+            message_id=data["message_id"],
+            channel=data["channel"],
+            action=data["action"],
+            timestamp=data["timestamp"]
         )
 
 @dataclass
@@ -1,14 +1,31 @@
 package main
 
 import (
+	"bufio"
+	"cmp"
 	"context"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+	"website/internal/auth"
 	"website/internal/database"
+	"website/internal/export"
+	"website/internal/integrity"
 	"website/internal/logger"
+	"website/internal/maintenance"
+	"website/internal/migrate"
+	"website/internal/privacy"
+	"website/internal/rollup"
 	"website/internal/server"
 	"website/internal/service"
+
+	"go.uber.org/zap"
 )
 
 func main() {
@@ -18,8 +35,319 @@ func main() {
 	logger := logger.NewLogger()
 	defer logger.Sync()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "maintenance":
+			runMaintenance(ctx, os.Args[2:], logger)
+		case "migrate":
+			runMigrate(ctx, os.Args[2:], logger)
+		case "check":
+			runCheck(ctx, os.Args[2:], logger)
+		case "export":
+			runExport(ctx, os.Args[2:], logger)
+		case "keys":
+			runKeys(ctx, os.Args[2:], logger)
+		case "users":
+			runUsers(ctx, os.Args[2:], logger)
+		default:
+			logger.Fatalf("Unknown command %q, expected maintenance, migrate, check, export, keys or users", os.Args[1])
+		}
+		return
+	}
+
 	conn := database.NewDatabaseConnection(logger.Named("database"))
 	resultsService := service.NewResultsService(conn, logger.Named("results-service"))
 
-	server.Start(ctx, logger, resultsService)
+	go rollup.NewJob(conn, logger.Named("rollup")).Start(ctx)
+
+	server.Start(ctx, logger, conn, resultsService)
+}
+
+// Applies the retention of results and rollups, see "website maintenance -h"
+func runMaintenance(ctx context.Context, args []string, logger *zap.SugaredLogger) {
+	config := maintenance.DefaultConfig()
+	flags := flag.NewFlagSet("maintenance", flag.ExitOnError)
+	flags.BoolVar(&config.DryRun, "dry-run", false, "report what would be done without changing anything")
+	flags.IntVar(&config.RawDays, "raw-days", config.RawDays, "days of raw results kept")
+	flags.IntVar(&config.PremakeDays, "premake-days", config.PremakeDays, "days of partitions created ahead of time")
+	rollupDays := map[string]*int{}
+	for _, table := range []string{"results_rollup_1m", "results_rollup_1h", "results_rollup_1d"} {
+		name := strings.Replace(strings.TrimPrefix(table, "results_"), "_", "-", 1) + "-days"
+		rollupDays[table] = flags.Int(name, config.RollupDays[table], fmt.Sprintf("days of %s kept, 0 keeps them forever", table))
+	}
+	flags.Parse(args)
+	for table, days := range rollupDays {
+		config.RollupDays[table] = *days
+	}
+	if config.RawDays < 1 || config.PremakeDays < 0 {
+		logger.Fatal("raw-days must be at least 1 and premake-days can not be negative")
+	}
+
+	conn := database.NewDatabaseConnection(logger.Named("database"))
+	report, err := maintenance.Run(ctx, conn, config, time.Now(), logger.Named("maintenance"))
+	if report.DryRun {
+		fmt.Println("Dry run, nothing was changed")
+	}
+	for _, action := range report.Actions {
+		fmt.Println(action)
+	}
+	if err != nil {
+		logger.Fatalf("Maintenance failed: %v", err)
+	}
+}
+
+// Shows or changes the schema version with "website migrate status|up|down|to N"
+func runMigrate(ctx context.Context, args []string, logger *zap.SugaredLogger) {
+	usage := "Usage: website migrate status|up|down|to N"
+	var target int64
+	if len(args) == 2 && args[0] == "to" {
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			logger.Fatalf("Invalid version %q", args[1])
+		}
+		target = version
+	} else if len(args) != 1 || !slices.Contains([]string{"status", "up", "down"}, args[0]) {
+		logger.Fatal(usage)
+	}
+
+	conn := database.NewDatabaseConnection(logger.Named("database"))
+	migrator := migrate.New(conn, logger.Named("migrate"))
+
+	var steps []migrate.Step
+	var err error
+	switch args[0] {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			logger.Fatalf("Failed to read migrations: %v", err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied at " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%s %s\n", status.Migration, applied)
+		}
+		return
+	case "up":
+		steps, err = migrator.Up(ctx)
+	case "down":
+		steps, err = migrator.Down(ctx)
+	case "to":
+		steps, err = migrator.To(ctx, target)
+	}
+
+	for _, step := range steps {
+		fmt.Println(step)
+	}
+	if err != nil {
+		logger.Fatalf("Migration failed: %v", err)
+	}
+	if len(steps) == 0 {
+		fmt.Println("Nothing to migrate")
+	}
+}
+
+// Reports the results stored more than once, exiting with status 1 if there are any
+func runCheck(ctx context.Context, args []string, logger *zap.SugaredLogger) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	limit := flags.Int("limit", 20, "duplicated messages listed")
+	flags.Parse(args)
+	if *limit < 1 {
+		logger.Fatal("limit must be at least 1")
+	}
+
+	conn := database.NewDatabaseConnection(logger.Named("database"))
+	report, err := integrity.FindDuplicates(ctx, conn, *limit)
+	if err != nil {
+		logger.Fatalf("Check failed: %v", err)
+	}
+
+	fmt.Println(report)
+	for _, duplicate := range report.Duplicates {
+		fmt.Println(duplicate)
+	}
+	if report.Messages > 0 {
+		os.Exit(1)
+	}
+}
+
+// Writes the results or averages of a channel to a file, see "website export -h"
+func runExport(ctx context.Context, args []string, logger *zap.SugaredLogger) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	channel := flags.String("channel", "", "channel exported (required)")
+	dataset := flags.String("dataset", string(export.DatasetResults), "rows exported: results or averages")
+	format := flags.String("format", string(export.FormatCSV), "file format: csv, ndjson or parquet")
+	bucket := flags.String("bucket", string(service.BucketMinute), "bucket of the averages: minute, hour or day")
+	from := flags.String("from", "", "RFC 3339 start of the range, defaults to one hour before -to")
+	to := flags.String("to", "", "RFC 3339 end of the range, defaults to now")
+	compress := flags.Bool("gzip", false, "compress the file with gzip")
+	output := flags.String("o", "", `file written, "-" for standard output, defaults to a name after the export`)
+	flags.Parse(args)
+
+	request := export.Request{Channel: *channel, To: time.Now(), Gzip: *compress}
+	if *channel == "" {
+		logger.Fatal("channel is required")
+	}
+	var err error
+	if request.Dataset, err = export.ParseDataset(*dataset); err != nil {
+		logger.Fatal(err)
+	}
+	if request.Format, err = export.ParseFormat(*format); err != nil {
+		logger.Fatal(err)
+	}
+	if request.Bucket, err = service.ParseBucket(*bucket); err != nil {
+		logger.Fatal(err)
+	}
+	if *to != "" {
+		if request.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			logger.Fatalf("Invalid to %q, expected an RFC 3339 timestamp", *to)
+		}
+	}
+	request.From = request.To.Add(-time.Hour)
+	if *from != "" {
+		if request.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			logger.Fatalf("Invalid from %q, expected an RFC 3339 timestamp", *from)
+		}
+	}
+	if !request.From.Before(request.To) {
+		logger.Fatal("from must be before to")
+	}
+
+	file := os.Stdout
+	if *output != "-" {
+		if *output == "" {
+			*output = request.Filename()
+		}
+		if file, err = os.Create(*output); err != nil {
+			logger.Fatalf("Failed to create %s: %v", *output, err)
+		}
+	}
+	writer := bufio.NewWriter(file)
+
+	conn := database.NewDatabaseConnection(logger.Named("database"))
+	err = export.Export(ctx, service.NewResultsService(conn, logger.Named("results-service")), writer, request)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Fatalf("Export failed: %v", err)
+	}
+	if file != os.Stdout {
+		logger.Infof("Exported %s", *output)
+	}
+}
+
+// Manages the API keys with "website keys create|list|revoke", see "website keys create -h"
+func runKeys(ctx context.Context, args []string, logger *zap.SugaredLogger) {
+	usage := "Usage: website keys create -name NAME [-scopes read,admin] [-rate N] [-burst N] | list | revoke NAME"
+	if len(args) == 0 {
+		logger.Fatal(usage)
+	}
+
+	var store *auth.Store
+	connect := func() {
+		store = auth.NewStore(database.NewDatabaseConnection(logger.Named("database")))
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("keys create", flag.ExitOnError)
+		name := flags.String("name", "", "unique name of the key (required)")
+		scopeList := flags.String("scopes", string(auth.ScopeRead), "comma separated scopes: read, admin")
+		rate := flags.Float64("rate", 5, "requests per second")
+		burst := flags.Int("burst", 20, "requests allowed at once")
+		flags.Parse(args[1:])
+		scopes, err := auth.ParseScopes(*scopeList)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if *name == "" || len(*name) > 100 || *rate <= 0 || *burst < 1 {
+			logger.Fatal("name is required and up to 100 characters, rate must be positive and burst at least 1")
+		}
+
+		connect()
+		key, secret, err := store.Create(ctx, *name, scopes, *rate, *burst)
+		if err != nil {
+			logger.Fatalf("Failed to create key: %v", err)
+		}
+		fmt.Printf("Created key %s with scopes %v, it is not shown again:\n%s\n", key.Name, key.Scopes, secret)
+	case "list":
+		connect()
+		keys, err := store.List(ctx)
+		if err != nil {
+			logger.Fatalf("Failed to list keys: %v", err)
+		}
+		for _, key := range keys {
+			status := "never used"
+			if key.RevokedAt != nil {
+				status = "revoked at " + key.RevokedAt.Format(time.RFC3339)
+			} else if key.LastUsed != nil {
+				status = "last used at " + key.LastUsed.Format(time.RFC3339)
+			}
+			fmt.Printf("%s %s... scopes %v, %g requests/s, burst %d, %s\n", key.Name, key.Prefix, key.Scopes, key.RateLimit, key.Burst, status)
+		}
+	case "revoke":
+		if len(args) != 2 {
+			logger.Fatal(usage)
+		}
+		connect()
+		if err := store.Revoke(ctx, args[1]); err != nil {
+			logger.Fatalf("Failed to revoke key: %v", err)
+		}
+		fmt.Printf("Revoked key %s\n", args[1])
+	default:
+		logger.Fatal(usage)
+	}
+}
+
+// Deletes or pseudonymizes the results of a user with "website users delete|pseudonymize|audit", see "website users delete -h"
+func runUsers(ctx context.Context, args []string, logger *zap.SugaredLogger) {
+	usage := "Usage: website users delete|pseudonymize [-reason REASON] USER | audit"
+	if len(args) == 0 {
+		logger.Fatal(usage)
+	}
+
+	var eraser *privacy.Eraser
+	connect := func() {
+		eraser = privacy.NewEraser(database.NewDatabaseConnection(logger.Named("database")), logger.Named("privacy"))
+	}
+
+	switch args[0] {
+	case "delete", "pseudonymize":
+		flags := flag.NewFlagSet("users "+args[0], flag.ExitOnError)
+		reason := flags.String("reason", "", "why the data is changed, kept in the audit log")
+		actor := flags.String("actor", "cli", "who asked for the change, kept in the audit log")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 || flags.Arg(0) == "" || len(flags.Arg(0)) > 100 {
+			logger.Fatal(usage)
+		}
+
+		connect()
+		erase := eraser.Delete
+		if args[0] == "pseudonymize" {
+			erase = eraser.Pseudonymize
+		}
+		entry, err := erase(ctx, flags.Arg(0), *actor, *reason)
+		if err != nil {
+			logger.Fatalf("Failed to %s user: %v", args[0], err)
+		}
+		fmt.Printf("Applied %s to %d results, audit entry %d\n", entry.Action, entry.Rows, entry.ID)
+	case "audit":
+		connect()
+		entries, err := eraser.Audit(ctx)
+		if err != nil {
+			logger.Fatalf("Failed to list the audit log: %v", err)
+		}
+		for _, entry := range entries {
+			// Entries made without a salt do not name the user
+			subject := cmp.Or(entry.Subject, "-")
+			fmt.Printf("%d %s %s %s of %s, %d results: %s\n", entry.ID, entry.CreatedAt.Format(time.RFC3339), entry.Actor, entry.Action, subject, entry.Rows, entry.Reason)
+		}
+	default:
+		logger.Fatal(usage)
+	}
 }
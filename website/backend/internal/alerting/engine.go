@@ -0,0 +1,276 @@
+package alerting
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+	"website/internal/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	deliveryQueueSize = 100
+	deliveryAttempts  = 3
+	deliveryBackoff   = time.Second
+	webhookTimeout    = 10 * time.Second
+	// Results of a minute may be stored after it ends, it is evaluated once this much time has passed
+	defaultLag = time.Minute
+)
+
+var (
+	alertsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alerts_fired_total",
+	}, []string{"rule"})
+
+	deliveriesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_webhook_deliveries_total",
+	}, []string{"result"})
+)
+
+// Evaluates the alert rules every minute and delivers the alerts that fire to the webhooks.
+// Rules are only evaluated by the goroutine calling Due and Evaluate.
+type Engine struct {
+	rules      []Rule
+	webhooks   []Webhook
+	client     *http.Client
+	logger     *zap.SugaredLogger
+	deliveries chan Alert
+	// Wait before the first retry of a delivery, growing with each attempt
+	backoff time.Duration
+	// How long after a minute ends it is evaluated
+	lag time.Duration
+
+	// Last minute evaluated
+	evaluated time.Time
+	// Rules firing for each channel, that do not fire again until they stop
+	firing map[ruleChannel]bool
+	// Minute each rule last fired for each channel, forgotten after the cooldown
+	fired map[ruleChannel]time.Time
+}
+
+type ruleChannel struct {
+	rule, channel string
+}
+
+// Firing and cooldown state of a rule for a channel, saved so a new leader does not fire the same alerts again
+type RuleState struct {
+	Rule    string `db:"rule"`
+	Channel string `db:"channel"`
+	Firing  bool   `db:"firing"`
+	// Minute the rule last fired for the channel
+	FiredAt time.Time `db:"fired_at"`
+}
+
+// Loads the rules and webhooks of the ALERT_RULES_FILE config, alerting is disabled when it is not set
+func NewEngine(logger *zap.SugaredLogger) *Engine {
+	e := &Engine{
+		client:     &http.Client{Timeout: webhookTimeout},
+		logger:     logger,
+		deliveries: make(chan Alert, deliveryQueueSize),
+		backoff:    deliveryBackoff,
+		lag:        defaultLag,
+		firing:     map[ruleChannel]bool{},
+		fired:      map[ruleChannel]time.Time{},
+	}
+
+	if value, found := os.LookupEnv("ALERT_LAG"); found {
+		lag, err := time.ParseDuration(value)
+		if err != nil || lag < 0 {
+			logger.Fatalf("Invalid ALERT_LAG %q", value)
+		}
+		e.lag = lag
+	}
+
+	path, found := os.LookupEnv("ALERT_RULES_FILE")
+	if !found {
+		return e
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		logger.Fatalf("Invalid alert rules in %s: %v", path, err)
+	}
+	e.rules, e.webhooks = config.Rules, config.Webhooks
+	logger.Infof("Loaded %d alert rules and %d webhooks", len(e.rules), len(e.webhooks))
+	return e
+}
+
+func (e *Engine) Enabled() bool {
+	return len(e.rules) > 0
+}
+
+// Last minute that ended more than the lag before now and the start of the averages needed to evaluate it,
+// false if it was already evaluated
+func (e *Engine) Due(now time.Time) (minute time.Time, from time.Time, due bool) {
+	minute = now.Add(-e.lag).Truncate(time.Minute).Add(-time.Minute)
+	if !e.Enabled() || !minute.After(e.evaluated) {
+		return minute, minute, false
+	}
+	from = minute
+	for _, rule := range e.rules {
+		if start := minute.Add(-time.Duration(rule.BaselineMinutes) * time.Minute); start.Before(from) {
+			from = start
+		}
+	}
+	return minute, from, true
+}
+
+// Evaluates every rule for the minute, given the per minute averages of each channel.
+// A rule fires once while it keeps matching, and not before its cooldown since it last fired.
+func (e *Engine) Evaluate(minute time.Time, averages map[string][]service.AverageResult) []Alert {
+	e.evaluated = minute
+
+	alerts := []Alert{}
+	for _, rule := range e.rules {
+		channels := rule.Channels
+		if len(channels) == 0 {
+			channels = slices.Sorted(maps.Keys(averages))
+		}
+		for _, channel := range channels {
+			key := ruleChannel{rule: rule.Name, channel: channel}
+			alert, matches := rule.evaluate(channel, minute, averages[channel])
+			if !matches {
+				delete(e.firing, key)
+				if last, found := e.fired[key]; found && minute.Sub(last) >= time.Duration(rule.Cooldown) {
+					delete(e.fired, key)
+				}
+				continue
+			}
+			if e.firing[key] {
+				continue
+			}
+			if last, found := e.fired[key]; found && minute.Sub(last) < time.Duration(rule.Cooldown) {
+				continue
+			}
+			e.firing[key], e.fired[key] = true, minute
+			alertsCounter.WithLabelValues(rule.Name).Inc()
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// Firing and cooldown state of every rule and channel, to be restored by the next leader
+func (e *Engine) State() []RuleState {
+	states := []RuleState{}
+	for key, firedAt := range e.fired {
+		states = append(states, RuleState{Rule: key.rule, Channel: key.channel, Firing: e.firing[key], FiredAt: firedAt})
+	}
+	slices.SortFunc(states, func(a, b RuleState) int {
+		return cmp.Or(cmp.Compare(a.Rule, b.Rule), cmp.Compare(a.Channel, b.Channel))
+	})
+	return states
+}
+
+// Replaces the firing and cooldown state, like the one saved by the previous leader
+func (e *Engine) Restore(states []RuleState) {
+	clear(e.firing)
+	clear(e.fired)
+	for _, state := range states {
+		key := ruleChannel{rule: state.Rule, channel: state.Channel}
+		e.fired[key] = state.FiredAt
+		if state.Firing {
+			e.firing[key] = true
+		}
+	}
+}
+
+// Queues the alerts for the webhooks, dropping them if the queue is full
+func (e *Engine) Deliver(alerts []Alert) {
+	for _, alert := range alerts {
+		select {
+		case e.deliveries <- alert:
+		default:
+			e.logger.Errorf("Delivery queue is full, dropping alert %s", alert)
+			deliveriesCounter.WithLabelValues("dropped").Inc()
+		}
+	}
+}
+
+// Delivers the queued alerts until the context is done
+func (e *Engine) Start(ctx context.Context) {
+	for {
+		select {
+		case alert := <-e.deliveries:
+			for _, webhook := range e.webhooks {
+				if err := e.send(ctx, webhook, alert); err != nil {
+					e.logger.Errorf("Failed to deliver alert %s: %v", alert, err)
+					deliveriesCounter.WithLabelValues("failure").Inc()
+				} else {
+					deliveriesCounter.WithLabelValues("success").Inc()
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Posts the alert to the webhook, retrying server errors and rate limits
+func (e *Engine) send(ctx context.Context, webhook Webhook, alert Alert) error {
+	var payload any
+	switch webhook.Format {
+	case FormatSlack:
+		payload = map[string]string{"text": alert.String()}
+	default:
+		payload = map[string]string{"content": alert.String()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = e.post(ctx, webhook.URL, body)
+		if err == nil {
+			return nil
+		}
+		var retryable retryableError
+		if !errors.As(err, &retryable) || attempt == deliveryAttempts {
+			return err
+		}
+		e.logger.Warnf("Retrying alert delivery after attempt %d: %v", attempt, err)
+
+		select {
+		case <-time.After(e.backoff * time.Duration(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type retryableError struct {
+	error
+}
+
+func (e *Engine) post(ctx context.Context, url string, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return retryableError{err}
+	}
+	response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		return retryableError{fmt.Errorf("webhook responded %s", response.Status)}
+	case response.StatusCode >= 300:
+		return fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"website/internal/service"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestEngine(rules []Rule, webhooks []Webhook) *Engine {
+	return &Engine{
+		rules:      rules,
+		webhooks:   webhooks,
+		client:     &http.Client{Timeout: time.Second},
+		logger:     zap.NewNop().Sugar(),
+		deliveries: make(chan Alert, deliveryQueueSize),
+		backoff:    time.Millisecond,
+		firing:     map[ruleChannel]bool{},
+		fired:      map[ruleChannel]time.Time{},
+	}
+}
+
+func TestEngineDue(t *testing.T) {
+	e := newTestEngine([]Rule{{Name: "a", BaselineMinutes: 30}, {Name: "b", BaselineMinutes: 60}}, nil)
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+
+	minute, from, due := e.Due(now)
+	require.True(t, due)
+	require.Equal(t, now.Truncate(time.Minute).Add(-time.Minute), minute)
+	require.Equal(t, minute.Add(-time.Hour), from)
+
+	e.Evaluate(minute, nil)
+	_, _, due = e.Due(now.Add(20 * time.Second))
+	require.False(t, due)
+	_, _, due = e.Due(now.Add(time.Minute))
+	require.True(t, due)
+
+	_, _, due = newTestEngine(nil, nil).Due(now)
+	require.False(t, due)
+
+	// Minutes are evaluated once the lag has passed after they end
+	e = newTestEngine([]Rule{{Name: "a", BaselineMinutes: 30}}, nil)
+	e.lag = time.Minute
+	minute, _, _ = e.Due(now)
+	require.Equal(t, now.Truncate(time.Minute).Add(-2*time.Minute), minute)
+}
+
+func TestEngineEvaluate(t *testing.T) {
+	rule := Rule{Name: "negative", Metric: MetricNegative, Threshold: 0.5, BaselineMinutes: 5, Cooldown: Duration(10 * time.Minute)}
+	e := newTestEngine([]Rule{rule}, nil)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	evaluate := func(minute int, negative map[string]float64) []string {
+		at := start.Add(time.Duration(minute) * time.Minute)
+		averages := map[string][]service.AverageResult{}
+		for channel, value := range negative {
+			averages[channel] = negativeAverages(at, 1, value)
+		}
+		channels := []string{}
+		for _, alert := range e.Evaluate(at, averages) {
+			channels = append(channels, alert.Channel)
+		}
+		return channels
+	}
+
+	// Every channel is evaluated, in order
+	require.Equal(t, []string{"a", "b"}, evaluate(0, map[string]float64{"b": 0.6, "a": 0.7, "c": 0.1}))
+	// Fires once while it keeps matching, and for channels that start matching
+	require.Equal(t, []string{"c"}, evaluate(1, map[string]float64{"a": 0.7, "b": 0.6, "c": 0.8}))
+	require.Empty(t, evaluate(2, map[string]float64{"a": 0.7, "b": 0.1}))
+}
+
+func TestEngineCooldown(t *testing.T) {
+	rule := Rule{Name: "negative", Channels: []string{"gaules"}, Metric: MetricNegative, Threshold: 0.5, BaselineMinutes: 5, Cooldown: Duration(10 * time.Minute)}
+	e := newTestEngine([]Rule{rule}, nil)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	fire := func(minute int, value float64) bool {
+		at := start.Add(time.Duration(minute) * time.Minute)
+		return len(e.Evaluate(at, map[string][]service.AverageResult{"gaules": negativeAverages(at, 1, value)})) > 0
+	}
+
+	require.True(t, fire(0, 0.7))
+	require.False(t, fire(1, 0.7), "still firing")
+	require.False(t, fire(2, 0.1))
+	require.False(t, fire(3, 0.7), "within the cooldown")
+	require.False(t, fire(9, 0.1))
+	require.True(t, fire(10, 0.7), "after the cooldown")
+}
+
+func TestEngineRestore(t *testing.T) {
+	rule := Rule{Name: "negative", Channels: []string{"gaules"}, Metric: MetricNegative, Threshold: 0.5, BaselineMinutes: 5, Cooldown: Duration(10 * time.Minute)}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	fire := func(e *Engine, minute int, value float64) bool {
+		at := start.Add(time.Duration(minute) * time.Minute)
+		return len(e.Evaluate(at, map[string][]service.AverageResult{"gaules": negativeAverages(at, 1, value)})) > 0
+	}
+
+	leader := newTestEngine([]Rule{rule}, nil)
+	require.True(t, fire(leader, 0, 0.7))
+	state := leader.State()
+	require.Equal(t, []RuleState{{Rule: "negative", Channel: "gaules", Firing: true, FiredAt: start}}, state)
+
+	// The next leader does not fire the active alert again
+	next := newTestEngine([]Rule{rule}, nil)
+	next.Restore(state)
+	require.False(t, fire(next, 1, 0.7))
+	require.False(t, fire(next, 2, 0.1))
+	require.False(t, fire(next, 3, 0.7), "within the cooldown")
+
+	// Rules are forgotten once they stopped firing and the cooldown passed
+	require.False(t, fire(next, 10, 0.1))
+	require.Empty(t, next.State())
+}
+
+func TestEngineDelivery(t *testing.T) {
+	var mu sync.Mutex
+	payloads := map[string][]map[string]string{}
+	failures := 1
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/slack" && failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path == "/invalid" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		var payload map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		payloads[r.URL.Path] = append(payloads[r.URL.Path], payload)
+	}))
+	defer stub.Close()
+
+	e := newTestEngine(nil, []Webhook{
+		{URL: stub.URL + "/discord", Format: FormatDiscord},
+		{URL: stub.URL + "/slack", Format: FormatSlack},
+		{URL: stub.URL + "/invalid", Format: FormatDiscord},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Start(ctx)
+
+	alert := Alert{Rule: "negative", Channel: "gaules", Metric: MetricNegative, Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), Value: 0.6, Messages: 10}
+	e.Deliver([]Alert{alert})
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(payloads["/discord"]) == 1 && len(payloads["/slack"]) == 1 && len(payloads["/invalid"]) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	text := "negative: gaules chat is 60% negative at 12:00 UTC over 10 messages"
+	require.Equal(t, map[string]string{"content": text}, payloads["/discord"][0])
+	// Delivered on the retry after the server error
+	require.Equal(t, map[string]string{"text": text}, payloads["/slack"][0])
+	require.Zero(t, failures)
+}
@@ -0,0 +1,221 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+	"website/internal/service"
+)
+
+const (
+	defaultBaselineMinutes = 30
+	defaultCooldown        = 10 * time.Minute
+	// Baseline buckets needed to compute a z-score
+	minBaselineBuckets = 5
+	// Floor of the baseline deviation, so small changes after a flat baseline are not spikes
+	minStdDev = 0.01
+)
+
+type Metric string
+
+const (
+	MetricPositive Metric = "positive"
+	MetricNeutral  Metric = "neutral"
+	MetricNegative Metric = "negative"
+)
+
+func (m Metric) value(result service.AverageResult) float64 {
+	switch m {
+	case MetricPositive:
+		return result.AveragePositiveSentiment
+	case MetricNeutral:
+		return result.AverageNeutralSentiment
+	default:
+		return result.AverageNegativeSentiment
+	}
+}
+
+// Duration written as a Go duration string in the config, like "10m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Fires when the average of a minute crosses the threshold and the z-score, if they are set
+type Rule struct {
+	Name string `json:"name"`
+	// Channels evaluated, every channel when empty
+	Channels []string `json:"channels"`
+	Metric   Metric   `json:"metric"`
+	// Minimum average of the minute, 0 disables it
+	Threshold float64 `json:"threshold"`
+	// Minimum standard deviations of the minute above its baseline, 0 disables it
+	ZScore float64 `json:"z_score"`
+	// Minutes before the evaluated one that make the baseline
+	BaselineMinutes int `json:"baseline_minutes"`
+	// Minimum messages of the minute, so a few messages do not fire it
+	MinMessages int64 `json:"min_messages"`
+	// Time after firing in which the rule does not fire again for the channel
+	Cooldown Duration `json:"cooldown"`
+}
+
+type WebhookFormat string
+
+const (
+	FormatDiscord WebhookFormat = "discord"
+	FormatSlack   WebhookFormat = "slack"
+)
+
+type Webhook struct {
+	URL    string        `json:"url"`
+	Format WebhookFormat `json:"format"`
+}
+
+type Config struct {
+	Rules    []Rule    `json:"rules"`
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// Reads the JSON config at path, filling the defaults of the rules
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return config, err
+	}
+
+	names := map[string]bool{}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Name == "" || names[rule.Name] {
+			return config, fmt.Errorf("rule %d needs a unique name", i)
+		}
+		names[rule.Name] = true
+		switch rule.Metric {
+		case "":
+			rule.Metric = MetricNegative
+		case MetricPositive, MetricNeutral, MetricNegative:
+		default:
+			return config, fmt.Errorf("rule %s has an invalid metric %q, expected one of: positive, neutral, negative", rule.Name, rule.Metric)
+		}
+		if rule.Threshold <= 0 && rule.ZScore <= 0 {
+			return config, fmt.Errorf("rule %s needs a threshold or a z_score", rule.Name)
+		}
+		if rule.BaselineMinutes == 0 {
+			rule.BaselineMinutes = defaultBaselineMinutes
+		}
+		if rule.BaselineMinutes < minBaselineBuckets {
+			return config, fmt.Errorf("rule %s needs a baseline of at least %d minutes", rule.Name, minBaselineBuckets)
+		}
+		if rule.Cooldown == 0 {
+			rule.Cooldown = Duration(defaultCooldown)
+		}
+	}
+	for i, webhook := range config.Webhooks {
+		if webhook.URL == "" {
+			return config, fmt.Errorf("webhook %d needs a url", i)
+		}
+		if webhook.Format != FormatDiscord && webhook.Format != FormatSlack {
+			return config, fmt.Errorf("webhook %d has an invalid format %q, expected discord or slack", i, webhook.Format)
+		}
+	}
+	if len(config.Rules) > 0 && len(config.Webhooks) == 0 {
+		return config, errors.New("rules need at least one webhook")
+	}
+
+	return config, nil
+}
+
+// Rule that fired for the minute of a channel
+type Alert struct {
+	Rule      string    `json:"rule" db:"rule"`
+	Channel   string    `json:"channel" db:"channel"`
+	Metric    Metric    `json:"metric" db:"metric"`
+	Timestamp time.Time `json:"timestamp" db:"timestamp"`
+	Value     float64   `json:"value" db:"value"`
+	Messages  int64     `json:"messages" db:"messages"`
+	// Mean of the baseline and the z-score of the value, set when the rule has a z-score
+	Baseline *float64 `json:"baseline,omitempty" db:"baseline"`
+	ZScore   *float64 `json:"z_score,omitempty" db:"z_score"`
+}
+
+func (a Alert) String() string {
+	description := fmt.Sprintf("%s: %s chat is %.0f%% %s at %s over %d messages",
+		a.Rule, a.Channel, a.Value*100, a.Metric, a.Timestamp.UTC().Format("15:04 UTC"), a.Messages)
+	if a.ZScore != nil {
+		description += fmt.Sprintf(", %.1f standard deviations above the %.0f%% baseline", *a.ZScore, *a.Baseline*100)
+	}
+	return description
+}
+
+// Evaluates the rule for the minute of a channel, given its averages ordered by bucket
+func (r Rule) evaluate(channel string, minute time.Time, averages []service.AverageResult) (Alert, bool) {
+	var current *service.AverageResult
+	baseline := []float64{}
+	from := minute.Add(-time.Duration(r.BaselineMinutes) * time.Minute)
+	for i, average := range averages {
+		switch {
+		case average.Timestamp.Equal(minute):
+			current = &averages[i]
+		case !average.Timestamp.Before(from) && average.Timestamp.Before(minute):
+			baseline = append(baseline, r.Metric.value(average))
+		}
+	}
+	if current == nil || current.Messages < r.MinMessages {
+		return Alert{}, false
+	}
+
+	alert := Alert{
+		Rule:      r.Name,
+		Channel:   channel,
+		Metric:    r.Metric,
+		Timestamp: minute,
+		Value:     r.Metric.value(*current),
+		Messages:  current.Messages,
+	}
+	if r.Threshold > 0 && alert.Value < r.Threshold {
+		return Alert{}, false
+	}
+	if r.ZScore > 0 {
+		if len(baseline) < minBaselineBuckets {
+			return Alert{}, false
+		}
+		mean, stdDev := meanAndStdDev(baseline)
+		zScore := (alert.Value - mean) / max(stdDev, minStdDev)
+		if zScore < r.ZScore {
+			return Alert{}, false
+		}
+		alert.Baseline, alert.ZScore = &mean, &zScore
+	}
+	return alert, true
+}
+
+func meanAndStdDev(values []float64) (float64, float64) {
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+
+	var squares float64
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)))
+}
@@ -0,0 +1,98 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"website/internal/service"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `{
+  "rules": [{"name": "negative spike", "channels": ["gaules"], "z_score": 3, "min_messages": 20}],
+  "webhooks": [{"url": "http://localhost/hook", "format": "discord"}]
+}`))
+	require.NoError(t, err)
+	require.Equal(t, Rule{
+		Name:            "negative spike",
+		Channels:        []string{"gaules"},
+		Metric:          MetricNegative,
+		ZScore:          3,
+		BaselineMinutes: defaultBaselineMinutes,
+		MinMessages:     20,
+		Cooldown:        Duration(defaultCooldown),
+	}, config.Rules[0])
+
+	for name, content := range map[string]string{
+		"no name":        `{"rules": [{"threshold": 0.5}], "webhooks": [{"url": "http://localhost", "format": "slack"}]}`,
+		"invalid metric": `{"rules": [{"name": "a", "metric": "angry", "threshold": 0.5}], "webhooks": [{"url": "http://localhost", "format": "slack"}]}`,
+		"no condition":   `{"rules": [{"name": "a"}], "webhooks": [{"url": "http://localhost", "format": "slack"}]}`,
+		"short baseline": `{"rules": [{"name": "a", "z_score": 2, "baseline_minutes": 2}], "webhooks": [{"url": "http://localhost", "format": "slack"}]}`,
+		"bad cooldown":   `{"rules": [{"name": "a", "threshold": 0.5, "cooldown": "soon"}], "webhooks": [{"url": "http://localhost", "format": "slack"}]}`,
+		"no webhooks":    `{"rules": [{"name": "a", "threshold": 0.5}]}`,
+		"bad format":     `{"rules": [{"name": "a", "threshold": 0.5}], "webhooks": [{"url": "http://localhost", "format": "teams"}]}`,
+	} {
+		_, err := LoadConfig(writeConfig(t, content))
+		require.Error(t, err, name)
+	}
+}
+
+// Per minute averages ending at minute, with the negative sentiment of each minute
+func negativeAverages(minute time.Time, messages int64, negative ...float64) []service.AverageResult {
+	averages := []service.AverageResult{}
+	for i, value := range negative {
+		averages = append(averages, service.AverageResult{
+			Timestamp:                minute.Add(time.Duration(i-len(negative)+1) * time.Minute),
+			Messages:                 messages,
+			AverageNegativeSentiment: value,
+		})
+	}
+	return averages
+}
+
+func TestRuleEvaluate(t *testing.T) {
+	minute := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	baseline := []float64{0.1, 0.12, 0.08, 0.11, 0.09}
+
+	threshold := Rule{Name: "threshold", Metric: MetricNegative, Threshold: 0.5, BaselineMinutes: 5, MinMessages: 10}
+	alert, fired := threshold.evaluate("gaules", minute, negativeAverages(minute, 10, append(baseline, 0.6)...))
+	require.True(t, fired)
+	require.Equal(t, Alert{Rule: "threshold", Channel: "gaules", Metric: MetricNegative, Timestamp: minute, Value: 0.6, Messages: 10}, alert)
+
+	_, fired = threshold.evaluate("gaules", minute, negativeAverages(minute, 10, append(baseline, 0.4)...))
+	require.False(t, fired, "below the threshold")
+	_, fired = threshold.evaluate("gaules", minute, negativeAverages(minute, 9, append(baseline, 0.6)...))
+	require.False(t, fired, "too few messages")
+	_, fired = threshold.evaluate("gaules", minute, negativeAverages(minute.Add(-time.Minute), 10, baseline...))
+	require.False(t, fired, "no results in the minute")
+
+	zScore := Rule{Name: "z-score", Metric: MetricNegative, ZScore: 3, BaselineMinutes: 5}
+	alert, fired = zScore.evaluate("gaules", minute, negativeAverages(minute, 10, append(baseline, 0.4)...))
+	require.True(t, fired)
+	require.InDelta(t, 0.1, *alert.Baseline, 1e-9)
+	require.Greater(t, *alert.ZScore, 3.0)
+	require.Contains(t, alert.String(), "standard deviations above the 10% baseline")
+
+	_, fired = zScore.evaluate("gaules", minute, negativeAverages(minute, 10, append(baseline, 0.13)...))
+	require.False(t, fired, "within the baseline deviation")
+	_, fired = zScore.evaluate("gaules", minute, negativeAverages(minute, 10, 0.1, 0.1, 0.4))
+	require.False(t, fired, "baseline too short")
+
+	// A flat baseline has no deviation, the floor keeps small changes from firing
+	_, fired = zScore.evaluate("gaules", minute, negativeAverages(minute, 10, 0.1, 0.1, 0.1, 0.1, 0.1, 0.12))
+	require.False(t, fired)
+
+	// Both conditions must hold when they are set
+	both := Rule{Name: "both", Metric: MetricNegative, Threshold: 0.5, ZScore: 3, BaselineMinutes: 5}
+	_, fired = both.evaluate("gaules", minute, negativeAverages(minute, 10, append(baseline, 0.4)...))
+	require.False(t, fired)
+}
@@ -0,0 +1,80 @@
+package alerting
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Keeps the fired alerts in the alerts table, and the state of the rules in the alert_state table
+type Store struct {
+	conn *pgxpool.Pool
+}
+
+func NewStore(conn *pgxpool.Pool) *Store {
+	return &Store{conn: conn}
+}
+
+// Saves the alerts, ignoring the ones already saved
+func (s *Store) Save(ctx context.Context, alerts []Alert) error {
+	batch := &pgx.Batch{}
+	for _, alert := range alerts {
+		batch.Queue(`
+INSERT INTO alerts (rule, channel, metric, "timestamp", value, messages, baseline, z_score)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (rule, channel, "timestamp") DO NOTHING;
+`, alert.Rule, alert.Channel, alert.Metric, alert.Timestamp, alert.Value, alert.Messages, alert.Baseline, alert.ZScore)
+	}
+	return s.conn.SendBatch(ctx, batch).Close()
+}
+
+// Alerts of the channels, or of every channel when there are none, fired in the [from, to) range, oldest first
+func (s *Store) List(ctx context.Context, channels []string, from, to time.Time) ([]Alert, error) {
+	rows, err := s.conn.Query(ctx, `
+SELECT rule, channel, metric, "timestamp", value, messages, baseline, z_score
+FROM alerts
+WHERE "timestamp" >= $1 AND "timestamp" < $2 AND (COALESCE(cardinality($3::VARCHAR[]), 0) = 0 OR channel = ANY($3))
+ORDER BY "timestamp", id;
+`, from, to, channels)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Alert])
+}
+
+// Replaces the saved state of the rules
+func (s *Store) SaveState(ctx context.Context, states []RuleState) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	batch.Queue(`DELETE FROM alert_state;`)
+	for _, state := range states {
+		batch.Queue(`
+INSERT INTO alert_state (rule, channel, firing, fired_at)
+VALUES ($1, $2, $3, $4);
+`, state.Rule, state.Channel, state.Firing, state.FiredAt)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Saved state of the rules
+func (s *Store) LoadState(ctx context.Context) ([]RuleState, error) {
+	rows, err := s.conn.Query(ctx, `
+SELECT rule, channel, firing, fired_at
+FROM alert_state
+ORDER BY rule, channel;
+`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[RuleState])
+}
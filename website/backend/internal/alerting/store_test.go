@@ -0,0 +1,65 @@
+package alerting
+
+import (
+	"context"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"go.uber.org/zap"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	t.Setenv("DATABASE_DSN", postgresContainer.MustConnectionString(ctx))
+	store := NewStore(database.NewDatabaseConnection(zap.NewNop().Sugar()))
+
+	minute := time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC)
+	zScore, baseline := 3.5, 0.2
+	alerts := []Alert{
+		{Rule: "negative spike", Channel: "gaules", Metric: MetricNegative, Timestamp: minute, Value: 0.7, Messages: 40, Baseline: &baseline, ZScore: &zScore},
+		{Rule: "toxic chat", Channel: "loud_coringa", Metric: MetricNegative, Timestamp: minute.Add(time.Minute), Value: 0.65, Messages: 80},
+	}
+	require.NoError(t, store.Save(ctx, alerts))
+	// Saving an alert again does not duplicate it
+	require.NoError(t, store.Save(ctx, alerts[:1]))
+
+	saved, err := store.List(ctx, nil, minute, minute.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, saved, 2)
+	require.Equal(t, alerts[0].Rule, saved[0].Rule)
+	require.True(t, alerts[0].Timestamp.Equal(saved[0].Timestamp))
+	require.Equal(t, zScore, *saved[0].ZScore)
+	require.Nil(t, saved[1].ZScore)
+
+	saved, err = store.List(ctx, []string{"loud_coringa"}, minute, minute.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, saved, 1)
+	require.Equal(t, "loud_coringa", saved[0].Channel)
+
+	saved, err = store.List(ctx, nil, minute.Add(time.Hour), minute.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, saved)
+
+	states := []RuleState{
+		{Rule: "negative spike", Channel: "gaules", Firing: true, FiredAt: minute},
+		{Rule: "toxic chat", Channel: "loud_coringa", FiredAt: minute.Add(time.Minute)},
+	}
+	require.NoError(t, store.SaveState(ctx, states))
+	// Saving replaces the previous state
+	require.NoError(t, store.SaveState(ctx, states[1:]))
+	loaded, err := store.LoadState(ctx)
+	require.NoError(t, err)
+	require.Len(t, loaded, 1)
+	require.Equal(t, "loud_coringa", loaded[0].Channel)
+	require.False(t, loaded[0].Firing)
+	require.True(t, states[1].FiredAt.Equal(loaded[0].FiredAt))
+}
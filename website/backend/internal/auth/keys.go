@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	keyPrefix = "tcs_"
+	// Random bytes of a key, hashing them with SHA-256 is enough since they can not be guessed
+	keyBytes = 32
+	// Characters of the key kept to recognize it
+	shownPrefixLength = len(keyPrefix) + 8
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrKeyExists  = errors.New("an API key with that name already exists")
+	ErrKeyUnknown = errors.New("no active API key with that name")
+)
+
+type Scope string
+
+const (
+	// Reads the data of the API
+	ScopeRead Scope = "read"
+	// Manages the data and the keys, includes read
+	ScopeAdmin Scope = "admin"
+)
+
+func ParseScopes(value string) ([]Scope, error) {
+	scopes := []Scope{}
+	for _, name := range strings.Split(value, ",") {
+		switch scope := Scope(strings.TrimSpace(name)); scope {
+		case ScopeRead, ScopeAdmin:
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		default:
+			return nil, fmt.Errorf("invalid scope %q, expected read or admin", name)
+		}
+	}
+	return scopes, nil
+}
+
+type Key struct {
+	ID        int64      `json:"-" db:"id"`
+	Name      string     `json:"name" db:"name"`
+	Prefix    string     `json:"prefix" db:"prefix"`
+	Scopes    []Scope    `json:"scopes" db:"scopes"`
+	RateLimit float64    `json:"rate_limit" db:"rate_limit"`
+	Burst     int        `json:"burst" db:"burst"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	LastUsed  *time.Time `json:"last_used_at" db:"last_used_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// Whether the key grants the scope, admin keys grant every scope
+func (k Key) Allows(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// Stores the API keys hashed in the api_keys table
+type Store struct {
+	conn *pgxpool.Pool
+}
+
+func NewStore(conn *pgxpool.Pool) *Store {
+	return &Store{conn: conn}
+}
+
+// Creates a key, returning the secret that is shown only once
+func (s *Store) Create(ctx context.Context, name string, scopes []Scope, rateLimit float64, burst int) (Key, string, error) {
+	random := make([]byte, keyBytes)
+	if _, err := rand.Read(random); err != nil {
+		return Key{}, "", err
+	}
+	secret := keyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	rows, err := s.conn.Query(ctx, `
+INSERT INTO api_keys (name, prefix, key_hash, scopes, rate_limit, burst)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (name) DO NOTHING
+RETURNING id, name, prefix, scopes, rate_limit, burst, created_at, last_used_at, revoked_at;
+`, name, secret[:shownPrefixLength], hash(secret), scopes, rateLimit, burst)
+	if err != nil {
+		return Key{}, "", err
+	}
+	key, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[Key])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Key{}, "", ErrKeyExists
+	}
+	return key, secret, err
+}
+
+// Every key, revoked ones included, oldest first
+func (s *Store) List(ctx context.Context) ([]Key, error) {
+	rows, err := s.conn.Query(ctx, `
+SELECT id, name, prefix, scopes, rate_limit, burst, created_at, last_used_at, revoked_at
+FROM api_keys
+ORDER BY created_at, id;
+`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Key])
+}
+
+func (s *Store) Revoke(ctx context.Context, name string) error {
+	tag, err := s.conn.Exec(ctx, `UPDATE api_keys SET revoked_at = NOW() WHERE name = $1 AND revoked_at IS NULL;`, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrKeyUnknown
+	}
+	return nil
+}
+
+// Active key of the secret, recording when it was used
+func (s *Store) Authenticate(ctx context.Context, secret string) (Key, error) {
+	if !strings.HasPrefix(secret, keyPrefix) {
+		return Key{}, ErrInvalidKey
+	}
+	rows, err := s.conn.Query(ctx, `
+UPDATE api_keys SET last_used_at = NOW()
+WHERE key_hash = $1 AND revoked_at IS NULL
+RETURNING id, name, prefix, scopes, rate_limit, burst, created_at, last_used_at, revoked_at;
+`, hash(secret))
+	if err != nil {
+		return Key{}, err
+	}
+	key, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[Key])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Key{}, ErrInvalidKey
+	}
+	return key, err
+}
+
+func hash(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = zap.NewNop().Sugar()
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("read, admin,read")
+	require.NoError(t, err)
+	require.Equal(t, []Scope{ScopeRead, ScopeAdmin}, scopes)
+
+	_, err = ParseScopes("write")
+	require.Error(t, err)
+
+	require.True(t, Key{Scopes: []Scope{ScopeAdmin}}.Allows(ScopeRead))
+	require.False(t, Key{Scopes: []Scope{ScopeRead}}.Allows(ScopeAdmin))
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	t.Setenv("DATABASE_DSN", postgresContainer.MustConnectionString(ctx))
+	store := NewStore(database.NewDatabaseConnection(logger))
+
+	key, secret, err := store.Create(ctx, "analyst", []Scope{ScopeRead}, 2, 10)
+	require.NoError(t, err)
+	require.Equal(t, secret[:shownPrefixLength], key.Prefix)
+	require.Equal(t, []Scope{ScopeRead}, key.Scopes)
+	require.Nil(t, key.LastUsed)
+
+	_, _, err = store.Create(ctx, "analyst", []Scope{ScopeAdmin}, 2, 10)
+	require.ErrorIs(t, err, ErrKeyExists)
+
+	authenticated, err := store.Authenticate(ctx, secret)
+	require.NoError(t, err)
+	require.Equal(t, key.ID, authenticated.ID)
+	require.NotNil(t, authenticated.LastUsed)
+
+	_, err = store.Authenticate(ctx, secret+"x")
+	require.ErrorIs(t, err, ErrInvalidKey)
+	_, err = store.Authenticate(ctx, "not a key")
+	require.ErrorIs(t, err, ErrInvalidKey)
+
+	require.NoError(t, store.Revoke(ctx, "analyst"))
+	require.ErrorIs(t, store.Revoke(ctx, "analyst"), ErrKeyUnknown)
+	_, err = store.Authenticate(ctx, secret)
+	require.ErrorIs(t, err, ErrInvalidKey)
+
+	keys, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.NotNil(t, keys[0].RevokedAt)
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Token buckets of the keys, refilled with the rate limit of each key up to its burst.
+// Limits are kept in memory, so each replica enforces them on its own.
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[int64]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{buckets: map[int64]*bucket{}}
+}
+
+// Takes a token of the key, or returns the wait until there is one
+func (l *limiter) allow(key Key, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, found := l.buckets[key.ID]
+	if !found {
+		b = &bucket{tokens: float64(key.Burst), last: now}
+		l.buckets[key.ID] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(key.Burst), b.tokens+elapsed.Seconds()*key.RateLimit)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / key.RateLimit * float64(time.Second))
+}
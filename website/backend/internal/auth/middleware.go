@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	// Time keys are trusted without reading them again, so revoked keys stop working after it
+	keyCacheTTL   = 30 * time.Second
+	maxCachedKeys = 1000
+)
+
+var (
+	rejectedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_requests_rejected_total",
+	}, []string{"reason"})
+
+	rateLimitedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_rate_limited_requests_total",
+	}, []string{"key"})
+)
+
+type keyStore interface {
+	Authenticate(ctx context.Context, secret string) (Key, error)
+}
+
+type contextKey struct{}
+
+// Key of an authenticated request
+func KeyFromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(Key)
+	return key, ok
+}
+
+// Authenticates requests by their API key and applies the rate limit of the key
+type Authenticator struct {
+	keys    keyStore
+	limiter *limiter
+	logger  *zap.SugaredLogger
+
+	mu sync.Mutex
+	// Keys by secret hash, read again from the store after keyCacheTTL
+	cache map[string]cachedKey
+	now   func() time.Time
+}
+
+type cachedKey struct {
+	key     Key
+	expires time.Time
+}
+
+func NewAuthenticator(store *Store, logger *zap.SugaredLogger) *Authenticator {
+	return newAuthenticator(store, logger)
+}
+
+func newAuthenticator(keys keyStore, logger *zap.SugaredLogger) *Authenticator {
+	return &Authenticator{
+		keys:    keys,
+		limiter: newLimiter(),
+		logger:  logger,
+		cache:   map[string]cachedKey{},
+		now:     time.Now,
+	}
+}
+
+// Serves the request only with a key that grants the scope and has requests left.
+// The key is sent as "Authorization: Bearer <key>" or in the "X-API-Key" header.
+func (a *Authenticator) Require(scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get("X-API-Key")
+		if value, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			secret = value
+		}
+		if secret == "" {
+			rejectedCounter.WithLabelValues("missing").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeError(w, http.StatusUnauthorized, "API key required")
+			return
+		}
+
+		key, err := a.authenticate(r.Context(), secret)
+		if errors.Is(err, ErrInvalidKey) {
+			rejectedCounter.WithLabelValues("invalid").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			a.logger.Errorf("Failed to authenticate: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if !key.Allows(scope) {
+			rejectedCounter.WithLabelValues("forbidden").Inc()
+			writeError(w, http.StatusForbidden, "API key does not have the "+string(scope)+" scope")
+			return
+		}
+
+		if allowed, wait := a.limiter.allow(key, a.now()); !allowed {
+			rejectedCounter.WithLabelValues("rate_limited").Inc()
+			rateLimitedCounter.WithLabelValues(key.Name).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, key)))
+	})
+}
+
+// Key of the secret, from the cache while it is fresh
+func (a *Authenticator) authenticate(ctx context.Context, secret string) (Key, error) {
+	hashed := string(hash(secret))
+	now := a.now()
+
+	a.mu.Lock()
+	cached, found := a.cache[hashed]
+	a.mu.Unlock()
+	if found && now.Before(cached.expires) {
+		return cached.key, nil
+	}
+
+	key, err := a.keys.Authenticate(ctx, secret)
+	if err != nil {
+		return Key{}, err
+	}
+
+	a.mu.Lock()
+	if len(a.cache) >= maxCachedKeys {
+		clear(a.cache)
+	}
+	a.cache[hashed] = cachedKey{key: key, expires: now.Add(keyCacheTTL)}
+	a.mu.Unlock()
+	return key, nil
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
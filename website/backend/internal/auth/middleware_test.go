@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStore struct {
+	keys  map[string]Key
+	reads int
+}
+
+func (f *fakeStore) Authenticate(ctx context.Context, secret string) (Key, error) {
+	f.reads++
+	key, found := f.keys[secret]
+	if !found {
+		return Key{}, ErrInvalidKey
+	}
+	return key, nil
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter()
+	key := Key{ID: 1, RateLimit: 2, Burst: 3}
+	now := time.Now()
+
+	for range 3 {
+		allowed, _ := l.allow(key, now)
+		require.True(t, allowed)
+	}
+	allowed, wait := l.allow(key, now)
+	require.False(t, allowed)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	allowed, _ = l.allow(key, now.Add(500*time.Millisecond))
+	require.True(t, allowed)
+	// Refills up to the burst
+	for range 3 {
+		allowed, _ = l.allow(key, now.Add(time.Hour))
+		require.True(t, allowed)
+	}
+	allowed, _ = l.allow(key, now.Add(time.Hour))
+	require.False(t, allowed)
+
+	// Keys have their own buckets
+	allowed, _ = l.allow(Key{ID: 2, RateLimit: 1, Burst: 1}, now)
+	require.True(t, allowed)
+}
+
+func TestRequire(t *testing.T) {
+	store := &fakeStore{keys: map[string]Key{
+		"tcs_reader": {ID: 1, Name: "reader", Scopes: []Scope{ScopeRead}, RateLimit: 1, Burst: 2},
+		"tcs_admin":  {ID: 2, Name: "admin", Scopes: []Scope{ScopeAdmin}, RateLimit: 1, Burst: 5},
+	}}
+	a := newAuthenticator(store, logger)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	handler := func(scope Scope) http.Handler {
+		return a.Require(scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := KeyFromContext(r.Context())
+			require.True(t, ok)
+			w.Write([]byte(key.Name))
+		}))
+	}
+	do := func(scope Scope, header, value string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/channels", nil)
+		if header != "" {
+			request.Header.Set(header, value)
+		}
+		recorder := httptest.NewRecorder()
+		handler(scope).ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do(ScopeRead, "", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(ScopeRead, "Authorization", "Bearer tcs_unknown").Code)
+
+	response := do(ScopeRead, "Authorization", "Bearer tcs_reader")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "reader", response.Body.String())
+	require.Equal(t, http.StatusForbidden, do(ScopeAdmin, "X-API-Key", "tcs_reader").Code)
+
+	// Rejected requests do not take tokens, the burst of two runs out on the third allowed one
+	require.Equal(t, http.StatusOK, do(ScopeRead, "X-API-Key", "tcs_reader").Code)
+	response = do(ScopeRead, "X-API-Key", "tcs_reader")
+	require.Equal(t, http.StatusTooManyRequests, response.Code)
+	require.Equal(t, "1", response.Header().Get("Retry-After"))
+
+	require.Equal(t, http.StatusOK, do(ScopeAdmin, "X-API-Key", "tcs_admin").Code)
+	require.Equal(t, http.StatusOK, do(ScopeRead, "X-API-Key", "tcs_admin").Code)
+
+	// Keys are read again once their cache expires
+	reads := store.reads
+	do(ScopeRead, "X-API-Key", "tcs_admin")
+	require.Equal(t, reads, store.reads)
+	now = now.Add(keyCacheTTL)
+	delete(store.keys, "tcs_admin")
+	require.Equal(t, http.StatusUnauthorized, do(ScopeRead, "X-API-Key", "tcs_admin").Code)
+}
@@ -0,0 +1,74 @@
+package backplane
+
+import (
+	"context"
+	"os"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Delivers hub events to every website replica and elects the single replica that queries the database
+type Backplane interface {
+	// Runs until the context is done
+	Start(ctx context.Context)
+	// Sends a message to every replica, including this one
+	Publish(ctx context.Context, message []byte) error
+	// Messages published by any replica
+	Messages() <-chan []byte
+	// Reports leadership changes of this replica
+	Leadership() <-chan bool
+	// Whether other replicas share the published messages
+	Distributed() bool
+}
+
+// Creates the backplane selected by the BACKPLANE environment variable, "postgres" or in-process by default
+func NewBackplane(conn *pgxpool.Pool, logger *zap.SugaredLogger) Backplane {
+	switch kind := os.Getenv("BACKPLANE"); kind {
+	case "postgres":
+		return NewPostgresBackplane(conn, logger)
+	case "", "local":
+		return NewLocalBackplane()
+	default:
+		logger.Fatalf("Invalid BACKPLANE %q, expected postgres or local", kind)
+		return nil
+	}
+}
+
+// In-process backplane of a single replica, which is always the leader
+type localBackplane struct {
+	messages   chan []byte
+	leadership chan bool
+}
+
+func NewLocalBackplane() Backplane {
+	b := &localBackplane{
+		messages:   make(chan []byte),
+		leadership: make(chan bool, 1),
+	}
+	b.leadership <- true
+	return b
+}
+
+func (b *localBackplane) Start(ctx context.Context) {}
+
+func (b *localBackplane) Publish(ctx context.Context, message []byte) error {
+	select {
+	case b.messages <- message:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *localBackplane) Messages() <-chan []byte {
+	return b.messages
+}
+
+func (b *localBackplane) Leadership() <-chan bool {
+	return b.leadership
+}
+
+func (b *localBackplane) Distributed() bool {
+	return false
+}
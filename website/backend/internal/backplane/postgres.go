@@ -0,0 +1,269 @@
+package backplane
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	eventsChannel = "website_events"
+	// Key of the advisory lock held by the leader
+	leaderLockKey = 7_245_001
+
+	// Notification payloads are limited to 8000 bytes
+	maxChunkSize     = 7000
+	electionInterval = 2 * time.Second
+	partialTimeout   = 30 * time.Second
+	maxBackoff       = 30 * time.Second
+)
+
+// Backplane over Postgres: messages are sent with NOTIFY and the leader holds a session advisory lock
+type postgresBackplane struct {
+	pool   *pgxpool.Pool
+	logger *zap.SugaredLogger
+
+	replica  string
+	counter  atomic.Uint64
+	messages chan []byte
+
+	leadership chan bool
+}
+
+func NewPostgresBackplane(pool *pgxpool.Pool, logger *zap.SugaredLogger) Backplane {
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	return &postgresBackplane{
+		pool:       pool,
+		logger:     logger,
+		replica:    hex.EncodeToString(id),
+		messages:   make(chan []byte),
+		leadership: make(chan bool, 1),
+	}
+}
+
+func (b *postgresBackplane) Start(ctx context.Context) {
+	b.logger.Infof("Starting Postgres backplane as replica %s", b.replica)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		b.retry(ctx, "subscriber", b.subscribe)
+	}()
+	go func() {
+		defer wg.Done()
+		b.retry(ctx, "election", b.elect)
+	}()
+	wg.Wait()
+
+	b.logger.Info("Postgres backplane stopped")
+}
+
+func (b *postgresBackplane) retry(ctx context.Context, name string, run func(ctx context.Context) error) {
+	backoff := time.Second
+	for {
+		err := run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		b.logger.Errorf("Backplane %s failed, retrying in %s: %v", name, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// Splits the message in chunks sent in a single transaction, so they are delivered together and in order
+func (b *postgresBackplane) Publish(ctx context.Context, message []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(message)
+	id := fmt.Sprintf("%s-%d", b.replica, b.counter.Add(1))
+	count := max((len(encoded)+maxChunkSize-1)/maxChunkSize, 1)
+
+	return pgx.BeginFunc(ctx, b.pool, func(tx pgx.Tx) error {
+		for i := 0; i < count; i++ {
+			chunk := encoded[i*maxChunkSize : min((i+1)*maxChunkSize, len(encoded))]
+			payload := fmt.Sprintf("%s:%d:%d:%s", id, i, count, chunk)
+			if _, err := tx.Exec(ctx, "SELECT pg_notify($1, $2)", eventsChannel, payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *postgresBackplane) Messages() <-chan []byte {
+	return b.messages
+}
+
+func (b *postgresBackplane) Leadership() <-chan bool {
+	return b.leadership
+}
+
+func (b *postgresBackplane) Distributed() bool {
+	return true
+}
+
+func (b *postgresBackplane) subscribe(ctx context.Context) error {
+	pooled, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+eventsChannel); err != nil {
+		return err
+	}
+
+	partials := newReassembler()
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		message, err := partials.add(notification.Payload, time.Now())
+		if err != nil {
+			b.logger.Errorf("Dropping invalid backplane message: %v", err)
+			continue
+		}
+		if message == nil {
+			continue
+		}
+		select {
+		case b.messages <- message:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Tries to take the leader lock and holds it while the connection is alive
+func (b *postgresBackplane) elect(ctx context.Context) error {
+	pooled, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The session lock lives as long as the connection, so it must not go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	leader := false
+	defer func() {
+		if leader {
+			b.logger.Info("Lost backplane leadership")
+			b.setLeader(false)
+		}
+	}()
+
+	ticker := time.NewTicker(electionInterval)
+	defer ticker.Stop()
+	for {
+		if leader {
+			if _, err := conn.Exec(ctx, "SELECT 1"); err != nil {
+				return err
+			}
+		} else {
+			var acquired bool
+			if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", leaderLockKey).Scan(&acquired); err != nil {
+				return err
+			}
+			if acquired {
+				b.logger.Info("Became backplane leader")
+				leader = true
+				b.setLeader(true)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Replaces a change that was not consumed yet, as only the latest one matters
+func (b *postgresBackplane) setLeader(leader bool) {
+	select {
+	case <-b.leadership:
+	default:
+	}
+	b.leadership <- leader
+}
+
+type partialMessage struct {
+	chunks   []string
+	present  []bool
+	received int
+	first    time.Time
+}
+
+// Joins the chunks of each message, dropping incomplete ones after a timeout
+type reassembler struct {
+	partials map[string]*partialMessage
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{partials: map[string]*partialMessage{}}
+}
+
+// Returns the whole message once its last chunk is added
+func (r *reassembler) add(payload string, now time.Time) ([]byte, error) {
+	for id, partial := range r.partials {
+		if now.Sub(partial.first) > partialTimeout {
+			delete(r.partials, id)
+		}
+	}
+
+	parts := strings.SplitN(payload, ":", 4)
+	if len(parts) != 4 {
+		return nil, errors.New("malformed chunk header")
+	}
+	id := parts[0]
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed chunk index: %w", err)
+	}
+	count, err := strconv.Atoi(parts[2])
+	if err != nil || count < 1 || index < 0 || index >= count {
+		return nil, errors.New("malformed chunk count")
+	}
+
+	partial, found := r.partials[id]
+	if !found {
+		partial = &partialMessage{chunks: make([]string, count), present: make([]bool, count), first: now}
+		r.partials[id] = partial
+	}
+	if len(partial.chunks) != count {
+		delete(r.partials, id)
+		return nil, fmt.Errorf("chunk count of message %s changed", id)
+	}
+	if !partial.present[index] {
+		partial.present[index] = true
+		partial.received++
+	}
+	partial.chunks[index] = parts[3]
+	if partial.received < count {
+		return nil, nil
+	}
+
+	delete(r.partials, id)
+	return base64.StdEncoding.DecodeString(strings.Join(partial.chunks, ""))
+}
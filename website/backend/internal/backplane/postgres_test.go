@@ -0,0 +1,101 @@
+package backplane
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = zap.NewNop().Sugar()
+}
+
+func TestReassembler(t *testing.T) {
+	now := time.Now()
+	r := newReassembler()
+
+	message, err := r.add("a-1:1:2:"+"bG8=", now)
+	require.NoError(t, err)
+	require.Nil(t, message)
+
+	message, err = r.add("a-1:0:2:"+"aGVs", now)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(message))
+
+	_, err = r.add("malformed", now)
+	require.Error(t, err)
+	_, err = r.add("a-2:2:2:aGVs", now)
+	require.Error(t, err)
+
+	// Incomplete messages are dropped after the timeout
+	_, err = r.add("a-3:0:2:aGVs", now)
+	require.NoError(t, err)
+	message, err = r.add("a-3:1:2:bG8=", now.Add(partialTimeout+time.Second))
+	require.NoError(t, err)
+	require.Nil(t, message)
+}
+
+func TestPostgresBackplane(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	t.Setenv("DATABASE_DSN", postgresContainer.MustConnectionString(ctx))
+	conn := database.NewDatabaseConnection(logger)
+
+	backplaneCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	first := NewPostgresBackplane(conn, logger)
+	go first.Start(backplaneCtx)
+
+	select {
+	case leader := <-first.Leadership():
+		require.True(t, leader)
+	case <-time.After(10 * time.Second):
+		t.Fatal("First replica did not become leader")
+	}
+
+	secondCtx, stopSecond := context.WithCancel(ctx)
+	defer stopSecond()
+	second := NewPostgresBackplane(conn, logger)
+	go second.Start(secondCtx)
+
+	select {
+	case <-second.Leadership():
+		t.Fatal("Second replica should not become leader while the first holds the lock")
+	case <-time.After(2 * electionInterval):
+	}
+
+	// Larger than a single notification
+	message := strings.Repeat("event ", 3*maxChunkSize)
+	require.NoError(t, second.Publish(ctx, []byte(message)))
+
+	for _, replica := range []Backplane{first, second} {
+		select {
+		case received := <-replica.Messages():
+			require.Equal(t, message, string(received))
+		case <-time.After(10 * time.Second):
+			t.Fatal("Did not receive the published message")
+		}
+	}
+
+	// The lock is released with the connection of the leader
+	cancel()
+	select {
+	case leader := <-second.Leadership():
+		require.True(t, leader)
+	case <-time.After(4 * electionInterval):
+		t.Fatal("Second replica did not take over the leadership")
+	}
+}
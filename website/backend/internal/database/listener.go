@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	// Channel notified by the results insert trigger with the comma separated channels of the inserted rows
+	ResultsInsertedChannel = "results_inserted"
+
+	listenerMaxBackoff = 30 * time.Second
+)
+
+// Listens to Postgres notifications on a dedicated connection, batching the ones received close to each other
+type Listener struct {
+	pool        *pgxpool.Pool
+	logger      *zap.SugaredLogger
+	channel     string
+	batchWindow time.Duration
+
+	batches   chan []string
+	listening chan bool
+}
+
+func NewListener(pool *pgxpool.Pool, channel string, batchWindow time.Duration, logger *zap.SugaredLogger) *Listener {
+	return &Listener{
+		pool:        pool,
+		logger:      logger,
+		channel:     channel,
+		batchWindow: batchWindow,
+		batches:     make(chan []string),
+		listening:   make(chan bool),
+	}
+}
+
+// Distinct payloads of the notifications received in a batch window
+func (l *Listener) Batches() <-chan []string {
+	return l.batches
+}
+
+// Reports whether notifications are being received, so consumers can fall back to polling
+func (l *Listener) Listening() <-chan bool {
+	return l.listening
+}
+
+// Listens until the context is done, reconnecting with backoff on failures
+func (l *Listener) Start(ctx context.Context) {
+	l.logger.Infof("Starting listener on %s", l.channel)
+
+	backoff := time.Second
+	for {
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			l.logger.Info("Stopping listener")
+			return
+		}
+		l.setListening(ctx, false)
+		l.logger.Errorf("Listener failed, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			l.logger.Info("Stopping listener")
+			return
+		}
+		backoff = min(backoff*2, listenerMaxBackoff)
+	}
+}
+
+func (l *Listener) listen(ctx context.Context) error {
+	pooled, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection keeps listening, so it must not go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{l.channel}.Sanitize()); err != nil {
+		return err
+	}
+	l.setListening(ctx, true)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		payloads := map[string]bool{}
+		addPayload(payloads, notification.Payload)
+
+		batchCtx, cancel := context.WithTimeout(ctx, l.batchWindow)
+		for {
+			notification, err := conn.WaitForNotification(batchCtx)
+			if err != nil {
+				cancel()
+				if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+					return err
+				}
+				break
+			}
+			addPayload(payloads, notification.Payload)
+		}
+
+		batch := make([]string, 0, len(payloads))
+		for payload := range payloads {
+			batch = append(batch, payload)
+		}
+		slices.Sort(batch)
+		select {
+		case l.batches <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *Listener) setListening(ctx context.Context, listening bool) {
+	select {
+	case l.listening <- listening:
+	case <-ctx.Done():
+	}
+}
+
+func addPayload(payloads map[string]bool, payload string) {
+	for _, value := range strings.Split(payload, ",") {
+		if len(value) > 0 {
+			payloads[value] = true
+		}
+	}
+}
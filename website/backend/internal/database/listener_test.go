@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+
+	"website/internal/testutils"
+)
+
+func TestListener(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	t.Setenv("DATABASE_DSN", postgresContainer.MustConnectionString(ctx))
+	conn := NewDatabaseConnection(logger)
+
+	listenerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	listener := NewListener(conn, ResultsInsertedChannel, 200*time.Millisecond, logger)
+	go listener.Start(listenerCtx)
+
+	select {
+	case listening := <-listener.Listening():
+		require.True(t, listening)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listener did not start")
+	}
+
+	insert := func(channel string, messageId string) {
+		_, err := conn.Exec(ctx, `INSERT INTO results
+			(channel, "user", "message_id", "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative)
+			VALUES ($1, 'user123', $2, NOW(), 'This is a sample message.', 0.8, 0.1, 0.1);`, channel, messageId)
+		require.NoError(t, err)
+	}
+	insert("channel1", "msg-001")
+	insert("channel2", "msg-002")
+	insert("channel1", "msg-003")
+
+	select {
+	case batch := <-listener.Batches():
+		require.Equal(t, []string{"channel1", "channel2"}, batch)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Did not receive notifications")
+	}
+}
@@ -0,0 +1,241 @@
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"website/internal/service"
+)
+
+const timestampFormat = "20060102T1504Z"
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatNDJSON  Format = "ndjson"
+	FormatParquet Format = "parquet"
+)
+
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(value); format {
+	case FormatCSV, FormatNDJSON, FormatParquet:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid format %q, expected one of: csv, ndjson, parquet", value)
+}
+
+// Rows that can be exported
+type Dataset string
+
+const (
+	DatasetResults  Dataset = "results"
+	DatasetAverages Dataset = "averages"
+)
+
+func ParseDataset(value string) (Dataset, error) {
+	switch dataset := Dataset(value); dataset {
+	case DatasetResults, DatasetAverages:
+		return dataset, nil
+	}
+	return "", fmt.Errorf("invalid dataset %q, expected results or averages", value)
+}
+
+type Request struct {
+	Dataset Dataset
+	Channel string
+	From    time.Time
+	To      time.Time
+	// Bucket of the averages
+	Bucket service.Bucket
+	Format Format
+	// Compresses the file with gzip
+	Gzip bool
+}
+
+// Name of the exported file, like gaules-results-20241201T1400Z-20241201T1500Z.csv.gz
+func (r Request) Filename() string {
+	name := fmt.Sprintf("%s-%s", r.Channel, r.Dataset)
+	if r.Dataset == DatasetAverages {
+		name += "-" + string(r.Bucket)
+	}
+	name += fmt.Sprintf("-%s-%s.%s", r.From.UTC().Format(timestampFormat), r.To.UTC().Format(timestampFormat), r.Format)
+	if r.Gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+func (r Request) ContentType() string {
+	if r.Gzip {
+		return "application/gzip"
+	}
+	switch r.Format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// Writes the rows of the request to w as they are read from the database
+func Export(ctx context.Context, resultsService *service.ResultsService, w io.Writer, r Request) error {
+	var compressed *gzip.Writer
+	if r.Gzip {
+		compressed = gzip.NewWriter(w)
+		w = compressed
+	}
+
+	var err error
+	switch r.Dataset {
+	case DatasetAverages:
+		var enc encoder[service.AverageResult]
+		if enc, err = newEncoder(r.Format, w, averagesTable); err != nil {
+			return err
+		}
+		err = resultsService.ExportAverageResults(ctx, r.Channel, r.From, r.To, r.Bucket, enc.write)
+		err = finish(err, enc)
+	default:
+		var enc encoder[service.Result]
+		if enc, err = newEncoder(r.Format, w, resultsTable); err != nil {
+			return err
+		}
+		err = resultsService.ExportResults(ctx, r.Channel, r.From, r.To, enc.write)
+		err = finish(err, enc)
+	}
+	if err != nil {
+		return err
+	}
+
+	if compressed != nil {
+		return compressed.Close()
+	}
+	return nil
+}
+
+// Closes the encoder if the rows were written, flushing what it buffered
+func finish[T any](err error, enc encoder[T]) error {
+	if err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+// Columns of the rows of a dataset and their values, in the order of the columns
+type table[T any] struct {
+	columns []string
+	values  func(T) []any
+	// Object written as JSON
+	object func(T) any
+	// Row written to Parquet files
+	parquetRow func(T) any
+}
+
+var resultsTable = table[service.Result]{
+	columns: []string{"channel", "user", "message", "message_id", "timestamp", "sentiment_positive", "sentiment_neutral", "sentiment_negative"},
+	values: func(r service.Result) []any {
+		return []any{r.Channel, r.User, r.Message, r.MessageId, r.Timestamp, r.PositiveSentiment, r.NeutralSentiment, r.NegativeSentiment}
+	},
+	object: func(r service.Result) any {
+		return struct {
+			Channel string `json:"channel"`
+			service.Result
+		}{Channel: r.Channel, Result: r}
+	},
+	parquetRow: func(r service.Result) any {
+		return resultParquetRow{r.Channel, r.User, r.Message, r.MessageId, r.Timestamp, r.PositiveSentiment, r.NeutralSentiment, r.NegativeSentiment}
+	},
+}
+
+var averagesTable = table[service.AverageResult]{
+	columns: []string{"channel", "timestamp", "messages", "avg_sentiment_positive", "avg_sentiment_neutral", "avg_sentiment_negative"},
+	values: func(a service.AverageResult) []any {
+		return []any{a.Channel, a.Timestamp, a.Messages, a.AveragePositiveSentiment, a.AverageNeutralSentiment, a.AverageNegativeSentiment}
+	},
+	object: func(a service.AverageResult) any {
+		return struct {
+			Channel string `json:"channel"`
+			service.AverageResult
+		}{Channel: a.Channel, AverageResult: a}
+	},
+	parquetRow: func(a service.AverageResult) any {
+		return averageParquetRow{a.Channel, a.Timestamp, a.Messages, a.AveragePositiveSentiment, a.AverageNeutralSentiment, a.AverageNegativeSentiment}
+	},
+}
+
+// Formats a value of a row as a CSV field
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatFloat(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// Writes the rows of a dataset in a format
+type encoder[T any] interface {
+	write(row T) error
+	close() error
+}
+
+func newEncoder[T any](format Format, w io.Writer, t table[T]) (encoder[T], error) {
+	switch format {
+	case FormatCSV:
+		enc := &csvEncoder[T]{w: csv.NewWriter(w), values: t.values}
+		return enc, enc.w.Write(t.columns)
+	case FormatNDJSON:
+		return &ndjsonEncoder[T]{enc: json.NewEncoder(w), object: t.object}, nil
+	case FormatParquet:
+		return newParquetEncoder(w, t), nil
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+type csvEncoder[T any] struct {
+	w      *csv.Writer
+	values func(T) []any
+	record []string
+}
+
+func (e *csvEncoder[T]) write(row T) error {
+	e.record = e.record[:0]
+	for _, value := range e.values(row) {
+		e.record = append(e.record, formatValue(value))
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder[T]) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonEncoder[T any] struct {
+	enc    *json.Encoder
+	object func(T) any
+}
+
+func (e *ndjsonEncoder[T]) write(row T) error {
+	return e.enc.Encode(e.object(row))
+}
+
+func (e *ndjsonEncoder[T]) close() error {
+	return nil
+}
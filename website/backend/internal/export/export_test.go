@@ -0,0 +1,138 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+	"website/internal/service"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	moment  = time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC)
+	results = []service.Result{
+		{Channel: "gaules", User: "user0", Message: "hello, \"chat\"", MessageId: "msg-0", Timestamp: moment, PositiveSentiment: 0.8, NeutralSentiment: 0.15, NegativeSentiment: 0.05},
+		{Channel: "gaules", User: "user1", Message: "bye", MessageId: "msg-1", Timestamp: moment.Add(1500 * time.Millisecond), PositiveSentiment: 0.1, NeutralSentiment: 0.2, NegativeSentiment: 0.7},
+	}
+)
+
+func encode[T any](t *testing.T, format Format, table table[T], rows []T) []byte {
+	var b bytes.Buffer
+	enc, err := newEncoder(format, &b, table)
+	require.NoError(t, err)
+	for _, row := range rows {
+		require.NoError(t, enc.write(row))
+	}
+	require.NoError(t, enc.close())
+	return b.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	format, err := ParseFormat("parquet")
+	require.NoError(t, err)
+	require.Equal(t, FormatParquet, format)
+	_, err = ParseFormat("xlsx")
+	require.Error(t, err)
+
+	dataset, err := ParseDataset("averages")
+	require.NoError(t, err)
+	require.Equal(t, DatasetAverages, dataset)
+	_, err = ParseDataset("users")
+	require.Error(t, err)
+}
+
+func TestFilename(t *testing.T) {
+	request := Request{Dataset: DatasetResults, Channel: "gaules", From: moment, To: moment.Add(time.Hour), Format: FormatCSV}
+	require.Equal(t, "gaules-results-20241201T1400Z-20241201T1500Z.csv", request.Filename())
+	require.Equal(t, "text/csv", request.ContentType())
+
+	request = Request{Dataset: DatasetAverages, Channel: "gaules", From: moment, To: moment.Add(time.Hour), Bucket: service.BucketHour, Format: FormatParquet, Gzip: true}
+	require.Equal(t, "gaules-averages-hour-20241201T1400Z-20241201T1500Z.parquet.gz", request.Filename())
+	require.Equal(t, "application/gzip", request.ContentType())
+}
+
+func TestCSV(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(encode(t, FormatCSV, resultsTable, results))).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"channel", "user", "message", "message_id", "timestamp", "sentiment_positive", "sentiment_neutral", "sentiment_negative"},
+		{"gaules", "user0", "hello, \"chat\"", "msg-0", "2024-12-01T14:00:00Z", "0.8", "0.15", "0.05"},
+		{"gaules", "user1", "bye", "msg-1", "2024-12-01T14:00:01.5Z", "0.1", "0.2", "0.7"},
+	}, records)
+
+	// Empty exports still have the header
+	records, err = csv.NewReader(bytes.NewReader(encode(t, FormatCSV, averagesTable, nil))).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"channel", "timestamp", "messages", "avg_sentiment_positive", "avg_sentiment_neutral", "avg_sentiment_negative"}}, records)
+}
+
+func TestNDJSON(t *testing.T) {
+	averages := []service.AverageResult{{Channel: "gaules", Timestamp: moment, Messages: 3, AveragePositiveSentiment: 0.5}}
+	decoder := json.NewDecoder(bytes.NewReader(encode(t, FormatNDJSON, averagesTable, averages)))
+
+	var object map[string]any
+	require.NoError(t, decoder.Decode(&object))
+	require.Equal(t, map[string]any{
+		"channel":                "gaules",
+		"timestamp":              "2024-12-01T14:00:00Z",
+		"messages":               3.0,
+		"avg_sentiment_positive": 0.5,
+		"avg_sentiment_neutral":  0.0,
+		"avg_sentiment_negative": 0.0,
+	}, object)
+	require.ErrorIs(t, decoder.Decode(&object), io.EOF)
+}
+
+func TestParquet(t *testing.T) {
+	rows := []service.Result{}
+	for range parquetRowGroupRows/2 + 1 {
+		rows = append(rows, results...)
+	}
+	file := encode(t, FormatParquet, resultsTable, rows)
+
+	f, err := parquet.OpenFile(bytes.NewReader(file), int64(len(file)))
+	require.NoError(t, err)
+	require.EqualValues(t, len(rows), f.NumRows())
+	columns := []string{}
+	for _, field := range f.Schema().Fields() {
+		columns = append(columns, field.Name())
+	}
+	require.Equal(t, resultsTable.columns, columns)
+
+	// Two row groups, the second with the rows left
+	groups := f.Metadata().RowGroups
+	require.Len(t, groups, 2)
+	require.EqualValues(t, parquetRowGroupRows, groups[0].NumRows)
+	require.EqualValues(t, 2, groups[1].NumRows)
+	require.Equal(t, format.Gzip, groups[1].Columns[0].MetaData.Codec)
+
+	reader := parquet.NewGenericReader[resultParquetRow](bytes.NewReader(file))
+	defer reader.Close()
+	read := make([]resultParquetRow, len(rows))
+	n, err := reader.Read(read)
+	if !errors.Is(err, io.EOF) {
+		require.NoError(t, err)
+	}
+	require.Equal(t, len(rows), n)
+	for i, row := range read[len(rows)-2:] {
+		expected := results[i]
+		require.Equal(t, expected.Message, row.Message)
+		require.Equal(t, expected.MessageId, row.MessageId)
+		require.True(t, expected.Timestamp.Equal(row.Timestamp), row.Timestamp)
+		require.Equal(t, expected.NegativeSentiment, row.NegativeSentiment)
+	}
+
+	// Empty exports are still valid files
+	file = encode(t, FormatParquet, averagesTable, nil)
+	f, err = parquet.OpenFile(bytes.NewReader(file), int64(len(file)))
+	require.NoError(t, err)
+	require.Zero(t, f.NumRows())
+	require.Len(t, f.Schema().Fields(), len(averagesTable.columns))
+}
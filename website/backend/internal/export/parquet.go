@@ -0,0 +1,60 @@
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Rows buffered before they are written as a row group, bounding the memory of an export
+const parquetRowGroupRows = 10_000
+
+// Rows of the Parquet files, with their columns in the order of the table columns
+type resultParquetRow struct {
+	Channel           string    `parquet:"channel"`
+	User              string    `parquet:"user"`
+	Message           string    `parquet:"message"`
+	MessageId         string    `parquet:"message_id"`
+	Timestamp         time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	PositiveSentiment float64   `parquet:"sentiment_positive"`
+	NeutralSentiment  float64   `parquet:"sentiment_neutral"`
+	NegativeSentiment float64   `parquet:"sentiment_negative"`
+}
+
+type averageParquetRow struct {
+	Channel                  string    `parquet:"channel"`
+	Timestamp                time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	Messages                 int64     `parquet:"messages"`
+	AveragePositiveSentiment float64   `parquet:"avg_sentiment_positive"`
+	AverageNeutralSentiment  float64   `parquet:"avg_sentiment_neutral"`
+	AverageNegativeSentiment float64   `parquet:"avg_sentiment_negative"`
+}
+
+// Writes gzip compressed row groups of parquetRowGroupRows rows
+type parquetEncoder[T any] struct {
+	w   *parquet.Writer
+	row func(T) any
+}
+
+func newParquetEncoder[T any](w io.Writer, t table[T]) *parquetEncoder[T] {
+	var zero T
+	return &parquetEncoder[T]{
+		w: parquet.NewWriter(w,
+			parquet.SchemaOf(t.parquetRow(zero)),
+			parquet.Compression(&parquet.Gzip),
+			parquet.MaxRowsPerRowGroup(parquetRowGroupRows),
+			parquet.CreatedBy("twitch-chat-sentiment-analysis", "", ""),
+		),
+		row: t.parquetRow,
+	}
+}
+
+func (e *parquetEncoder[T]) write(row T) error {
+	return e.w.Write(e.row(row))
+}
+
+// Writes the remaining rows and the file metadata
+func (e *parquetEncoder[T]) close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,148 @@
+package exporter
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"website/internal/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultInterval = 15 * time.Second
+	defaultWindow   = 5 * time.Minute
+	maxWindow       = time.Hour
+)
+
+var (
+	channelPattern = regexp.MustCompile(`^[a-zA-Z0-9_]{1,100}$`)
+
+	// Average sentiment of the messages of the channel in the window, weighted by the messages of each minute
+	averageGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "channel_sentiment_average",
+	}, []string{"channel", "sentiment"})
+
+	// Analyzed messages per second of the channel in the window
+	messageRateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "channel_message_rate",
+	}, []string{"channel"})
+
+	// Average positive minus negative sentiment of the channel in the window, from -1 to 1
+	indexGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "channel_sentiment_index",
+	}, []string{"channel"})
+
+	updateErrorsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "channel_sentiment_update_errors_total",
+	})
+)
+
+type moodSource interface {
+	GetChannelsMood(ctx context.Context, channels []string, window int, now time.Time) (map[string]service.Mood, error)
+}
+
+// Exports the sentiment of the allowed channels over a rolling window as Prometheus gauges.
+// Only the channels of the allow-list get series, so their number is bounded.
+type Exporter struct {
+	moods    moodSource
+	logger   *zap.SugaredLogger
+	channels []string
+	window   time.Duration
+	interval time.Duration
+}
+
+func NewExporter(resultsService *service.ResultsService, logger *zap.SugaredLogger) *Exporter {
+	e := &Exporter{
+		moods:    resultsService,
+		logger:   logger,
+		window:   durationFromEnv("SENTIMENT_METRICS_WINDOW", defaultWindow, logger),
+		interval: durationFromEnv("SENTIMENT_METRICS_INTERVAL", defaultInterval, logger),
+	}
+	if e.window < time.Minute || e.window > maxWindow || e.window%time.Minute != 0 {
+		logger.Fatalf("Invalid SENTIMENT_METRICS_WINDOW %s, expected whole minutes up to %s", e.window, maxWindow)
+	}
+	if e.interval == 0 {
+		logger.Fatal("Invalid SENTIMENT_METRICS_INTERVAL, expected more than 0")
+	}
+
+	// Comma separated allow-list, like "gaules,loud_coringa"
+	for _, channel := range strings.Split(os.Getenv("SENTIMENT_METRICS_CHANNELS"), ",") {
+		channel = strings.TrimSpace(channel)
+		if channel == "" {
+			continue
+		}
+		if !channelPattern.MatchString(channel) {
+			logger.Fatalf("Invalid channel %q in SENTIMENT_METRICS_CHANNELS", channel)
+		}
+		e.channels = append(e.channels, channel)
+	}
+	return e
+}
+
+func durationFromEnv(name string, fallback time.Duration, logger *zap.SugaredLogger) time.Duration {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		logger.Fatalf("Invalid %s %q", name, value)
+	}
+	return duration
+}
+
+func (e *Exporter) Enabled() bool {
+	return len(e.channels) > 0
+}
+
+// Updates the gauges every interval until the context is done
+func (e *Exporter) Start(ctx context.Context) {
+	e.logger.Infof("Exporting the sentiment of %d channels over %s every %s", len(e.channels), e.window, e.interval)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	e.update(ctx, time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			e.update(ctx, now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sets the gauges of every channel, keeping the last values when the moods can not be read
+func (e *Exporter) update(ctx context.Context, now time.Time) {
+	window := int(e.window / time.Minute)
+	moods, err := e.moods.GetChannelsMood(ctx, e.channels, window, now)
+	if err != nil {
+		updateErrorsCounter.Inc()
+		e.logger.Errorf("Failed to read the channels mood: %v", err)
+		return
+	}
+
+	// The window ends with the current minute, which has only run until now
+	elapsed := e.window - now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+	for _, channel := range e.channels {
+		mood := moods[channel]
+		messageRateGauge.WithLabelValues(channel).Set(float64(mood.Messages) / elapsed.Seconds())
+
+		// Averages of quiet channels are unknown rather than zero
+		if mood.Messages == 0 {
+			averageGauge.DeletePartialMatch(prometheus.Labels{"channel": channel})
+			indexGauge.DeleteLabelValues(channel)
+			continue
+		}
+		averageGauge.WithLabelValues(channel, "positive").Set(mood.AveragePositiveSentiment)
+		averageGauge.WithLabelValues(channel, "neutral").Set(mood.AverageNeutralSentiment)
+		averageGauge.WithLabelValues(channel, "negative").Set(mood.AverageNegativeSentiment)
+		indexGauge.WithLabelValues(channel).Set(mood.Score)
+	}
+}
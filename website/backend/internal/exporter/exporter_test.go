@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"website/internal/service"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = zap.NewNop().Sugar()
+}
+
+type fakeMoods struct {
+	moods map[string]service.Mood
+	err   error
+}
+
+func (f *fakeMoods) GetChannelsMood(ctx context.Context, channels []string, window int, now time.Time) (map[string]service.Mood, error) {
+	return f.moods, f.err
+}
+
+func TestNewExporter(t *testing.T) {
+	t.Setenv("SENTIMENT_METRICS_CHANNELS", " gaules, loud_coringa,")
+	t.Setenv("SENTIMENT_METRICS_WINDOW", "10m")
+	e := NewExporter(nil, logger)
+	require.True(t, e.Enabled())
+	require.Equal(t, []string{"gaules", "loud_coringa"}, e.channels)
+	require.Equal(t, 10*time.Minute, e.window)
+	require.Equal(t, defaultInterval, e.interval)
+
+	t.Setenv("SENTIMENT_METRICS_CHANNELS", "")
+	require.False(t, NewExporter(nil, logger).Enabled())
+}
+
+func TestUpdate(t *testing.T) {
+	moods := &fakeMoods{moods: map[string]service.Mood{
+		"gaules": {Messages: 270, AveragePositiveSentiment: 0.6, AverageNeutralSentiment: 0.3, AverageNegativeSentiment: 0.1, Score: 0.5},
+	}}
+	e := &Exporter{moods: moods, logger: logger, channels: []string{"gaules", "quiet"}, window: 5 * time.Minute}
+
+	// 30 seconds into the last minute of the window
+	now := time.Date(2024, 12, 1, 14, 0, 30, 0, time.UTC)
+	e.update(context.Background(), now)
+	require.Equal(t, 1.0, testutil.ToFloat64(messageRateGauge.WithLabelValues("gaules")))
+	require.Equal(t, 0.6, testutil.ToFloat64(averageGauge.WithLabelValues("gaules", "positive")))
+	require.Equal(t, 0.1, testutil.ToFloat64(averageGauge.WithLabelValues("gaules", "negative")))
+	require.Equal(t, 0.5, testutil.ToFloat64(indexGauge.WithLabelValues("gaules")))
+	require.Equal(t, 0.0, testutil.ToFloat64(messageRateGauge.WithLabelValues("quiet")))
+	require.Equal(t, 3, testutil.CollectAndCount(averageGauge))
+	require.Equal(t, 1, testutil.CollectAndCount(indexGauge))
+
+	// Failed updates keep the last values
+	moods.err = errors.New("connection refused")
+	e.update(context.Background(), now)
+	require.Equal(t, 0.5, testutil.ToFloat64(indexGauge.WithLabelValues("gaules")))
+
+	// Channels that turn quiet lose their averages
+	moods.moods, moods.err = map[string]service.Mood{}, nil
+	e.update(context.Background(), now)
+	require.Equal(t, 0.0, testutil.ToFloat64(messageRateGauge.WithLabelValues("gaules")))
+	require.Equal(t, 0, testutil.CollectAndCount(averageGauge))
+	require.Equal(t, 0, testutil.CollectAndCount(indexGauge))
+}
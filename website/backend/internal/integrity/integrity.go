@@ -0,0 +1,73 @@
+package integrity
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Message stored more than once in the results table
+type Duplicate struct {
+	Channel   string
+	MessageId string
+	Copies    int64
+}
+
+func (d Duplicate) String() string {
+	return fmt.Sprintf("%s %s stored %d times", d.Channel, d.MessageId, d.Copies)
+}
+
+type Report struct {
+	// Messages stored more than once
+	Messages int64
+	// Rows beyond the first copy of each message
+	ExtraRows int64
+	// Messages with the most copies, up to the limit
+	Duplicates []Duplicate
+}
+
+func (r Report) String() string {
+	if r.Messages == 0 {
+		return "No duplicate results"
+	}
+	return fmt.Sprintf("%d messages are stored more than once, %d extra rows", r.Messages, r.ExtraRows)
+}
+
+// Finds the results that share a channel and message id, the key the inserts are deduplicated by
+func FindDuplicates(ctx context.Context, pool *pgxpool.Pool, limit int) (Report, error) {
+	report := Report{Duplicates: []Duplicate{}}
+
+	rows, err := pool.Query(ctx, `
+WITH duplicates AS (
+    SELECT
+        channel, message_id, COUNT(*) AS copies
+    FROM
+        results
+    GROUP BY
+        channel, message_id
+    HAVING
+        COUNT(*) > 1
+)
+SELECT
+    channel, message_id, copies, COUNT(*) OVER (), SUM(copies - 1) OVER ()::bigint
+FROM
+    duplicates
+ORDER BY
+    copies DESC, channel, message_id
+LIMIT $1;
+`, limit)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var duplicate Duplicate
+		if err := rows.Scan(&duplicate.Channel, &duplicate.MessageId, &duplicate.Copies, &report.Messages, &report.ExtraRows); err != nil {
+			return report, err
+		}
+		report.Duplicates = append(report.Duplicates, duplicate)
+	}
+	return report, rows.Err()
+}
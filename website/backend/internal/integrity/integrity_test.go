@@ -0,0 +1,52 @@
+package integrity
+
+import (
+	"context"
+	"testing"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = zap.NewNop().Sugar()
+}
+
+func TestFindDuplicates(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	require.NoError(t, testutils.PopulateDatabase(dsn, 2, 3))
+
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+
+	report, err := FindDuplicates(ctx, conn, 10)
+	require.NoError(t, err)
+	require.Equal(t, Report{Duplicates: []Duplicate{}}, report)
+
+	// Duplicates stored before the unique key and the deduplication existed
+	_, err = conn.Exec(ctx, `ALTER TABLE results DROP CONSTRAINT results_message_key`)
+	require.NoError(t, err)
+	_, err = conn.Exec(ctx, `DROP TRIGGER results_deduplicate ON results`)
+	require.NoError(t, err)
+	require.NoError(t, testutils.PopulateDatabase(dsn, 1, 2))
+	require.NoError(t, testutils.PopulateDatabase(dsn, 1, 1))
+
+	report, err = FindDuplicates(ctx, conn, 1)
+	require.NoError(t, err)
+	require.Equal(t, Report{
+		Messages:   2,
+		ExtraRows:  3,
+		Duplicates: []Duplicate{{Channel: "channel0", MessageId: "msg-0000", Copies: 3}},
+	}, report)
+}
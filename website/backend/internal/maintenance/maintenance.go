@@ -0,0 +1,314 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPartition = "results_default"
+	partitionLayout  = "20060102"
+	// Tombstones of moderated messages, kept as long as the raw results they redact
+	moderationTable = "moderated_messages"
+	// Keys of the stored messages that skip redeliveries, kept as long as the raw results
+	messageIDsTable = "result_message_ids"
+	day             = 24 * time.Hour
+
+	// Key of the advisory lock held while the maintenance runs
+	maintenanceLockKey = 7_245_002
+)
+
+var (
+	ErrAlreadyRunning = errors.New("another maintenance is running")
+
+	partitionPattern = regexp.MustCompile(`^results_p(\d{8})$`)
+)
+
+// Rollup tables, finest first
+var rollupTables = []string{"results_rollup_1m", "results_rollup_1h", "results_rollup_1d"}
+
+type Config struct {
+	// Days of raw results kept
+	RawDays int
+	// Days kept of each rollup table, 0 keeps them forever
+	RollupDays map[string]int
+	// Days of partitions created ahead of time
+	PremakeDays int
+	// Reports the actions without applying them
+	DryRun bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		RawDays: 30,
+		RollupDays: map[string]int{
+			"results_rollup_1m": 90,
+			"results_rollup_1h": 730,
+			"results_rollup_1d": 0,
+		},
+		PremakeDays: 7,
+	}
+}
+
+type ActionKind string
+
+const (
+	CreatePartition ActionKind = "create partition"
+	DropPartition   ActionKind = "drop partition"
+	DeleteRows      ActionKind = "delete rows"
+)
+
+type Action struct {
+	Kind ActionKind
+	// Partition or table changed
+	Table string
+	// Range of the partition, or the end of the rows to delete
+	From time.Time
+	To   time.Time
+
+	// Set once the action is applied, or estimated on dry runs
+	Rows int64
+	// Why the action was not applied
+	Skipped string
+}
+
+func (a Action) String() string {
+	var description string
+	switch a.Kind {
+	case CreatePartition:
+		description = fmt.Sprintf("create partition %s for [%s, %s), %d rows moved from %s",
+			a.Table, a.From.Format(time.DateOnly), a.To.Format(time.DateOnly), a.Rows, defaultPartition)
+	case DropPartition:
+		description = fmt.Sprintf("drop partition %s with %d rows", a.Table, a.Rows)
+	default:
+		description = fmt.Sprintf("delete %d rows of %s before %s", a.Rows, a.Table, a.To.Format(time.RFC3339))
+	}
+	if a.Skipped != "" {
+		return "skip " + description + ": " + a.Skipped
+	}
+	return description
+}
+
+type Report struct {
+	DryRun  bool
+	Actions []Action
+}
+
+type partition struct {
+	name string
+	from time.Time
+}
+
+func partitionName(from time.Time) string {
+	return "results_p" + from.Format(partitionLayout)
+}
+
+// Actions that bring the partitions and tables to the configured retention at now
+func plan(config Config, partitioned bool, partitions []partition, now time.Time) []Action {
+	today := now.UTC().Truncate(day)
+	cutoff := today.AddDate(0, 0, -config.RawDays)
+	actions := []Action{}
+
+	if partitioned {
+		existing := map[string]bool{}
+		for _, p := range partitions {
+			existing[p.name] = true
+			if !p.from.Add(day).After(cutoff) {
+				actions = append(actions, Action{Kind: DropPartition, Table: p.name, From: p.from, To: p.from.Add(day)})
+			}
+		}
+		for from := cutoff; !from.After(today.AddDate(0, 0, config.PremakeDays)); from = from.Add(day) {
+			if name := partitionName(from); !existing[name] {
+				actions = append(actions, Action{Kind: CreatePartition, Table: name, From: from, To: from.Add(day)})
+			}
+		}
+		actions = append(actions, Action{Kind: DeleteRows, Table: defaultPartition, To: cutoff})
+	} else {
+		actions = append(actions, Action{Kind: DeleteRows, Table: "results", To: cutoff})
+	}
+	actions = append(actions, Action{Kind: DeleteRows, Table: moderationTable, To: cutoff})
+	actions = append(actions, Action{Kind: DeleteRows, Table: messageIDsTable, To: cutoff})
+
+	for _, table := range rollupTables {
+		if days := config.RollupDays[table]; days > 0 {
+			actions = append(actions, Action{Kind: DeleteRows, Table: table, To: today.AddDate(0, 0, -days)})
+		}
+	}
+
+	return actions
+}
+
+// Applies the retention of the config, or only reports what would be done on dry runs
+func Run(ctx context.Context, pool *pgxpool.Pool, config Config, now time.Time, logger *zap.SugaredLogger) (Report, error) {
+	report := Report{DryRun: config.DryRun}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", maintenanceLockKey).Scan(&locked); err != nil {
+		return report, err
+	}
+	if !locked {
+		return report, ErrAlreadyRunning
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", maintenanceLockKey)
+
+	var partitioned bool
+	if err := conn.QueryRow(ctx, `SELECT relkind = 'p' FROM pg_class WHERE oid = 'results'::regclass`).Scan(&partitioned); err != nil {
+		return report, err
+	}
+	if !partitioned {
+		logger.Warn("The results table is not partitioned, old results are deleted row by row")
+	}
+	partitions, err := listPartitions(ctx, conn.Conn())
+	if err != nil {
+		return report, err
+	}
+
+	for _, action := range plan(config, partitioned, partitions, now) {
+		if err := apply(ctx, conn.Conn(), &action, config.DryRun); err != nil {
+			return report, fmt.Errorf("failed to %s %s: %w", action.Kind, action.Table, err)
+		}
+		logger.Infof("%s", action)
+		report.Actions = append(report.Actions, action)
+	}
+
+	return report, nil
+}
+
+// Daily partitions of the results table, oldest first
+func listPartitions(ctx context.Context, conn *pgx.Conn) ([]partition, error) {
+	rows, err := conn.Query(ctx, `
+SELECT
+    c.relname
+FROM
+    pg_inherits i
+    JOIN pg_class c ON c.oid = i.inhrelid
+WHERE
+    i.inhparent = 'results'::regclass;
+`)
+	if err != nil {
+		return nil, err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := []partition{}
+	for _, name := range names {
+		match := partitionPattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		from, err := time.Parse(partitionLayout, match[1])
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, partition{name: name, from: from})
+	}
+	slices.SortFunc(partitions, func(a, b partition) int { return a.from.Compare(b.from) })
+	return partitions, nil
+}
+
+func apply(ctx context.Context, conn *pgx.Conn, action *Action, dryRun bool) error {
+	switch action.Kind {
+	case CreatePartition:
+		if dryRun {
+			return conn.QueryRow(ctx, `SELECT COUNT(*) FROM `+defaultPartition+` WHERE "timestamp" >= $1 AND "timestamp" < $2`,
+				action.From, action.To).Scan(&action.Rows)
+		}
+		// Rows of the new range must leave the default partition before the new one is attached
+		return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			table := pgx.Identifier{action.Table}.Sanitize()
+			_, err := tx.Exec(ctx, `CREATE TABLE `+table+` (LIKE results INCLUDING DEFAULTS INCLUDING CONSTRAINTS INCLUDING GENERATED)`)
+			if err != nil {
+				return err
+			}
+			// Generated columns are computed again by the insert
+			var columns string
+			err = tx.QueryRow(ctx, `
+SELECT
+    string_agg(quote_ident(attname), ', ' ORDER BY attnum)
+FROM
+    pg_attribute
+WHERE
+    attrelid = 'results'::regclass AND attnum > 0 AND NOT attisdropped AND attgenerated = '';
+`).Scan(&columns)
+			if err != nil {
+				return err
+			}
+			tag, err := tx.Exec(ctx, `
+WITH moved AS (
+    DELETE FROM `+defaultPartition+` WHERE "timestamp" >= $1 AND "timestamp" < $2 RETURNING `+columns+`
+)
+INSERT INTO `+table+` (`+columns+`) SELECT `+columns+` FROM moved;
+`, action.From, action.To)
+			if err != nil {
+				return err
+			}
+			action.Rows = tag.RowsAffected()
+			// Partition bounds do not accept parameters
+			_, err = tx.Exec(ctx, fmt.Sprintf(`ALTER TABLE results ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`,
+				table, action.From.Format(time.RFC3339), action.To.Format(time.RFC3339)))
+			return err
+		})
+
+	case DropPartition:
+		table := pgx.Identifier{action.Table}.Sanitize()
+		if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM `+table).Scan(&action.Rows); err != nil {
+			return err
+		}
+		// Results are dropped only once they are counted in the rollups
+		var pending int64
+		err := conn.QueryRow(ctx, `
+SELECT COUNT(*) FROM `+table+` WHERE ingested_at > (SELECT watermark FROM rollup_watermarks WHERE name = 'results')
+`).Scan(&pending)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			action.Skipped = fmt.Sprintf("%d rows are not rolled up yet", pending)
+			return nil
+		}
+		if dryRun {
+			return nil
+		}
+		_, err = conn.Exec(ctx, `DROP TABLE `+table)
+		return err
+
+	default:
+		table := pgx.Identifier{action.Table}.Sanitize()
+		column := `"timestamp"`
+		condition := ""
+		if slices.Contains(rollupTables, action.Table) {
+			column = "bucket"
+		} else if action.Table == moderationTable {
+			column = "moderated_at"
+		} else if action.Table != messageIDsTable {
+			condition = ` AND ingested_at <= (SELECT watermark FROM rollup_watermarks WHERE name = 'results')`
+		}
+		if dryRun {
+			return conn.QueryRow(ctx, `SELECT COUNT(*) FROM `+table+` WHERE `+column+` < $1`+condition, action.To).
+				Scan(&action.Rows)
+		}
+		tag, err := conn.Exec(ctx, `DELETE FROM `+table+` WHERE `+column+` < $1`+condition, action.To)
+		if err != nil {
+			return err
+		}
+		action.Rows = tag.RowsAffected()
+		return nil
+	}
+}
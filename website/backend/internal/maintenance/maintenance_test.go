@@ -0,0 +1,109 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/rollup"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = zap.NewNop().Sugar()
+}
+
+func TestPlan(t *testing.T) {
+	config := Config{RawDays: 2, PremakeDays: 1, RollupDays: map[string]int{"results_rollup_1m": 10}}
+	now := time.Date(2024, 12, 10, 15, 30, 0, 0, time.UTC)
+	date := func(day int) time.Time { return time.Date(2024, 12, day, 0, 0, 0, 0, time.UTC) }
+	partitions := []partition{
+		{name: "results_p20241207", from: date(7)},
+		{name: "results_p20241208", from: date(8)},
+		{name: "results_p20241209", from: date(9)},
+	}
+
+	actions := plan(config, true, partitions, now)
+	require.Equal(t, []Action{
+		{Kind: DropPartition, Table: "results_p20241207", From: date(7), To: date(8)},
+		{Kind: CreatePartition, Table: "results_p20241210", From: date(10), To: date(11)},
+		{Kind: CreatePartition, Table: "results_p20241211", From: date(11), To: date(12)},
+		{Kind: DeleteRows, Table: "results_default", To: date(8)},
+		{Kind: DeleteRows, Table: "moderated_messages", To: date(8)},
+		{Kind: DeleteRows, Table: "result_message_ids", To: date(8)},
+		{Kind: DeleteRows, Table: "results_rollup_1m", To: date(0)},
+	}, actions)
+
+	actions = plan(config, false, nil, now)
+	require.Equal(t, []Action{
+		{Kind: DeleteRows, Table: "results", To: date(8)},
+		{Kind: DeleteRows, Table: "moderated_messages", To: date(8)},
+		{Kind: DeleteRows, Table: "result_message_ids", To: date(8)},
+		{Kind: DeleteRows, Table: "results_rollup_1m", To: date(0)},
+	}, actions)
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	require.NoError(t, testutils.PopulateDatabase(dsn, 2, 3))
+
+	t.Setenv("DATABASE_DSN", dsn)
+	t.Setenv("ROLLUP_LAG", "0s")
+	conn := database.NewDatabaseConnection(logger)
+
+	config := DefaultConfig()
+	config.RawDays = 2
+	config.PremakeDays = 1
+	now := time.Date(2024, 12, 2, 12, 0, 0, 0, time.UTC)
+	count := func(table string) int64 {
+		var rows int64
+		require.NoError(t, conn.QueryRow(ctx, "SELECT COUNT(*) FROM "+table).Scan(&rows))
+		return rows
+	}
+
+	config.DryRun = true
+	report, err := Run(ctx, conn, config, now, logger)
+	require.NoError(t, err)
+	require.True(t, report.DryRun)
+	require.Len(t, report.Actions, 9)
+	require.Equal(t, "results_p20241201", report.Actions[1].Table)
+	require.EqualValues(t, 6, report.Actions[1].Rows)
+	require.EqualValues(t, 6, count("results_default"))
+
+	// The results move to their partition
+	config.DryRun = false
+	report, err = Run(ctx, conn, config, now, logger)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, count("results_default"))
+	require.EqualValues(t, 6, count("results_p20241201"))
+	require.EqualValues(t, 6, count("results"))
+
+	// Partitions out of the retention are kept until their results are rolled up
+	now = now.AddDate(0, 0, 3)
+	report, err = Run(ctx, conn, config, now, logger)
+	require.NoError(t, err)
+	require.Equal(t, DropPartition, report.Actions[1].Kind)
+	require.Equal(t, "results_p20241201", report.Actions[1].Table)
+	require.Equal(t, "6 rows are not rolled up yet", report.Actions[1].Skipped)
+	require.EqualValues(t, 6, count("results"))
+
+	_, err = rollup.NewJob(conn, logger).Run(ctx)
+	require.NoError(t, err)
+	report, err = Run(ctx, conn, config, now, logger)
+	require.NoError(t, err)
+	require.Empty(t, report.Actions[0].Skipped)
+	require.EqualValues(t, 0, count("results"))
+	require.EqualValues(t, 2, count("results_rollup_1h"))
+}
@@ -0,0 +1,281 @@
+package migrate
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Key of the advisory lock held while migrations are applied
+const migrateLockKey = 7_245_003
+
+var (
+	//go:embed migrations/*.sql
+	migrationFiles embed.FS
+
+	filePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migration applied or reverted
+type Step struct {
+	Migration
+	Revert bool
+}
+
+func (s Step) String() string {
+	if s.Revert {
+		return "down " + s.Migration.String()
+	}
+	return "up " + s.Migration.String()
+}
+
+// Migrations of the migrations directory, each with an up and a down file, ordered by version
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := filePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid migration version in %q", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %q and %q", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %s needs an up and a down file", migration)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return int(a.Version - b.Version) })
+	return migrations, nil
+}
+
+// Steps that take the database from the applied versions to the target version
+func plan(migrations []Migration, applied map[int64]bool, target int64) ([]Step, error) {
+	known := map[int64]bool{0: true}
+	for _, migration := range migrations {
+		known[migration.Version] = true
+	}
+	if !known[target] {
+		return nil, fmt.Errorf("unknown version %d", target)
+	}
+	for version := range applied {
+		if !known[version] && version > target {
+			return nil, fmt.Errorf("version %d is applied but unknown to this binary", version)
+		}
+	}
+
+	steps := []Step{}
+	for _, migration := range slices.Backward(migrations) {
+		if migration.Version > target && applied[migration.Version] {
+			steps = append(steps, Step{Migration: migration, Revert: true})
+		}
+	}
+	for _, migration := range migrations {
+		if migration.Version <= target && !applied[migration.Version] {
+			steps = append(steps, Step{Migration: migration})
+		}
+	}
+	return steps, nil
+}
+
+// Applies the embedded migrations, recording them in the schema_migrations table
+type Migrator struct {
+	pool       *pgxpool.Pool
+	logger     *zap.SugaredLogger
+	migrations []Migration
+}
+
+func New(pool *pgxpool.Pool, logger *zap.SugaredLogger) *Migrator {
+	migrations, err := load(migrationFiles)
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded migrations: %v", err))
+	}
+	return &Migrator{pool: pool, logger: logger, migrations: migrations}
+}
+
+// Every known migration and whether it is applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	statuses := []Status{}
+	err := m.withLock(ctx, func(conn *pgx.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			appliedAt, found := applied[migration.Version]
+			statuses = append(statuses, Status{Migration: migration, Applied: found, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Applies every pending migration
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Reverts the latest applied migration
+func (m *Migrator) Down(ctx context.Context) ([]Step, error) {
+	return m.migrate(ctx, func(applied map[int64]bool) int64 {
+		var latest, previous int64
+		for _, migration := range m.migrations {
+			if applied[migration.Version] {
+				previous, latest = latest, migration.Version
+			}
+		}
+		return previous
+	})
+}
+
+// Applies or reverts migrations until the database is at the version, 0 reverts all of them
+func (m *Migrator) To(ctx context.Context, version int64) ([]Step, error) {
+	return m.migrate(ctx, func(map[int64]bool) int64 { return version })
+}
+
+func (m *Migrator) migrate(ctx context.Context, target func(applied map[int64]bool) int64) ([]Step, error) {
+	done := []Step{}
+	err := m.withLock(ctx, func(conn *pgx.Conn) error {
+		appliedAt, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		applied := map[int64]bool{}
+		for version := range appliedAt {
+			applied[version] = true
+		}
+
+		steps, err := plan(m.migrations, applied, target(applied))
+		if err != nil {
+			return err
+		}
+		for _, step := range steps {
+			if err := apply(ctx, conn, step); err != nil {
+				return fmt.Errorf("failed to %s: %w", step, err)
+			}
+			m.logger.Infof("Migrated %s", step)
+			done = append(done, step)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Runs the function holding the migration lock, waiting for other migrations to finish
+func (m *Migrator) withLock(ctx context.Context, f func(conn *pgx.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrateLockKey); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrateLockKey)
+
+	_, err = conn.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version bigint PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    applied_at timestamp with time zone NOT NULL DEFAULT now()
+);
+`)
+	if err != nil {
+		return err
+	}
+
+	return f(conn.Conn())
+}
+
+func appliedVersions(ctx context.Context, conn *pgx.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int64]time.Time{}
+	var version int64
+	var appliedAt time.Time
+	_, err = pgx.ForEachRow(rows, []any{&version, &appliedAt}, func() error {
+		applied[version] = appliedAt
+		return nil
+	})
+	return applied, err
+}
+
+// Runs the migration and records it in the same transaction
+func apply(ctx context.Context, conn *pgx.Conn, step Step) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if step.Revert {
+			if _, err := tx.Exec(ctx, step.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", step.Version)
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, step.Up); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", step.Version, step.Name)
+		return err
+	})
+}
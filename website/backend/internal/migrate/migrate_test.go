@@ -0,0 +1,203 @@
+package migrate
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+	"website/internal/rollup"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = zap.NewNop().Sugar()
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := load(migrationFiles)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		require.EqualValues(t, i+1, migration.Version, "migrations are numbered without gaps")
+	}
+
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	for name, fsys := range map[string]fstest.MapFS{
+		"missing down": {"migrations/0001_a.up.sql": file},
+		"two names":    {"migrations/0001_a.up.sql": file, "migrations/0001_b.down.sql": file},
+		"bad name":     {"migrations/a.up.sql": file},
+		"zero version": {"migrations/0000_a.up.sql": file, "migrations/0000_a.down.sql": file},
+	} {
+		_, err := load(fsys)
+		require.Error(t, err, name)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	migrations := []Migration{{Version: 1, Name: "a"}, {Version: 2, Name: "b"}, {Version: 3, Name: "c"}}
+	versions := func(steps []Step) []string {
+		result := []string{}
+		for _, step := range steps {
+			result = append(result, step.String())
+		}
+		return result
+	}
+
+	steps, err := plan(migrations, map[int64]bool{}, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"up 0001_a", "up 0002_b", "up 0003_c"}, versions(steps))
+
+	steps, err = plan(migrations, map[int64]bool{1: true, 2: true, 3: true}, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"down 0003_c", "down 0002_b"}, versions(steps))
+
+	steps, err = plan(migrations, map[int64]bool{1: true, 3: true}, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"up 0002_b"}, versions(steps))
+
+	steps, err = plan(migrations, map[int64]bool{1: true}, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"down 0001_a"}, versions(steps))
+
+	_, err = plan(migrations, map[int64]bool{}, 4)
+	require.Error(t, err)
+
+	_, err = plan(migrations, map[int64]bool{1: true, 4: true}, 3)
+	require.Error(t, err)
+}
+
+// Starts an empty database, the testutils container can not be used as it applies the migrations
+func startPostgres(t *testing.T) *pgxpool.Pool {
+	ctx := context.Background()
+	postgresContainer, err := postgres.Run(
+		ctx,
+		"postgres:16.6-alpine3.20",
+		postgres.WithDatabase("twitchsentimentanalysis"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(5*time.Second)),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { testcontainers.CleanupContainer(t, postgresContainer) })
+
+	conn, err := pgxpool.New(ctx, postgresContainer.MustConnectionString(ctx))
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	conn := startPostgres(t)
+	migrator := New(conn, logger)
+	latest := migrator.migrations[len(migrator.migrations)-1].Version
+
+	steps, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, steps, len(migrator.migrations))
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		require.True(t, status.Applied, status.Migration)
+		require.False(t, status.AppliedAt.IsZero())
+	}
+
+	steps, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Empty(t, steps)
+
+	steps, err = migrator.Down(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []Step{{Migration: migrator.migrations[latest-1], Revert: true}}, steps)
+
+	// Every down migration reverts its up migration
+	steps, err = migrator.To(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, steps, len(migrator.migrations)-1)
+	var tables int
+	require.NoError(t, conn.QueryRow(ctx, `SELECT COUNT(*) FROM pg_tables WHERE schemaname = 'public' AND tablename <> 'schema_migrations'`).Scan(&tables))
+	require.Zero(t, tables)
+
+	_, err = migrator.To(ctx, latest)
+	require.NoError(t, err)
+	var partitioned bool
+	require.NoError(t, conn.QueryRow(ctx, `SELECT relkind = 'p' FROM pg_class WHERE oid = 'results'::regclass`).Scan(&partitioned))
+	require.True(t, partitioned)
+
+	_, err = migrator.To(ctx, latest+1)
+	require.Error(t, err)
+}
+
+func TestMigratorExistingDatabase(t *testing.T) {
+	ctx := context.Background()
+	conn := startPostgres(t)
+	migrator := New(conn, logger)
+
+	// Databases created by the former init script have the results table but no schema_migrations
+	_, err := conn.Exec(ctx, migrator.migrations[0].Up)
+	require.NoError(t, err)
+	insert := func(messageId string, positive float64) {
+		_, err := conn.Exec(ctx, `
+INSERT INTO results (channel, "user", "message_id", "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative)
+VALUES ('channel1', 'user1', $1, '2024-12-01T14:30:00Z', 'message', $2, 0.1, 0.1);
+`, messageId, positive)
+		require.NoError(t, err)
+	}
+	insert("msg-1", 0.8)
+	insert("msg-1", 0.8)
+
+	_, err = migrator.To(ctx, 4)
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, conn.QueryRow(ctx, `SELECT COUNT(*) FROM results_default`).Scan(&count))
+	require.Equal(t, 2, count)
+
+	// The trigger is recreated on the partitioned table
+	var triggers int
+	require.NoError(t, conn.QueryRow(ctx, `SELECT COUNT(*) FROM pg_trigger WHERE tgrelid = 'results'::regclass AND tgname = 'results_inserted'`).Scan(&triggers))
+	require.Equal(t, 1, triggers)
+
+	// Both copies are rolled up, then a third one arrives after the rollup
+	t.Setenv("ROLLUP_LAG", "0s")
+	_, err = rollup.NewJob(conn, logger).Run(ctx)
+	require.NoError(t, err)
+	insert("msg-1", 0.8)
+	insert("msg-2", 0.4)
+
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.QueryRow(ctx, `SELECT COUNT(*) FROM results`).Scan(&count))
+	require.Equal(t, 2, count)
+	for _, table := range []string{"results_rollup_1m", "results_rollup_1h", "results_rollup_1d"} {
+		var messages int64
+		var positive float64
+		require.NoError(t, conn.QueryRow(ctx, `SELECT messages, sum_positive FROM `+table).Scan(&messages, &positive))
+		require.EqualValues(t, 1, messages, table)
+		require.InDelta(t, 0.8, positive, 1e-9, table)
+	}
+
+	// Redeliveries are skipped, also when they have another timestamp
+	for _, timestamp := range []string{"2024-12-01T14:30:00Z", "2024-12-01T14:31:00Z"} {
+		_, err = conn.Exec(ctx, `
+INSERT INTO results (channel, "user", "message_id", "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative)
+VALUES ('channel1', 'user1', 'msg-2', $1, 'message', 0.4, 0.1, 0.1);
+`, timestamp)
+		require.NoError(t, err)
+	}
+	require.NoError(t, conn.QueryRow(ctx, `SELECT COUNT(*) FROM results`).Scan(&count))
+	require.Equal(t, 2, count)
+}
@@ -1,5 +1,4 @@
--- CREATE DATABASE twitchSentimentAnalysis;
-
+-- Databases created by the former init script already have this table
 CREATE TABLE IF NOT EXISTS results (
     channel VARCHAR(100) NOT NULL,
     "user" VARCHAR(100) NOT NULL,
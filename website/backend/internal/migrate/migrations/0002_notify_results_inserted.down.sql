@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS results_inserted ON results;
+
+DROP FUNCTION IF EXISTS notify_results_inserted();
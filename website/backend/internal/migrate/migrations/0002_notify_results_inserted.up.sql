@@ -0,0 +1,13 @@
+-- Notifies the channels of the inserted results once per statement
+CREATE OR REPLACE FUNCTION notify_results_inserted() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('results_inserted', COALESCE((SELECT string_agg(DISTINCT channel, ',') FROM inserted), ''));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER results_inserted
+    AFTER INSERT ON results
+    REFERENCING NEW TABLE AS inserted
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_results_inserted();
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS rollup_watermarks;
+
+DROP TABLE IF EXISTS results_rollup_1d;
+
+DROP TABLE IF EXISTS results_rollup_1h;
+
+DROP TABLE IF EXISTS results_rollup_1m;
+
+DROP INDEX IF EXISTS idx_results_ingested_at;
+
+ALTER TABLE results DROP COLUMN IF EXISTS ingested_at;
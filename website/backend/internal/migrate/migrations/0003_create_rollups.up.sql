@@ -0,0 +1,32 @@
+ALTER TABLE results ADD COLUMN IF NOT EXISTS ingested_at timestamp with time zone NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS idx_results_ingested_at ON results(ingested_at);
+
+-- Message counts and sentiment sums per channel, kept up to date by the website rollup job
+CREATE TABLE IF NOT EXISTS results_rollup_1m (
+    channel VARCHAR(100) NOT NULL,
+    bucket timestamp with time zone NOT NULL,
+    messages bigint NOT NULL,
+    sum_positive double precision NOT NULL,
+    sum_neutral double precision NOT NULL,
+    sum_negative double precision NOT NULL,
+    PRIMARY KEY (channel, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS results_rollup_1h (LIKE results_rollup_1m INCLUDING ALL);
+
+CREATE TABLE IF NOT EXISTS results_rollup_1d (LIKE results_rollup_1m INCLUDING ALL);
+
+CREATE INDEX IF NOT EXISTS idx_results_rollup_1m_bucket ON results_rollup_1m(bucket);
+
+CREATE INDEX IF NOT EXISTS idx_results_rollup_1h_bucket ON results_rollup_1h(bucket);
+
+CREATE INDEX IF NOT EXISTS idx_results_rollup_1d_bucket ON results_rollup_1d(bucket);
+
+-- Results ingested up to the watermark are included in the rollups
+CREATE TABLE IF NOT EXISTS rollup_watermarks (
+    name VARCHAR(100) PRIMARY KEY,
+    watermark timestamp with time zone NOT NULL
+);
+
+INSERT INTO rollup_watermarks (name, watermark) VALUES ('results', '-infinity') ON CONFLICT DO NOTHING;
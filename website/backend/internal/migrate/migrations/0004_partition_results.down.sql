@@ -0,0 +1,19 @@
+ALTER TABLE results RENAME TO results_partitioned;
+ALTER INDEX idx_results_channel RENAME TO idx_results_partitioned_channel;
+ALTER INDEX idx_results_timestamp RENAME TO idx_results_partitioned_timestamp;
+ALTER INDEX idx_results_ingested_at RENAME TO idx_results_partitioned_ingested_at;
+
+CREATE TABLE results (LIKE results_partitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS);
+
+CREATE INDEX idx_results_channel ON results(channel);
+CREATE INDEX idx_results_timestamp ON results(timestamp);
+CREATE INDEX idx_results_ingested_at ON results(ingested_at);
+
+INSERT INTO results SELECT * FROM results_partitioned;
+DROP TABLE results_partitioned;
+
+CREATE TRIGGER results_inserted
+    AFTER INSERT ON results
+    REFERENCING NEW TABLE AS inserted
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_results_inserted();
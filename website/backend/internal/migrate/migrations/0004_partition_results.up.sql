@@ -0,0 +1,31 @@
+-- Daily partitions are created and dropped by the website maintenance command,
+-- rows outside of them are kept in results_default until their partition is created
+DO $$
+BEGIN
+    IF (SELECT relkind FROM pg_class WHERE oid = 'results'::regclass) = 'p' THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE results RENAME TO results_unpartitioned;
+    ALTER INDEX idx_results_channel RENAME TO idx_results_unpartitioned_channel;
+    ALTER INDEX idx_results_timestamp RENAME TO idx_results_unpartitioned_timestamp;
+    ALTER INDEX idx_results_ingested_at RENAME TO idx_results_unpartitioned_ingested_at;
+
+    CREATE TABLE results (LIKE results_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS)
+        PARTITION BY RANGE ("timestamp");
+    CREATE TABLE results_default PARTITION OF results DEFAULT;
+
+    CREATE INDEX idx_results_channel ON results(channel);
+    CREATE INDEX idx_results_timestamp ON results(timestamp);
+    CREATE INDEX idx_results_ingested_at ON results(ingested_at);
+
+    INSERT INTO results SELECT * FROM results_unpartitioned;
+    DROP TABLE results_unpartitioned;
+
+    CREATE TRIGGER results_inserted
+        AFTER INSERT ON results
+        REFERENCING NEW TABLE AS inserted
+        FOR EACH STATEMENT
+        EXECUTE FUNCTION notify_results_inserted();
+END;
+$$;
@@ -0,0 +1,2 @@
+-- Removed duplicates are not restored
+ALTER TABLE results DROP CONSTRAINT IF EXISTS results_message_key;
@@ -0,0 +1,72 @@
+-- The watermark can not move while the duplicates are removed from the rollups
+SELECT watermark FROM rollup_watermarks WHERE name = 'results' FOR UPDATE;
+
+-- Copies of a message stored by redeliveries, the first ingested one is kept
+CREATE TEMPORARY TABLE duplicate_results ON COMMIT DROP AS
+SELECT
+    *
+FROM (
+    SELECT
+        tableoid AS partition_oid,
+        ctid AS row_ctid,
+        channel,
+        "timestamp",
+        ingested_at,
+        sentiment_positive,
+        sentiment_neutral,
+        sentiment_negative,
+        ROW_NUMBER() OVER (PARTITION BY channel, message_id ORDER BY ingested_at, "timestamp") AS copy
+    FROM
+        results
+) AS copies
+WHERE
+    copy > 1;
+
+DELETE FROM results USING duplicate_results
+WHERE results.tableoid = duplicate_results.partition_oid AND results.ctid = duplicate_results.row_ctid;
+
+-- Copies already added to the rollups are subtracted from them
+CREATE TEMPORARY TABLE duplicate_rollups ON COMMIT DROP AS
+SELECT
+    'results_rollup_' || level.name AS rollup,
+    channel,
+    DATE_TRUNC(level.unit, "timestamp", 'UTC') AS bucket,
+    COUNT(*) AS messages,
+    SUM(sentiment_positive) AS sum_positive,
+    SUM(sentiment_neutral) AS sum_neutral,
+    SUM(sentiment_negative) AS sum_negative
+FROM
+    duplicate_results,
+    (VALUES ('1m', 'minute'), ('1h', 'hour'), ('1d', 'day')) AS level(name, unit)
+WHERE
+    ingested_at <= (SELECT watermark FROM rollup_watermarks WHERE name = 'results')
+GROUP BY
+    1, 2, 3;
+
+UPDATE results_rollup_1m AS r SET
+    messages = r.messages - d.messages,
+    sum_positive = r.sum_positive - d.sum_positive,
+    sum_neutral = r.sum_neutral - d.sum_neutral,
+    sum_negative = r.sum_negative - d.sum_negative
+FROM duplicate_rollups AS d
+WHERE d.rollup = 'results_rollup_1m' AND r.channel = d.channel AND r.bucket = d.bucket;
+
+UPDATE results_rollup_1h AS r SET
+    messages = r.messages - d.messages,
+    sum_positive = r.sum_positive - d.sum_positive,
+    sum_neutral = r.sum_neutral - d.sum_neutral,
+    sum_negative = r.sum_negative - d.sum_negative
+FROM duplicate_rollups AS d
+WHERE d.rollup = 'results_rollup_1h' AND r.channel = d.channel AND r.bucket = d.bucket;
+
+UPDATE results_rollup_1d AS r SET
+    messages = r.messages - d.messages,
+    sum_positive = r.sum_positive - d.sum_positive,
+    sum_neutral = r.sum_neutral - d.sum_neutral,
+    sum_negative = r.sum_negative - d.sum_negative
+FROM duplicate_rollups AS d
+WHERE d.rollup = 'results_rollup_1d' AND r.channel = d.channel AND r.bucket = d.bucket;
+
+-- Unique keys of partitioned tables must include the partition key,
+-- redeliveries keep the timestamp of the message so they still conflict
+ALTER TABLE results ADD CONSTRAINT results_message_key UNIQUE (channel, message_id, "timestamp");
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_results_search_vector;
+
+ALTER TABLE results DROP COLUMN IF EXISTS search_vector;
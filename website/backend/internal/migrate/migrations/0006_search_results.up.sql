@@ -0,0 +1,5 @@
+-- The simple configuration does not stem or drop stop words, chat is written in many languages
+ALTER TABLE results ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', message)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_results_search_vector ON results USING GIN (search_vector);
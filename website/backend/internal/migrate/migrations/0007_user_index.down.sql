@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_results_user_timestamp;
@@ -0,0 +1,2 @@
+-- User profiles read the messages of one user across channels
+CREATE INDEX IF NOT EXISTS idx_results_user_timestamp ON results("user", "timestamp");
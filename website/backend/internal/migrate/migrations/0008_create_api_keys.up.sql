@@ -0,0 +1,15 @@
+-- API keys of the programmatic endpoints, only the SHA-256 hash of each key is stored
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    -- Start of the key, to recognize it without storing it
+    prefix VARCHAR(20) NOT NULL,
+    key_hash BYTEA NOT NULL UNIQUE,
+    scopes VARCHAR(20)[] NOT NULL,
+    -- Token bucket refilled with rate_limit requests per second up to burst requests
+    rate_limit DOUBLE PRECISION NOT NULL CHECK (rate_limit > 0),
+    burst INTEGER NOT NULL CHECK (burst >= 1),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
@@ -0,0 +1,16 @@
+-- Alerts fired by the alert rules, kept as the history of Grafana annotations
+CREATE TABLE IF NOT EXISTS alerts (
+    id BIGSERIAL PRIMARY KEY,
+    rule VARCHAR(100) NOT NULL,
+    channel VARCHAR(100) NOT NULL,
+    metric VARCHAR(20) NOT NULL,
+    -- Minute that fired the alert
+    "timestamp" TIMESTAMPTZ NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    messages BIGINT NOT NULL,
+    baseline DOUBLE PRECISION,
+    z_score DOUBLE PRECISION,
+    UNIQUE (rule, channel, "timestamp")
+);
+
+CREATE INDEX IF NOT EXISTS alerts_timestamp_idx ON alerts ("timestamp");
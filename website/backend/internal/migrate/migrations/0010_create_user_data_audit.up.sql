@@ -0,0 +1,13 @@
+-- Deletions and pseudonymizations of the data of a user, kept as proof they were done
+CREATE TABLE IF NOT EXISTS user_data_audit (
+    id BIGSERIAL PRIMARY KEY,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('delete', 'pseudonymize')),
+    -- Pseudonym of the user, the login itself is not kept
+    subject VARCHAR(100) NOT NULL,
+    -- API key or operator that asked for it
+    actor VARCHAR(100) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    -- Results deleted or pseudonymized
+    rows BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS moderated_messages_inserted ON moderated_messages;
+DROP FUNCTION IF EXISTS redact_moderated_results();
+DROP TRIGGER IF EXISTS results_redact_moderated ON results;
+DROP FUNCTION IF EXISTS redact_moderated_result();
+-- Redacted messages can not be restored
+ALTER TABLE results DROP COLUMN IF EXISTS moderated;
+DROP TABLE IF EXISTS moderated_messages;
@@ -0,0 +1,46 @@
+-- Messages removed by Twitch moderators, the chat reader publishes a tombstone for each one
+CREATE TABLE IF NOT EXISTS moderated_messages (
+    channel VARCHAR(100) NOT NULL,
+    message_id VARCHAR(64) NOT NULL,
+    -- delete, timeout, ban or clear
+    action VARCHAR(20) NOT NULL,
+    moderated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (channel, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS moderated_messages_moderated_at_idx ON moderated_messages (moderated_at);
+
+-- Moderated results keep their sentiment, so the averages and rollups do not change, but not their text
+ALTER TABLE results ADD COLUMN IF NOT EXISTS moderated BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Results of messages moderated before they were analyzed are redacted as they are stored
+CREATE OR REPLACE FUNCTION redact_moderated_result() RETURNS trigger AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM moderated_messages WHERE channel = NEW.channel AND message_id = NEW.message_id) THEN
+        NEW.message := '';
+        NEW.moderated := TRUE;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER results_redact_moderated
+    BEFORE INSERT ON results
+    FOR EACH ROW
+    EXECUTE FUNCTION redact_moderated_result();
+
+-- Results already stored are redacted when their tombstone arrives
+CREATE OR REPLACE FUNCTION redact_moderated_results() RETURNS trigger AS $$
+BEGIN
+    UPDATE results SET message = '', moderated = TRUE
+    FROM inserted
+    WHERE results.channel = inserted.channel AND results.message_id = inserted.message_id AND NOT results.moderated;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER moderated_messages_inserted
+    AFTER INSERT ON moderated_messages
+    REFERENCING NEW TABLE AS inserted
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION redact_moderated_results();
@@ -0,0 +1,2 @@
+-- How likely the user of the message is a bot, from 0 to 1, scored by the chat reader
+ALTER TABLE results ADD COLUMN IF NOT EXISTS bot_score DOUBLE PRECISION NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+-- Firing and cooldown state of the alert rules, saved by the leader so the next one does not fire active alerts again
+CREATE TABLE IF NOT EXISTS alert_state (
+    rule VARCHAR(100) NOT NULL,
+    channel VARCHAR(100) NOT NULL,
+    firing BOOLEAN NOT NULL,
+    -- Minute the rule last fired for the channel
+    fired_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (rule, channel)
+);
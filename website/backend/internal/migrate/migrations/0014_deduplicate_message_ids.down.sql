@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS results_deduplicate ON results;
+DROP FUNCTION IF EXISTS deduplicate_result();
+-- Removed duplicates can not be restored
+DROP TABLE IF EXISTS result_message_ids;
@@ -0,0 +1,103 @@
+-- Redeliveries stored after 0005 with another timestamp are removed the same way, keeping the first ingested copy.
+-- The watermark can not move while the duplicates are removed from the rollups.
+SELECT watermark FROM rollup_watermarks WHERE name = 'results' FOR UPDATE;
+
+-- Copies of a message stored by redeliveries, the first ingested one is kept
+CREATE TEMPORARY TABLE duplicate_results ON COMMIT DROP AS
+SELECT
+    *
+FROM (
+    SELECT
+        tableoid AS partition_oid,
+        ctid AS row_ctid,
+        channel,
+        "timestamp",
+        ingested_at,
+        sentiment_positive,
+        sentiment_neutral,
+        sentiment_negative,
+        ROW_NUMBER() OVER (PARTITION BY channel, message_id ORDER BY ingested_at, "timestamp") AS copy
+    FROM
+        results
+) AS copies
+WHERE
+    copy > 1;
+
+DELETE FROM results USING duplicate_results
+WHERE results.tableoid = duplicate_results.partition_oid AND results.ctid = duplicate_results.row_ctid;
+
+-- Copies already added to the rollups are subtracted from them
+CREATE TEMPORARY TABLE duplicate_rollups ON COMMIT DROP AS
+SELECT
+    'results_rollup_' || level.name AS rollup,
+    channel,
+    DATE_TRUNC(level.unit, "timestamp", 'UTC') AS bucket,
+    COUNT(*) AS messages,
+    SUM(sentiment_positive) AS sum_positive,
+    SUM(sentiment_neutral) AS sum_neutral,
+    SUM(sentiment_negative) AS sum_negative
+FROM
+    duplicate_results,
+    (VALUES ('1m', 'minute'), ('1h', 'hour'), ('1d', 'day')) AS level(name, unit)
+WHERE
+    ingested_at <= (SELECT watermark FROM rollup_watermarks WHERE name = 'results')
+GROUP BY
+    1, 2, 3;
+
+UPDATE results_rollup_1m AS r SET
+    messages = r.messages - d.messages,
+    sum_positive = r.sum_positive - d.sum_positive,
+    sum_neutral = r.sum_neutral - d.sum_neutral,
+    sum_negative = r.sum_negative - d.sum_negative
+FROM duplicate_rollups AS d
+WHERE d.rollup = 'results_rollup_1m' AND r.channel = d.channel AND r.bucket = d.bucket;
+
+UPDATE results_rollup_1h AS r SET
+    messages = r.messages - d.messages,
+    sum_positive = r.sum_positive - d.sum_positive,
+    sum_neutral = r.sum_neutral - d.sum_neutral,
+    sum_negative = r.sum_negative - d.sum_negative
+FROM duplicate_rollups AS d
+WHERE d.rollup = 'results_rollup_1h' AND r.channel = d.channel AND r.bucket = d.bucket;
+
+UPDATE results_rollup_1d AS r SET
+    messages = r.messages - d.messages,
+    sum_positive = r.sum_positive - d.sum_positive,
+    sum_neutral = r.sum_neutral - d.sum_neutral,
+    sum_negative = r.sum_negative - d.sum_negative
+FROM duplicate_rollups AS d
+WHERE d.rollup = 'results_rollup_1d' AND r.channel = d.channel AND r.bucket = d.bucket;
+
+-- Keys of the stored messages. Unique keys of the partitioned results must include the timestamp,
+-- this table is not partitioned, so redeliveries with another timestamp are found too.
+CREATE TABLE IF NOT EXISTS result_message_ids (
+    channel VARCHAR(100) NOT NULL,
+    message_id VARCHAR(64) NOT NULL,
+    -- Timestamp of the stored copy, the key is pruned with the raw results
+    "timestamp" TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (channel, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_result_message_ids_timestamp ON result_message_ids ("timestamp");
+
+INSERT INTO result_message_ids (channel, message_id, "timestamp")
+SELECT channel, message_id, MIN("timestamp") FROM results GROUP BY channel, message_id
+ON CONFLICT DO NOTHING;
+
+-- Skips the results of messages already stored. Before triggers run by name, so this one runs before the redaction.
+CREATE OR REPLACE FUNCTION deduplicate_result() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO result_message_ids (channel, message_id, "timestamp")
+    VALUES (NEW.channel, NEW.message_id, NEW."timestamp")
+    ON CONFLICT DO NOTHING;
+    IF NOT FOUND THEN
+        RETURN NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER results_deduplicate
+    BEFORE INSERT ON results
+    FOR EACH ROW
+    EXECUTE FUNCTION deduplicate_result();
@@ -0,0 +1,2 @@
+-- Names of the Twitch emotes of the message, removed from its text by the chat reader
+ALTER TABLE results ADD COLUMN IF NOT EXISTS emotes TEXT[] NOT NULL DEFAULT '{}';
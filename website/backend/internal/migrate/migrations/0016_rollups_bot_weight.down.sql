@@ -0,0 +1,4 @@
+-- The sums added since stay weighted by the bot scores
+ALTER TABLE results_rollup_1m DROP COLUMN IF EXISTS sum_weight;
+ALTER TABLE results_rollup_1h DROP COLUMN IF EXISTS sum_weight;
+ALTER TABLE results_rollup_1d DROP COLUMN IF EXISTS sum_weight;
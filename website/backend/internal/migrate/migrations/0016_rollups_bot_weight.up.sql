@@ -0,0 +1,13 @@
+-- Results weigh one minus their bot score in the averages. The sentiment sums of the rollups are weighted
+-- from now on and sum_weight holds the total weight, the buckets summed before count every message fully.
+ALTER TABLE results_rollup_1m ADD COLUMN IF NOT EXISTS sum_weight double precision;
+UPDATE results_rollup_1m SET sum_weight = messages WHERE sum_weight IS NULL;
+ALTER TABLE results_rollup_1m ALTER COLUMN sum_weight SET NOT NULL;
+
+ALTER TABLE results_rollup_1h ADD COLUMN IF NOT EXISTS sum_weight double precision;
+UPDATE results_rollup_1h SET sum_weight = messages WHERE sum_weight IS NULL;
+ALTER TABLE results_rollup_1h ALTER COLUMN sum_weight SET NOT NULL;
+
+ALTER TABLE results_rollup_1d ADD COLUMN IF NOT EXISTS sum_weight double precision;
+UPDATE results_rollup_1d SET sum_weight = messages WHERE sum_weight IS NULL;
+ALTER TABLE results_rollup_1d ALTER COLUMN sum_weight SET NOT NULL;
@@ -0,0 +1,194 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoSalt = errors.New("USER_HASH_SALT is not set, users can not be pseudonymized")
+	// Changes are only made on behalf of a named API key or CLI operator
+	ErrNoActor = errors.New("the actor of the change is not named")
+)
+
+type Action string
+
+const (
+	ActionDelete       Action = "delete"
+	ActionPseudonymize Action = "pseudonymize"
+)
+
+// Deletion or pseudonymization of the data of a user
+type AuditEntry struct {
+	ID     int64  `json:"id" db:"id"`
+	Action Action `json:"action" db:"action"`
+	// Pseudonym of the user keyed with the salt, empty without one. The login is not kept.
+	Subject   string    `json:"subject" db:"subject"`
+	Actor     string    `json:"actor" db:"actor"`
+	Reason    string    `json:"reason" db:"reason"`
+	Rows      int64     `json:"rows" db:"rows"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Deletes and pseudonymizes the results of users, recording each change in the user_data_audit table
+type Eraser struct {
+	conn *pgxpool.Pool
+	salt string
+}
+
+func NewEraser(conn *pgxpool.Pool, logger *zap.SugaredLogger) *Eraser {
+	salt := os.Getenv("USER_HASH_SALT")
+	if salt == "" {
+		logger.Info("USER_HASH_SALT is not set, users can be deleted but not pseudonymized")
+	}
+	return &Eraser{conn: conn, salt: salt}
+}
+
+// Whether users can be pseudonymized
+func (e *Eraser) Salted() bool {
+	return e.salt != ""
+}
+
+// Deletes every result of the user, including the ones stored under its pseudonym,
+// and subtracts the ones already added to the rollups
+func (e *Eraser) Delete(ctx context.Context, user, actor, reason string) (AuditEntry, error) {
+	users := []string{strings.ToLower(user)}
+	if e.Salted() {
+		users = append(users, Pseudonymize(e.salt, user))
+	}
+	return e.apply(ctx, ActionDelete, user, actor, reason, func(tx pgx.Tx) (int64, error) {
+		// The watermark can not move while the results are removed from the rollups
+		_, err := tx.Exec(ctx, `SELECT watermark FROM rollup_watermarks WHERE name = 'results' FOR UPDATE;`)
+		if err != nil {
+			return 0, err
+		}
+		var deleted int64
+		err = tx.QueryRow(ctx, deleteUserSQL, users).Scan(&deleted)
+		return deleted, err
+	})
+}
+
+// Replaces the user of every result of the user with its pseudonym, so the statistics stay but not the login
+func (e *Eraser) Pseudonymize(ctx context.Context, user, actor, reason string) (AuditEntry, error) {
+	if !e.Salted() {
+		return AuditEntry{}, ErrNoSalt
+	}
+	return e.apply(ctx, ActionPseudonymize, user, actor, reason, func(tx pgx.Tx) (int64, error) {
+		tag, err := tx.Exec(ctx, `UPDATE results SET "user" = $2 WHERE "user" = $1;`, strings.ToLower(user), Pseudonymize(e.salt, user))
+		return tag.RowsAffected(), err
+	})
+}
+
+// Runs the change and records it in the same transaction
+func (e *Eraser) apply(ctx context.Context, action Action, user, actor, reason string, change func(tx pgx.Tx) (int64, error)) (AuditEntry, error) {
+	if strings.TrimSpace(actor) == "" {
+		return AuditEntry{}, ErrNoActor
+	}
+	tx, err := e.conn.Begin(ctx)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	changed, err := change(tx)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	// An unsalted hash of a login is easy to reverse, so without a salt the entry does not name the user
+	subject := ""
+	if e.Salted() {
+		subject = Pseudonymize(e.salt, user)
+	}
+	rows, err := tx.Query(ctx, `
+INSERT INTO user_data_audit (action, subject, actor, reason, rows)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, action, subject, actor, reason, rows, created_at;
+`, action, subject, actor, reason, changed)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	entry, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[AuditEntry])
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	return entry, tx.Commit(ctx)
+}
+
+// Audit entries, newest first
+func (e *Eraser) Audit(ctx context.Context) ([]AuditEntry, error) {
+	rows, err := e.conn.Query(ctx, `
+SELECT id, action, subject, actor, reason, rows, created_at
+FROM user_data_audit
+ORDER BY id DESC;
+`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[AuditEntry])
+}
+
+// Deletes the results of the users and returns how many. The deleted results ingested
+// before the watermark are in the rollups, so they are subtracted from every level.
+const deleteUserSQL = `
+WITH deleted AS (
+    DELETE FROM results
+    WHERE "user" = ANY($1)
+    RETURNING channel, "timestamp", ingested_at, bot_score, sentiment_positive, sentiment_neutral, sentiment_negative
+),
+deleted_rollups AS (
+    SELECT
+        'results_rollup_' || level.name AS rollup,
+        channel,
+        DATE_TRUNC(level.unit, "timestamp", 'UTC') AS bucket,
+        COUNT(*) AS messages,
+        SUM(1 - bot_score) AS sum_weight,
+        SUM(sentiment_positive * (1 - bot_score)) AS sum_positive,
+        SUM(sentiment_neutral * (1 - bot_score)) AS sum_neutral,
+        SUM(sentiment_negative * (1 - bot_score)) AS sum_negative
+    FROM
+        deleted,
+        (VALUES ('1m', 'minute'), ('1h', 'hour'), ('1d', 'day')) AS level(name, unit)
+    WHERE
+        ingested_at <= (SELECT watermark FROM rollup_watermarks WHERE name = 'results')
+    GROUP BY
+        1, 2, 3
+),
+minutes AS (
+    UPDATE results_rollup_1m AS r SET
+        messages = r.messages - d.messages,
+        sum_weight = r.sum_weight - d.sum_weight,
+        sum_positive = r.sum_positive - d.sum_positive,
+        sum_neutral = r.sum_neutral - d.sum_neutral,
+        sum_negative = r.sum_negative - d.sum_negative
+    FROM deleted_rollups AS d
+    WHERE d.rollup = 'results_rollup_1m' AND r.channel = d.channel AND r.bucket = d.bucket
+),
+hours AS (
+    UPDATE results_rollup_1h AS r SET
+        messages = r.messages - d.messages,
+        sum_weight = r.sum_weight - d.sum_weight,
+        sum_positive = r.sum_positive - d.sum_positive,
+        sum_neutral = r.sum_neutral - d.sum_neutral,
+        sum_negative = r.sum_negative - d.sum_negative
+    FROM deleted_rollups AS d
+    WHERE d.rollup = 'results_rollup_1h' AND r.channel = d.channel AND r.bucket = d.bucket
+),
+days AS (
+    UPDATE results_rollup_1d AS r SET
+        messages = r.messages - d.messages,
+        sum_weight = r.sum_weight - d.sum_weight,
+        sum_positive = r.sum_positive - d.sum_positive,
+        sum_neutral = r.sum_neutral - d.sum_neutral,
+        sum_negative = r.sum_negative - d.sum_negative
+    FROM deleted_rollups AS d
+    WHERE d.rollup = 'results_rollup_1d' AND r.channel = d.channel AND r.bucket = d.bucket
+)
+SELECT COUNT(*) FROM deleted;
+`
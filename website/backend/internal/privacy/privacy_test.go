@@ -0,0 +1,98 @@
+package privacy
+
+import (
+	"context"
+	"testing"
+	"website/internal/database"
+	"website/internal/rollup"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"go.uber.org/zap"
+)
+
+func TestPseudonymize(t *testing.T) {
+	pseudonym := Pseudonymize("salt", "Viewer_1")
+	require.Equal(t, Pseudonymize("salt", "viewer_1"), pseudonym)
+	require.NotEqual(t, Pseudonymize("other", "viewer_1"), pseudonym)
+	// Same vector as the chat reader, so both find the same rows
+	require.Equal(t, "u_8e8ffd03fc42068a226227df758871aa", pseudonym)
+	require.Regexp(t, `^[a-zA-Z0-9_]{1,100}$`, pseudonym)
+}
+
+func TestEraser(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	require.NoError(t, testutils.PopulateDatabase(dsn, 3, 5))
+	t.Setenv("DATABASE_DSN", dsn)
+	t.Setenv("ROLLUP_LAG", "0s")
+	conn := database.NewDatabaseConnection(zap.NewNop().Sugar())
+	countUser := func(user string) int64 {
+		var count int64
+		require.NoError(t, conn.QueryRow(ctx, `SELECT COUNT(*) FROM results WHERE "user" = $1;`, user).Scan(&count))
+		return count
+	}
+	rolledUp := func(channel string) (minutes, hours, days int64) {
+		require.NoError(t, conn.QueryRow(ctx, `
+SELECT
+    (SELECT COALESCE(SUM(messages), 0) FROM results_rollup_1m WHERE channel = $1),
+    (SELECT COALESCE(SUM(messages), 0) FROM results_rollup_1h WHERE channel = $1),
+    (SELECT COALESCE(SUM(messages), 0) FROM results_rollup_1d WHERE channel = $1);
+`, channel).Scan(&minutes, &hours, &days))
+		return minutes, hours, days
+	}
+	_, err = rollup.NewJob(conn, zap.NewNop().Sugar()).Run(ctx)
+	require.NoError(t, err)
+
+	unsalted := NewEraser(conn, zap.NewNop().Sugar())
+	_, err = unsalted.Pseudonymize(ctx, "user0", "cli", "")
+	require.ErrorIs(t, err, ErrNoSalt)
+	// Changes are never recorded without an actor
+	_, err = unsalted.Delete(ctx, "user2", " ", "")
+	require.ErrorIs(t, err, ErrNoActor)
+	// Without a salt the entry does not name the user
+	entry, err := unsalted.Delete(ctx, "user2", "cli", "request 0")
+	require.NoError(t, err)
+	require.EqualValues(t, 5, entry.Rows)
+	require.Empty(t, entry.Subject)
+
+	t.Setenv("USER_HASH_SALT", "salt")
+	eraser := NewEraser(conn, zap.NewNop().Sugar())
+
+	entry, err = eraser.Pseudonymize(ctx, "User0", "admin-key", "request 1")
+	require.NoError(t, err)
+	require.Equal(t, ActionPseudonymize, entry.Action)
+	require.EqualValues(t, 5, entry.Rows)
+	require.Equal(t, Pseudonymize("salt", "user0"), entry.Subject)
+	require.Zero(t, countUser("user0"))
+	require.EqualValues(t, 5, countUser(Pseudonymize("salt", "user0")))
+
+	// Deleting finds the rows of the login and of its pseudonym
+	entry, err = eraser.Delete(ctx, "user0", "cli", "request 2")
+	require.NoError(t, err)
+	require.EqualValues(t, 5, entry.Rows)
+	require.Zero(t, countUser(Pseudonymize("salt", "user0")))
+	require.EqualValues(t, 5, countUser("user1"))
+
+	// The deleted results are subtracted from every rollup level
+	minutes, hours, days := rolledUp("channel0")
+	require.Zero(t, minutes+hours+days)
+	minutes, hours, days = rolledUp("channel1")
+	require.EqualValues(t, []int64{5, 5, 5}, []int64{minutes, hours, days})
+
+	entries, err := eraser.Audit(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, ActionDelete, entries[0].Action)
+	require.Equal(t, "cli", entries[0].Actor)
+	require.Equal(t, "request 2", entries[0].Reason)
+	for _, entry := range entries {
+		require.NotContains(t, entry.Subject, "user0")
+	}
+}
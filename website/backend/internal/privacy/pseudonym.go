@@ -0,0 +1,15 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Pseudonym of a Twitch login, the same the chat reader stores when it pseudonymizes users at ingest
+func Pseudonymize(salt, user string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(strings.ToLower(user)))
+	return "u_" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
@@ -0,0 +1,160 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	// Row of rollup_watermarks tracking the results included in the rollups
+	watermarkName = "results"
+
+	defaultInterval = 10 * time.Second
+	// Inserts still running after this long could be left out of the rollups
+	defaultLag = time.Minute
+)
+
+// Rollup tables and the unit of their buckets, finest first
+var levels = []struct {
+	table string
+	unit  string
+}{
+	{table: "results_rollup_1m", unit: "minute"},
+	{table: "results_rollup_1h", unit: "hour"},
+	{table: "results_rollup_1d", unit: "day"},
+}
+
+var (
+	bucketsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rollup_buckets_updated_total",
+	})
+
+	watermarkGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rollup_watermark_timestamp_seconds",
+	})
+)
+
+// Incrementally adds the ingested results to the rollup tables.
+// Results are taken by ingestion time, so rows that arrive late for their timestamp are still counted.
+type Job struct {
+	pool     *pgxpool.Pool
+	logger   *zap.SugaredLogger
+	interval time.Duration
+	lag      time.Duration
+}
+
+func NewJob(pool *pgxpool.Pool, logger *zap.SugaredLogger) *Job {
+	return &Job{
+		pool:     pool,
+		logger:   logger,
+		interval: durationFromEnv("ROLLUP_INTERVAL", defaultInterval, logger),
+		lag:      durationFromEnv("ROLLUP_LAG", defaultLag, logger),
+	}
+}
+
+func durationFromEnv(name string, fallback time.Duration, logger *zap.SugaredLogger) time.Duration {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		logger.Fatalf("Invalid %s %q", name, value)
+	}
+	return duration
+}
+
+// Runs the job every interval until the context is done
+func (j *Job) Start(ctx context.Context) {
+	j.logger.Infof("Starting rollup job every %s with a lag of %s", j.interval, j.lag)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Run(ctx); err != nil && ctx.Err() == nil {
+			j.logger.Errorf("Rollup failed: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			j.logger.Info("Stopping rollup job")
+			return
+		}
+	}
+}
+
+// Adds the results ingested after the watermark to every rollup and moves the watermark, in a single transaction.
+// Concurrent runs, like those of other replicas, wait for each other on the watermark row.
+// Returns the new watermark.
+func (j *Job) Run(ctx context.Context) (time.Time, error) {
+	var to time.Time
+	err := pgx.BeginFunc(ctx, j.pool, func(tx pgx.Tx) error {
+		var from pgtype.Timestamptz
+		err := tx.QueryRow(ctx, `
+SELECT
+    watermark, now() - make_interval(secs => $2)
+FROM
+    rollup_watermarks
+WHERE
+    name = $1
+FOR UPDATE;
+`, watermarkName, j.lag.Seconds()).Scan(&from, &to)
+		if err != nil {
+			return fmt.Errorf("failed to read watermark: %w", err)
+		}
+		if from.InfinityModifier == pgtype.Finite && !from.Time.Before(to) {
+			to = from.Time
+			return nil
+		}
+
+		for _, level := range levels {
+			tag, err := tx.Exec(ctx, fmt.Sprintf(`
+INSERT INTO %[1]s (channel, bucket, messages, sum_weight, sum_positive, sum_neutral, sum_negative)
+SELECT
+    channel,
+    DATE_TRUNC('%[2]s', "timestamp", 'UTC'),
+    COUNT(*),
+    SUM(1 - bot_score),
+    SUM(sentiment_positive * (1 - bot_score)),
+    SUM(sentiment_neutral * (1 - bot_score)),
+    SUM(sentiment_negative * (1 - bot_score))
+FROM
+    results
+WHERE
+    ingested_at > $1 AND ingested_at <= $2
+GROUP BY
+    1, 2
+ON CONFLICT (channel, bucket) DO UPDATE SET
+    messages = %[1]s.messages + EXCLUDED.messages,
+    sum_weight = %[1]s.sum_weight + EXCLUDED.sum_weight,
+    sum_positive = %[1]s.sum_positive + EXCLUDED.sum_positive,
+    sum_neutral = %[1]s.sum_neutral + EXCLUDED.sum_neutral,
+    sum_negative = %[1]s.sum_negative + EXCLUDED.sum_negative;
+`, level.table, level.unit), from, to)
+			if err != nil {
+				return fmt.Errorf("failed to update %s: %w", level.table, err)
+			}
+			bucketsCounter.Add(float64(tag.RowsAffected()))
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE rollup_watermarks SET watermark = $2 WHERE name = $1;`, watermarkName, to)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	watermarkGauge.Set(float64(to.Unix()))
+	return to, nil
+}
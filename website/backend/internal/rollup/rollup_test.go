@@ -0,0 +1,80 @@
+package rollup
+
+import (
+	"context"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = zap.NewNop().Sugar()
+}
+
+func TestJob(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	databaseChannels := 3
+	messagesPerChannel := 2
+	require.NoError(t, testutils.PopulateDatabase(dsn, databaseChannels, messagesPerChannel))
+
+	t.Setenv("DATABASE_DSN", dsn)
+	t.Setenv("ROLLUP_LAG", "0s")
+	conn := database.NewDatabaseConnection(logger)
+	job := NewJob(conn, logger)
+
+	watermark, err := job.Run(ctx)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), watermark, time.Minute)
+
+	count := func(table string) (buckets int64, messages int64) {
+		err := conn.QueryRow(ctx, "SELECT COUNT(*), COALESCE(SUM(messages), 0) FROM "+table+" WHERE channel = 'channel1'").
+			Scan(&buckets, &messages)
+		require.NoError(t, err)
+		return buckets, messages
+	}
+	buckets, messages := count("results_rollup_1m")
+	require.EqualValues(t, messagesPerChannel, buckets)
+	require.EqualValues(t, messagesPerChannel, messages)
+	buckets, messages = count("results_rollup_1h")
+	require.EqualValues(t, 1, buckets)
+	require.EqualValues(t, messagesPerChannel, messages)
+	buckets, messages = count("results_rollup_1d")
+	require.EqualValues(t, 1, buckets)
+	require.EqualValues(t, messagesPerChannel, messages)
+
+	// Running again does not count the same results twice
+	_, err = job.Run(ctx)
+	require.NoError(t, err)
+	_, messages = count("results_rollup_1d")
+	require.EqualValues(t, messagesPerChannel, messages)
+
+	// A result arriving late for its timestamp is added to the existing buckets
+	_, err = conn.Exec(ctx, `INSERT INTO results
+		(channel, "user", "message_id", "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative)
+		VALUES ('channel1', 'user1', 'msg-late', '2024-12-01T14:00:30Z', 'late message', 0.2, 0.4, 0.4);`)
+	require.NoError(t, err)
+	_, err = job.Run(ctx)
+	require.NoError(t, err)
+
+	buckets, messages = count("results_rollup_1m")
+	require.EqualValues(t, messagesPerChannel, buckets)
+	require.EqualValues(t, messagesPerChannel+1, messages)
+	var positive float64
+	err = conn.QueryRow(ctx, `SELECT sum_positive FROM results_rollup_1m WHERE channel = 'channel1' AND bucket = '2024-12-01T14:00:00Z'`).
+		Scan(&positive)
+	require.NoError(t, err)
+	require.InDelta(t, 1.0, positive, 1e-9)
+}
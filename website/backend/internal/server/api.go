@@ -0,0 +1,588 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"website/internal/auth"
+	"website/internal/export"
+	"website/internal/privacy"
+	"website/internal/service"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRange       = time.Hour
+	defaultSearchRange = 7 * 24 * time.Hour
+	defaultUserRange   = 7 * 24 * time.Hour
+	// Minimum messages of users in the positive and negative leaderboards, so single messages do not lead them
+	defaultMinMessages  = 5
+	maxSearchLength     = 200
+	defaultResultLimit  = 100
+	maxResultLimit      = 1000
+	maxBucketsPerQuery  = 10_000
+	maxComparedChannels = 10
+	defaultMaxLag       = 10
+	maxLag              = 60
+	maxReasonLength     = 500
+)
+
+var (
+	//go:embed openapi.yaml
+	openapiSpec []byte
+
+	channelPattern = regexp.MustCompile(`^[a-zA-Z0-9_]{1,100}$`)
+	// Users are Twitch logins, like channels
+	userPattern = channelPattern
+)
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type api struct {
+	resultsService *service.ResultsService
+	keys           *auth.Store
+	eraser         *privacy.Eraser
+	logger         *zap.SugaredLogger
+}
+
+// Registers the versioned REST API routes, which need an API key with their scope.
+// When authenticator is nil the read routes are public and the admin routes are not registered.
+func registerApiRoutes(mux *http.ServeMux, resultsService *service.ResultsService, keys *auth.Store, eraser *privacy.Eraser, authenticator *auth.Authenticator, logger *zap.SugaredLogger) {
+	a := &api{resultsService: resultsService, keys: keys, eraser: eraser, logger: logger}
+	handle := func(pattern string, scope auth.Scope, handler http.HandlerFunc) {
+		switch {
+		case authenticator != nil:
+			mux.Handle(pattern, authenticator.Require(scope, handler))
+		case scope == auth.ScopeRead:
+			mux.Handle(pattern, handler)
+		}
+	}
+
+	mux.HandleFunc("GET /api/v1/openapi.yaml", a.openapi)
+	handle("GET /api/v1/channels", auth.ScopeRead, a.channels)
+	handle("GET /api/v1/channels/{channel}/averages", auth.ScopeRead, a.channelAverages)
+	handle("GET /api/v1/channels/{channel}/results", auth.ScopeRead, a.channelResults)
+	handle("GET /api/v1/channels/{channel}/summary", auth.ScopeRead, a.channelSummary)
+	handle("GET /api/v1/channels/{channel}/leaderboard", auth.ScopeRead, a.channelLeaderboard)
+	handle("GET /api/v1/channels/{channel}/export", auth.ScopeRead, a.channelExport)
+	handle("GET /api/v1/channels/{channel}/trends", auth.ScopeRead, a.channelTrends)
+	handle("GET /api/v1/users/{user}", auth.ScopeRead, a.userProfile)
+	handle("GET /api/v1/search", auth.ScopeRead, a.search)
+	handle("GET /api/v1/compare", auth.ScopeRead, a.compare)
+	handle("GET /api/v1/keys", auth.ScopeAdmin, a.apiKeys)
+	handle("DELETE /api/v1/users/{user}", auth.ScopeAdmin, a.deleteUser)
+	handle("POST /api/v1/users/{user}/pseudonymize", auth.ScopeAdmin, a.pseudonymizeUser)
+	handle("GET /api/v1/audit", auth.ScopeAdmin, a.audit)
+}
+
+func (a *api) openapi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openapiSpec)
+}
+
+func (a *api) channels(w http.ResponseWriter, r *http.Request) {
+	channels, err := a.resultsService.GetChannels(r.Context())
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, channels)
+}
+
+func (a *api) channelAverages(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	query := r.URL.Query()
+	from, to, err := parseRange(query, time.Now())
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	bucket := service.BucketMinute
+	if value := query.Get("bucket"); value != "" {
+		if bucket, err = service.ParseBucket(value); err != nil {
+			a.badRequest(w, err)
+			return
+		}
+	}
+	if buckets := to.Sub(from) / bucket.Duration(); buckets > maxBucketsPerQuery {
+		a.badRequest(w, fmt.Errorf("range has %d buckets of one %s, the maximum is %d", buckets, bucket, maxBucketsPerQuery))
+		return
+	}
+
+	averages, err := a.resultsService.GetChannelAverageResults(r.Context(), channel, from, to, bucket)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, averages)
+}
+
+func (a *api) channelResults(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	query := r.URL.Query()
+	from, to, err := parseRange(query, time.Now())
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	limit, err := parseLimit(query)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	var cursor *service.Cursor
+	if value := query.Get("cursor"); value != "" {
+		decoded, err := service.DecodeCursor(value)
+		if err != nil {
+			a.badRequest(w, err)
+			return
+		}
+		cursor = &decoded
+	}
+
+	page, err := a.resultsService.GetChannelResults(r.Context(), channel, from, to, limit, cursor)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, page)
+}
+
+func (a *api) channelSummary(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	from, to, err := parseRange(r.URL.Query(), time.Now())
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+
+	summary, err := a.resultsService.GetChannelSummary(r.Context(), channel, from, to)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, summary)
+}
+
+func (a *api) channelLeaderboard(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	query := r.URL.Query()
+	from, to, err := parseRange(query, cacheableNow())
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	order := service.ByMessages
+	if value := query.Get("order"); value != "" {
+		if order, err = service.ParseLeaderboardOrder(value); err != nil {
+			a.badRequest(w, err)
+			return
+		}
+	}
+	minMessages := int64(1)
+	if order != service.ByMessages {
+		minMessages = defaultMinMessages
+	}
+	if value := query.Get("min_messages"); value != "" {
+		if minMessages, err = strconv.ParseInt(value, 10, 64); err != nil || minMessages < 1 {
+			a.badRequest(w, errors.New("invalid \"min_messages\", expected a positive integer"))
+			return
+		}
+	}
+	limit, err := parseLimit(query)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+
+	users, err := a.resultsService.GetLeaderboard(r.Context(), channel, from, to, order, minMessages, limit)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, users)
+}
+
+func (a *api) channelExport(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	query := r.URL.Query()
+	request := export.Request{Channel: channel, Dataset: export.DatasetResults, Format: export.FormatCSV, Bucket: service.BucketMinute}
+	if request.From, request.To, err = parseRange(query, time.Now()); err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	if value := query.Get("dataset"); value != "" {
+		if request.Dataset, err = export.ParseDataset(value); err != nil {
+			a.badRequest(w, err)
+			return
+		}
+	}
+	if value := query.Get("format"); value != "" {
+		if request.Format, err = export.ParseFormat(value); err != nil {
+			a.badRequest(w, err)
+			return
+		}
+	}
+	if value := query.Get("bucket"); value != "" {
+		if request.Bucket, err = service.ParseBucket(value); err != nil {
+			a.badRequest(w, err)
+			return
+		}
+	}
+	if value := query.Get("gzip"); value != "" {
+		if request.Gzip, err = strconv.ParseBool(value); err != nil {
+			a.badRequest(w, errors.New("invalid \"gzip\", expected true or false"))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", request.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": request.Filename()}))
+	if err := export.Export(r.Context(), a.resultsService, w, request); err != nil {
+		// The status was already sent, aborting the response tells the client the file is incomplete
+		a.logger.Errorf("Failed to export %s: %v", request.Filename(), err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (a *api) channelTrends(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	query := r.URL.Query()
+	window := defaultTrendsWindow
+	if value := query.Get("window"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 1 || time.Duration(minutes)*time.Minute > maxWindow {
+			a.badRequest(w, fmt.Errorf("invalid window %q, expected 1 to %d minutes", value, int(maxWindow.Minutes())))
+			return
+		}
+		window = time.Duration(minutes) * time.Minute
+	}
+	limit := defaultTrendsLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxTrendsLimit {
+			a.badRequest(w, fmt.Errorf("invalid limit %q, expected 1 to %d", value, maxTrendsLimit))
+			return
+		}
+	}
+
+	// Complete minutes only, like the broadcast trends
+	to := time.Now().Truncate(time.Minute)
+	trends, err := a.resultsService.GetChannelsTrends(r.Context(), []string{channel}, to.Add(-window), to, limit)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, trends[channel])
+}
+
+func (a *api) userProfile(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if !userPattern.MatchString(user) {
+		a.badRequest(w, fmt.Errorf("invalid user %q", user))
+		return
+	}
+	query := r.URL.Query()
+	from, to, err := parseRangeWithDefault(query, cacheableNow(), defaultUserRange)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	bucket := service.BucketHour
+	if value := query.Get("bucket"); value != "" {
+		if bucket, err = service.ParseBucket(value); err != nil {
+			a.badRequest(w, err)
+			return
+		}
+	}
+	if buckets := to.Sub(from) / bucket.Duration(); buckets > maxBucketsPerQuery {
+		a.badRequest(w, fmt.Errorf("range has %d buckets of one %s, the maximum is %d", buckets, bucket, maxBucketsPerQuery))
+		return
+	}
+
+	profile, err := a.resultsService.GetUserProfile(r.Context(), user, from, to, bucket)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, profile)
+}
+
+func (a *api) search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	search := service.SearchQuery{Text: query.Get("q"), Channel: query.Get("channel"), User: query.Get("user")}
+	if search.Text == "" || len(search.Text) > maxSearchLength {
+		a.badRequest(w, fmt.Errorf("\"q\" is required and can have up to %d bytes", maxSearchLength))
+		return
+	}
+	if search.Channel != "" && !channelPattern.MatchString(search.Channel) {
+		a.badRequest(w, fmt.Errorf("invalid channel %q", search.Channel))
+		return
+	}
+	var err error
+	if search.From, search.To, err = parseRangeWithDefault(query, time.Now(), defaultSearchRange); err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	for _, score := range []struct {
+		name  string
+		value *float64
+	}{
+		{name: "min_positive", value: &search.MinPositive},
+		{name: "min_neutral", value: &search.MinNeutral},
+		{name: "min_negative", value: &search.MinNegative},
+	} {
+		if *score.value, err = parseScore(query, score.name); err != nil {
+			a.badRequest(w, err)
+			return
+		}
+	}
+	if search.Limit, err = parseLimit(query); err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	if value := query.Get("cursor"); value != "" {
+		decoded, err := service.DecodeCursor(value)
+		if err != nil {
+			a.badRequest(w, err)
+			return
+		}
+		search.Cursor = &decoded
+	}
+
+	page, err := a.resultsService.Search(r.Context(), search)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, page)
+}
+
+func (a *api) compare(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	channels := []string{}
+	for _, channel := range strings.Split(query.Get("channels"), ",") {
+		if channel = strings.TrimSpace(channel); channel == "" || slices.Contains(channels, channel) {
+			continue
+		}
+		if !channelPattern.MatchString(channel) {
+			a.badRequest(w, fmt.Errorf("invalid channel %q", channel))
+			return
+		}
+		channels = append(channels, channel)
+	}
+	if len(channels) < 2 || len(channels) > maxComparedChannels {
+		a.badRequest(w, fmt.Errorf("\"channels\" must have from 2 to %d comma separated channels", maxComparedChannels))
+		return
+	}
+	from, to, err := parseRange(query, cacheableNow())
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	if minutes := to.Sub(from) / time.Minute; minutes > maxBucketsPerQuery {
+		a.badRequest(w, fmt.Errorf("range has %d minutes, the maximum is %d", minutes, maxBucketsPerQuery))
+		return
+	}
+	metric := service.CompareNegative
+	if value := query.Get("metric"); value != "" {
+		if metric, err = service.ParseComparisonMetric(value); err != nil {
+			a.badRequest(w, err)
+			return
+		}
+	}
+	lag := defaultMaxLag
+	if value := query.Get("max_lag"); value != "" {
+		if lag, err = strconv.Atoi(value); err != nil || lag < 0 || lag > maxLag {
+			a.badRequest(w, fmt.Errorf("invalid max_lag %q, expected 0 to %d minutes", value, maxLag))
+			return
+		}
+	}
+
+	comparison, err := a.resultsService.GetChannelsComparison(r.Context(), channels, from, to, metric, lag)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, comparison)
+}
+
+func (a *api) apiKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.keys.List(r.Context())
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, keys)
+}
+
+func (a *api) deleteUser(w http.ResponseWriter, r *http.Request) {
+	a.eraseUser(w, r, a.eraser.Delete)
+}
+
+func (a *api) pseudonymizeUser(w http.ResponseWriter, r *http.Request) {
+	a.eraseUser(w, r, a.eraser.Pseudonymize)
+}
+
+func (a *api) eraseUser(w http.ResponseWriter, r *http.Request, erase func(ctx context.Context, user, actor, reason string) (privacy.AuditEntry, error)) {
+	user := r.PathValue("user")
+	if !userPattern.MatchString(user) {
+		a.badRequest(w, fmt.Errorf("invalid user %q", user))
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if len(reason) > maxReasonLength {
+		a.badRequest(w, fmt.Errorf("reason is longer than %d characters", maxReasonLength))
+		return
+	}
+	// Changes are recorded under the name of the admin key, they are never made anonymously
+	key, ok := auth.KeyFromContext(r.Context())
+	if !ok {
+		a.writeJSON(w, http.StatusUnauthorized, apiError{Error: "an admin API key is required"})
+		return
+	}
+	actor := key.Name
+
+	entry, err := erase(r.Context(), user, actor, reason)
+	if errors.Is(err, privacy.ErrNoSalt) {
+		a.writeJSON(w, http.StatusNotImplemented, apiError{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	// Cached leaderboards and profiles would still show the user
+	a.resultsService.ClearCache()
+	a.logger.Infof("%s %s of a user, %d results, audit entry %d", actor, entry.Action, entry.Rows, entry.ID)
+	a.writeJSON(w, http.StatusOK, entry)
+}
+
+func (a *api) audit(w http.ResponseWriter, r *http.Request) {
+	entries, err := a.eraser.Audit(r.Context())
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, entries)
+}
+
+func (a *api) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		a.logger.Errorf("Failed to encode response: %v", err)
+	}
+}
+
+func (a *api) badRequest(w http.ResponseWriter, err error) {
+	a.writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+}
+
+func (a *api) internalError(w http.ResponseWriter, err error) {
+	a.logger.Errorf("Failed to handle request: %v", err)
+	a.writeJSON(w, http.StatusInternalServerError, apiError{Error: "internal server error"})
+}
+
+func parseChannel(r *http.Request) (string, error) {
+	channel := r.PathValue("channel")
+	if !channelPattern.MatchString(channel) {
+		return "", fmt.Errorf("invalid channel %q", channel)
+	}
+	return channel, nil
+}
+
+// Parses the optional RFC 3339 "from" and "to" parameters, defaulting to the hour before now
+func parseRange(query url.Values, now time.Time) (time.Time, time.Time, error) {
+	return parseRangeWithDefault(query, now, defaultRange)
+}
+
+// Parses the optional RFC 3339 "from" and "to" parameters, defaulting to the given range before now
+func parseRangeWithDefault(query url.Values, now time.Time, defaultRange time.Duration) (time.Time, time.Time, error) {
+	to := now
+	if value := query.Get("to"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid \"to\", expected an RFC 3339 timestamp")
+		}
+		to = t
+	}
+	from := to.Add(-defaultRange)
+	if value := query.Get("from"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid \"from\", expected an RFC 3339 timestamp")
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("\"from\" must be before \"to\"")
+	}
+	return from, to, nil
+}
+
+// End of the current minute, so ranges ending now are the same for a minute and their results can be cached
+func cacheableNow() time.Time {
+	return time.Now().Truncate(time.Minute).Add(time.Minute)
+}
+
+func parseLimit(query url.Values) (int64, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return defaultResultLimit, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 1 || limit > maxResultLimit {
+		return 0, fmt.Errorf("invalid \"limit\", expected an integer between 1 and %d", maxResultLimit)
+	}
+	return limit, nil
+}
+
+// Parses an optional sentiment score between 0 and 1, defaulting to 0
+func parseScore(query url.Values, name string) (float64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	score, err := strconv.ParseFloat(value, 64)
+	if err != nil || score < 0 || score > 1 {
+		return 0, fmt.Errorf("invalid %q, expected a number between 0 and 1", name)
+	}
+	return score, nil
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+	"website/internal/auth"
+	"website/internal/database"
+	"website/internal/privacy"
+	"website/internal/service"
+	"website/internal/testutils"
+	"website/internal/trends"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseRange(t *testing.T) {
+	now := time.Date(2024, 12, 1, 15, 0, 0, 0, time.UTC)
+
+	t.Run("defaults to the last hour", func(t *testing.T) {
+		from, to, err := parseRange(url.Values{}, now)
+		require.NoError(t, err)
+		require.Equal(t, now.Add(-time.Hour), from)
+		require.Equal(t, now, to)
+	})
+	t.Run("explicit range", func(t *testing.T) {
+		from, to, err := parseRange(url.Values{
+			"from": {"2024-12-01T14:00:00Z"},
+			"to":   {"2024-12-01T14:30:00Z"},
+		}, now)
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC), from.UTC())
+		require.Equal(t, time.Date(2024, 12, 1, 14, 30, 0, 0, time.UTC), to.UTC())
+	})
+	t.Run("invalid timestamp", func(t *testing.T) {
+		_, _, err := parseRange(url.Values{"from": {"yesterday"}}, now)
+		require.Error(t, err)
+	})
+	t.Run("from after to", func(t *testing.T) {
+		_, _, err := parseRange(url.Values{
+			"from": {"2024-12-01T15:00:00Z"},
+			"to":   {"2024-12-01T14:00:00Z"},
+		}, now)
+		require.Error(t, err)
+	})
+}
+
+func TestParseLimit(t *testing.T) {
+	limit, err := parseLimit(url.Values{})
+	require.NoError(t, err)
+	require.EqualValues(t, defaultResultLimit, limit)
+
+	limit, err = parseLimit(url.Values{"limit": {"10"}})
+	require.NoError(t, err)
+	require.EqualValues(t, 10, limit)
+
+	for _, value := range []string{"0", "-1", "1001", "ten"} {
+		_, err = parseLimit(url.Values{"limit": {value}})
+		require.Error(t, err, value)
+	}
+}
+
+func TestApi(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+
+	databaseChannels := 3
+	messagesPerChannel := 5
+	err = testutils.PopulateDatabase(dsn, databaseChannels, messagesPerChannel)
+	require.NoError(t, err)
+
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+
+	mux := http.NewServeMux()
+	resultsService := service.NewResultsService(conn, logger)
+	registerApiRoutes(mux, resultsService, auth.NewStore(conn), privacy.NewEraser(conn, logger), nil, logger)
+	registerOverlayRoutes(mux, resultsService, logger)
+
+	get := func(path string, status int, body any) {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		require.Equal(t, status, recorder.Code, recorder.Body.String())
+		if body != nil {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), body))
+		}
+	}
+
+	var channels []service.Channel
+	get("/api/v1/channels", http.StatusOK, &channels)
+	require.Len(t, channels, databaseChannels)
+
+	var averages []service.AverageResult
+	get("/api/v1/channels/channel0/averages?from=2024-12-01T14:00:00Z&to=2024-12-01T15:00:00Z", http.StatusOK, &averages)
+	require.Len(t, averages, messagesPerChannel)
+
+	var page service.ResultsPage
+	get("/api/v1/channels/channel0/results?from=2024-12-01T14:00:00Z&to=2024-12-01T15:00:00Z&limit=3", http.StatusOK, &page)
+	require.Len(t, page.Results, 3)
+	require.NotEmpty(t, page.NextCursor)
+
+	get("/api/v1/channels/channel0/results?from=2024-12-01T14:00:00Z&to=2024-12-01T15:00:00Z&limit=3&cursor="+page.NextCursor, http.StatusOK, &page)
+	require.Len(t, page.Results, 2)
+	require.Empty(t, page.NextCursor)
+
+	var summary service.ChannelSummary
+	get("/api/v1/channels/channel0/summary?from=2024-12-01T14:00:00Z&to=2024-12-01T15:00:00Z", http.StatusOK, &summary)
+	require.EqualValues(t, messagesPerChannel, summary.Messages)
+
+	var users []service.UserStats
+	get("/api/v1/channels/channel0/leaderboard?from=2024-12-01T14:00:00Z&to=2024-12-01T15:00:00Z", http.StatusOK, &users)
+	require.Len(t, users, 1)
+	require.EqualValues(t, messagesPerChannel, users[0].Messages)
+
+	var profile service.UserProfile
+	get("/api/v1/users/user0?from=2024-12-01T14:00:00Z&to=2024-12-01T15:00:00Z&bucket=minute", http.StatusOK, &profile)
+	require.Len(t, profile.Channels, 1)
+	require.Len(t, profile.History["channel0"], messagesPerChannel)
+
+	// The sample messages are older than any trends window
+	var channelTrends trends.Trends
+	get("/api/v1/channels/channel0/trends?window=60&limit=5", http.StatusOK, &channelTrends)
+	require.Equal(t, "channel0", channelTrends.Channel)
+	require.Zero(t, channelTrends.Messages)
+	require.Empty(t, channelTrends.Unigrams)
+	get("/api/v1/channels/channel0/trends?window=61", http.StatusBadRequest, nil)
+	get("/api/v1/channels/channel0/trends?limit=0", http.StatusBadRequest, nil)
+
+	get("/api/v1/channels/channel0/leaderboard?order=loudest", http.StatusBadRequest, nil)
+	get("/api/v1/channels/channel0/leaderboard?min_messages=0", http.StatusBadRequest, nil)
+	get("/api/v1/users/not%20a%20user", http.StatusBadRequest, nil)
+
+	// The sample messages are older than any mood window
+	var mood service.Mood
+	get("/overlay/channel0/mood?window=15", http.StatusOK, &mood)
+	require.Equal(t, service.Mood{Channel: "channel0", Window: 15, Mood: service.MoodQuiet, UpdatedAt: mood.UpdatedAt}, mood)
+
+	var search service.SearchPage
+	get("/api/v1/search?q=sample&channel=channel0&from=2024-12-01T14:00:00Z&to=2024-12-01T15:00:00Z&min_positive=0.5", http.StatusOK, &search)
+	require.Len(t, search.Results, messagesPerChannel)
+	require.Equal(t, "channel0", search.Results[0].Channel)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/channels/channel0/export?from=2024-12-01T14:00:00Z&to=2024-12-01T15:00:00Z&format=csv&gzip=true", nil)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, `attachment; filename=channel0-results-20241201T1400Z-20241201T1500Z.csv.gz`, recorder.Header().Get("Content-Disposition"))
+	reader, err := gzip.NewReader(recorder.Body)
+	require.NoError(t, err)
+	records, err := csv.NewReader(reader).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, messagesPerChannel+1)
+
+	var comparison service.Comparison
+	get("/api/v1/compare?channels=channel0,channel1&metric=index&from=2024-12-01T14:00:00Z&to=2024-12-01T15:00:00Z", http.StatusOK, &comparison)
+	require.Len(t, comparison.Timestamps, 60)
+	require.Len(t, comparison.Series, 2)
+	require.Len(t, comparison.Pairs, 1)
+	require.Equal(t, messagesPerChannel, comparison.Pairs[0].Points)
+	require.InDelta(t, 0, *comparison.Pairs[0].MeanDifference, 1e-9)
+
+	get("/api/v1/compare?channels=channel0", http.StatusBadRequest, nil)
+	get("/api/v1/compare?channels=channel0,channel1&max_lag=61", http.StatusBadRequest, nil)
+	get("/api/v1/compare?channels=channel0,channel1&metric=mood", http.StatusBadRequest, nil)
+	get("/api/v1/channels/channel0/export?format=xlsx", http.StatusBadRequest, nil)
+	get("/api/v1/channels/channel0/export?dataset=users", http.StatusBadRequest, nil)
+	get("/api/v1/channels/channel0/export?gzip=maybe", http.StatusBadRequest, nil)
+	get("/api/v1/search?channel=channel0", http.StatusBadRequest, nil)
+	get("/api/v1/search?q=sample&min_negative=2", http.StatusBadRequest, nil)
+	get("/api/v1/channels/channel0/averages?bucket=week", http.StatusBadRequest, nil)
+	get("/api/v1/channels/channel0/results?cursor=invalid", http.StatusBadRequest, nil)
+	get("/api/v1/channels/not%20a%20channel/summary", http.StatusBadRequest, nil)
+	get("/api/v1/openapi.yaml", http.StatusOK, nil)
+
+	send := func(method, path, key string, status int, body any) {
+		request := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			request.Header.Set("Authorization", "Bearer "+key)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		require.Equal(t, status, recorder.Code, recorder.Body.String())
+		if body != nil {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), body))
+		}
+	}
+	// Without authentication the admin routes are not served
+	send(http.MethodDelete, "/api/v1/users/user2", "", http.StatusMethodNotAllowed, nil)
+	send(http.MethodPost, "/api/v1/users/user2/pseudonymize", "", http.StatusNotFound, nil)
+	get("/api/v1/keys", http.StatusNotFound, nil)
+	get("/api/v1/audit", http.StatusNotFound, nil)
+
+	// With authentication, routes need a key with their scope
+	keys := auth.NewStore(conn)
+	register := func() {
+		mux = http.NewServeMux()
+		registerApiRoutes(mux, service.NewResultsService(conn, logger), keys, privacy.NewEraser(conn, logger), auth.NewAuthenticator(keys, logger), logger)
+	}
+	register()
+	_, readKey, err := keys.Create(ctx, "reader", []auth.Scope{auth.ScopeRead}, 10, 10)
+	require.NoError(t, err)
+	_, adminKey, err := keys.Create(ctx, "admin", []auth.Scope{auth.ScopeAdmin}, 10, 10)
+	require.NoError(t, err)
+
+	// Users can not be pseudonymized without a salt
+	send(http.MethodPost, "/api/v1/users/user2/pseudonymize", adminKey, http.StatusNotImplemented, nil)
+	send(http.MethodDelete, "/api/v1/users/not%20a%20user", adminKey, http.StatusBadRequest, nil)
+	var entry privacy.AuditEntry
+	send(http.MethodDelete, "/api/v1/users/user2?reason=erasure+request", adminKey, http.StatusOK, &entry)
+	require.Equal(t, privacy.ActionDelete, entry.Action)
+	require.EqualValues(t, messagesPerChannel, entry.Rows)
+	require.Equal(t, "admin", entry.Actor)
+	require.Equal(t, "erasure request", entry.Reason)
+	var audit []privacy.AuditEntry
+	send(http.MethodGet, "/api/v1/audit", adminKey, http.StatusOK, &audit)
+	require.Equal(t, []privacy.AuditEntry{entry}, audit)
+
+	t.Setenv("USER_HASH_SALT", "salt")
+	register()
+
+	sendWithKey := func(method, path, key string) int {
+		request := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			request.Header.Set("Authorization", "Bearer "+key)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	getWithKey := func(path, key string) int {
+		return sendWithKey(http.MethodGet, path, key)
+	}
+	require.Equal(t, http.StatusUnauthorized, getWithKey("/api/v1/channels", ""))
+	require.Equal(t, http.StatusOK, getWithKey("/api/v1/channels", readKey))
+	require.Equal(t, http.StatusOK, getWithKey("/api/v1/openapi.yaml", ""))
+	require.Equal(t, http.StatusForbidden, getWithKey("/api/v1/keys", readKey))
+	require.Equal(t, http.StatusOK, getWithKey("/api/v1/keys", adminKey))
+	require.Equal(t, http.StatusForbidden, sendWithKey(http.MethodPost, "/api/v1/users/user1/pseudonymize", readKey))
+	require.Equal(t, http.StatusOK, sendWithKey(http.MethodPost, "/api/v1/users/user1/pseudonymize", adminKey))
+	require.Equal(t, http.StatusForbidden, getWithKey("/api/v1/audit", readKey))
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"website/internal/alerting"
+	"website/internal/auth"
+	"website/internal/service"
+
+	"go.uber.org/zap"
+)
+
+// Values of the averages served as Grafana series, named "<channel>.<metric>"
+var grafanaMetrics = map[string]func(service.AverageResult) float64{
+	"messages": func(r service.AverageResult) float64 { return float64(r.Messages) },
+	"positive": func(r service.AverageResult) float64 { return r.AveragePositiveSentiment },
+	"neutral":  func(r service.AverageResult) float64 { return r.AverageNeutralSentiment },
+	"negative": func(r service.AverageResult) float64 { return r.AverageNegativeSentiment },
+	"index":    func(r service.AverageResult) float64 { return r.AveragePositiveSentiment - r.AverageNegativeSentiment },
+}
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaTarget struct {
+	Target string `json:"target"`
+	RefId  string `json:"refId"`
+	// timeserie (default) or table
+	Type string `json:"type"`
+	Hide bool   `json:"hide"`
+}
+
+type grafanaQuery struct {
+	Range         grafanaRange    `json:"range"`
+	IntervalMs    int64           `json:"intervalMs"`
+	MaxDataPoints int64           `json:"maxDataPoints"`
+	Targets       []grafanaTarget `json:"targets"`
+}
+
+type grafanaAnnotationQuery struct {
+	Range      grafanaRange `json:"range"`
+	Annotation struct {
+		Name string `json:"name"`
+		// Comma separated channels of the alerts and stream events, every channel when empty
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+type grafanaAnnotation struct {
+	Annotation any      `json:"annotation"`
+	Time       int64    `json:"time"`
+	Title      string   `json:"title"`
+	Text       string   `json:"text"`
+	Tags       []string `json:"tags"`
+}
+
+// Serves the averages, the fired alerts and the stream events with the Grafana JSON datasource protocol
+type grafana struct {
+	*api
+	alerts *alerting.Store
+}
+
+// Registers the Grafana JSON datasource routes under /grafana, which need a read key unless authenticator is nil
+func registerGrafanaRoutes(mux *http.ServeMux, resultsService *service.ResultsService, alerts *alerting.Store, authenticator *auth.Authenticator, logger *zap.SugaredLogger) {
+	g := &grafana{api: &api{resultsService: resultsService, logger: logger}, alerts: alerts}
+	handle := func(pattern string, handler http.HandlerFunc) {
+		if authenticator == nil {
+			mux.Handle(pattern, handler)
+			return
+		}
+		mux.Handle(pattern, authenticator.Require(auth.ScopeRead, handler))
+	}
+
+	// Tested by Grafana when the datasource is saved
+	handle("GET /grafana/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handle("POST /grafana/search", g.search)
+	handle("POST /grafana/query", g.query)
+	handle("POST /grafana/annotations", g.annotations)
+}
+
+// Lists the series of every channel, keeping those that contain the target
+func (g *grafana) search(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Target string `json:"target"`
+	}
+	if err := decodeGrafanaBody(w, r, &body); err != nil {
+		g.badRequest(w, err)
+		return
+	}
+	channels, err := g.resultsService.GetChannels(r.Context())
+	if err != nil {
+		g.internalError(w, err)
+		return
+	}
+
+	targets := []string{}
+	for _, channel := range channels {
+		for metric := range grafanaMetrics {
+			target := channel.Channel + "." + metric
+			if strings.Contains(target, body.Target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	slices.Sort(targets)
+	g.writeJSON(w, http.StatusOK, targets)
+}
+
+func (g *grafana) query(w http.ResponseWriter, r *http.Request) {
+	var query grafanaQuery
+	if err := decodeGrafanaBody(w, r, &query); err != nil {
+		g.badRequest(w, err)
+		return
+	}
+	from, to := query.Range.From, query.Range.To
+	if !from.Before(to) {
+		g.badRequest(w, errors.New("range \"from\" must be before \"to\""))
+		return
+	}
+	bucket, err := grafanaBucket(from, to, time.Duration(query.IntervalMs)*time.Millisecond, query.MaxDataPoints)
+	if err != nil {
+		g.badRequest(w, err)
+		return
+	}
+
+	// Targets of the same channel share its averages
+	averages := map[string][]service.AverageResult{}
+	response := []any{}
+	for _, target := range query.Targets {
+		if target.Hide || target.Target == "" {
+			continue
+		}
+		channel, metric, err := parseGrafanaTarget(target.Target)
+		if err != nil {
+			g.badRequest(w, err)
+			return
+		}
+		channelAverages, found := averages[channel]
+		if !found {
+			if channelAverages, err = g.resultsService.GetChannelAverageResults(r.Context(), channel, from, to, bucket); err != nil {
+				g.internalError(w, err)
+				return
+			}
+			averages[channel] = channelAverages
+		}
+
+		switch target.Type {
+		case "", "timeserie":
+			series := grafanaSeries{Target: target.Target, Datapoints: make([][2]float64, 0, len(channelAverages))}
+			for _, average := range channelAverages {
+				series.Datapoints = append(series.Datapoints, [2]float64{metric(average), float64(average.Timestamp.UnixMilli())})
+			}
+			response = append(response, series)
+		case "table":
+			table := grafanaTable{
+				Type:    "table",
+				Columns: []grafanaColumn{{Text: "Time", Type: "time"}, {Text: target.Target, Type: "number"}},
+				Rows:    make([][]any, 0, len(channelAverages)),
+			}
+			for _, average := range channelAverages {
+				table.Rows = append(table.Rows, []any{average.Timestamp.UnixMilli(), metric(average)})
+			}
+			response = append(response, table)
+		default:
+			g.badRequest(w, fmt.Errorf("invalid target type %q, expected timeserie or table", target.Type))
+			return
+		}
+	}
+	g.writeJSON(w, http.StatusOK, response)
+}
+
+// Fired alerts and stream events of the channels in the query as annotations, ordered by time
+func (g *grafana) annotations(w http.ResponseWriter, r *http.Request) {
+	var query grafanaAnnotationQuery
+	if err := decodeGrafanaBody(w, r, &query); err != nil {
+		g.badRequest(w, err)
+		return
+	}
+	var channels []string
+	for _, channel := range strings.Split(query.Annotation.Query, ",") {
+		if channel = strings.TrimSpace(channel); channel == "" {
+			continue
+		}
+		if !channelPattern.MatchString(channel) {
+			g.badRequest(w, fmt.Errorf("invalid channel %q", channel))
+			return
+		}
+		channels = append(channels, channel)
+	}
+
+	alerts, err := g.alerts.List(r.Context(), channels, query.Range.From, query.Range.To)
+	if err != nil {
+		g.internalError(w, err)
+		return
+	}
+	events, err := g.resultsService.GetStreamEvents(r.Context(), channels, query.Range.From, query.Range.To, time.Now())
+	if err != nil {
+		g.internalError(w, err)
+		return
+	}
+
+	annotations := make([]grafanaAnnotation, 0, len(alerts)+len(events))
+	for _, alert := range alerts {
+		annotations = append(annotations, grafanaAnnotation{
+			Annotation: query.Annotation,
+			Time:       alert.Timestamp.UnixMilli(),
+			Title:      alert.Rule,
+			Text:       alert.String(),
+			Tags:       []string{alert.Channel, string(alert.Metric)},
+		})
+	}
+	for _, event := range events {
+		title := "Stream started"
+		if event.Kind == service.StreamEnded {
+			title = "Stream ended"
+		}
+		annotations = append(annotations, grafanaAnnotation{
+			Annotation: query.Annotation,
+			Time:       event.Timestamp.UnixMilli(),
+			Title:      title,
+			Text:       fmt.Sprintf("%s on %s, inferred from the chat activity", title, event.Channel),
+			Tags:       []string{event.Channel, "stream", string(event.Kind)},
+		})
+	}
+	slices.SortStableFunc(annotations, func(a, b grafanaAnnotation) int { return cmp.Compare(a.Time, b.Time) })
+	g.writeJSON(w, http.StatusOK, annotations)
+}
+
+func decodeGrafanaBody(w http.ResponseWriter, r *http.Request, body any) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(body); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// Splits a "<channel>.<metric>" target
+func parseGrafanaTarget(target string) (string, func(service.AverageResult) float64, error) {
+	channel, name, _ := strings.Cut(target, ".")
+	metric, found := grafanaMetrics[name]
+	if !channelPattern.MatchString(channel) || !found {
+		return "", nil, fmt.Errorf("invalid target %q, expected <channel>.<messages|positive|neutral|negative|index>", target)
+	}
+	return channel, metric, nil
+}
+
+// Finest bucket at least as long as the interval between points that keeps the points under the maximum
+func grafanaBucket(from, to time.Time, interval time.Duration, maxDataPoints int64) (service.Bucket, error) {
+	limit := int64(maxBucketsPerQuery)
+	if maxDataPoints > 0 && maxDataPoints < limit {
+		limit = maxDataPoints
+	}
+	for _, bucket := range []service.Bucket{service.BucketMinute, service.BucketHour} {
+		if bucket.Duration() >= interval && int64(to.Sub(from)/bucket.Duration()) <= limit {
+			return bucket, nil
+		}
+	}
+	if buckets := to.Sub(from) / service.BucketDay.Duration(); buckets > maxBucketsPerQuery {
+		return "", fmt.Errorf("range has %d buckets of one day, the maximum is %d", buckets, maxBucketsPerQuery)
+	}
+	return service.BucketDay, nil
+}
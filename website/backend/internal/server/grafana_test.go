@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"website/internal/alerting"
+	"website/internal/database"
+	"website/internal/service"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestGrafanaBucket(t *testing.T) {
+	from := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	bucket, err := grafanaBucket(from, from.Add(6*time.Hour), 20*time.Second, 1000)
+	require.NoError(t, err)
+	require.Equal(t, service.BucketMinute, bucket)
+
+	// Too many points for minutes
+	bucket, err = grafanaBucket(from, from.Add(24*time.Hour), 20*time.Second, 1000)
+	require.NoError(t, err)
+	require.Equal(t, service.BucketHour, bucket)
+
+	// Points further apart than an hour
+	bucket, err = grafanaBucket(from, from.Add(24*time.Hour), 2*time.Hour, 0)
+	require.NoError(t, err)
+	require.Equal(t, service.BucketDay, bucket)
+
+	_, err = grafanaBucket(from, from.Add(maxBucketsPerQuery*25*time.Hour), time.Hour, 0)
+	require.Error(t, err)
+}
+
+func TestParseGrafanaTarget(t *testing.T) {
+	channel, metric, err := parseGrafanaTarget("gaules.index")
+	require.NoError(t, err)
+	require.Equal(t, "gaules", channel)
+	require.InDelta(t, 0.5, metric(service.AverageResult{AveragePositiveSentiment: 0.7, AverageNegativeSentiment: 0.2}), 1e-9)
+
+	for _, target := range []string{"gaules", "gaules.mood", "not a channel.index", ".index"} {
+		_, _, err = parseGrafanaTarget(target)
+		require.Error(t, err, target)
+	}
+}
+
+func TestGrafana(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	require.NoError(t, testutils.PopulateDatabase(dsn, 2, 5))
+
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+	alerts := alerting.NewStore(conn)
+	require.NoError(t, alerts.Save(ctx, []alerting.Alert{
+		{Rule: "toxic chat", Channel: "channel0", Metric: alerting.MetricNegative, Timestamp: time.Date(2024, 12, 1, 14, 2, 0, 0, time.UTC), Value: 0.7, Messages: 1},
+		{Rule: "toxic chat", Channel: "channel1", Metric: alerting.MetricNegative, Timestamp: time.Date(2024, 12, 1, 14, 3, 0, 0, time.UTC), Value: 0.7, Messages: 1},
+	}))
+
+	mux := http.NewServeMux()
+	registerGrafanaRoutes(mux, service.NewResultsService(conn, logger), alerts, nil, logger)
+
+	post := func(path string, body any, status int, response any) {
+		bts, err := json.Marshal(body)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(bts)))
+		require.Equal(t, status, recorder.Code, recorder.Body.String())
+		if response != nil {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), response))
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/grafana/", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var targets []string
+	post("/grafana/search", map[string]string{"target": "channel1."}, http.StatusOK, &targets)
+	require.Equal(t, []string{"channel1.index", "channel1.messages", "channel1.negative", "channel1.neutral", "channel1.positive"}, targets)
+
+	dataRange := grafanaRange{From: time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC), To: time.Date(2024, 12, 1, 15, 0, 0, 0, time.UTC)}
+	var series []grafanaSeries
+	post("/grafana/query", grafanaQuery{Range: dataRange, IntervalMs: 1000, MaxDataPoints: 500, Targets: []grafanaTarget{
+		{Target: "channel0.messages", RefId: "A"},
+		{Target: "channel0.index", RefId: "B"},
+		{Target: "channel1.positive", RefId: "C", Hide: true},
+	}}, http.StatusOK, &series)
+	require.Len(t, series, 2)
+	require.Len(t, series[0].Datapoints, 5)
+	require.Equal(t, [2]float64{1, float64(dataRange.From.UnixMilli())}, series[0].Datapoints[0])
+	require.InDelta(t, 0.7, series[1].Datapoints[0][0], 1e-9)
+
+	var tables []grafanaTable
+	post("/grafana/query", grafanaQuery{Range: dataRange, Targets: []grafanaTarget{{Target: "channel1.neutral", Type: "table"}}}, http.StatusOK, &tables)
+	require.Len(t, tables, 1)
+	require.Len(t, tables[0].Rows, 5)
+
+	post("/grafana/query", grafanaQuery{Range: dataRange, Targets: []grafanaTarget{{Target: "channel0.mood"}}}, http.StatusBadRequest, nil)
+	post("/grafana/query", grafanaQuery{Range: grafanaRange{From: dataRange.To, To: dataRange.From}}, http.StatusBadRequest, nil)
+
+	var annotations []grafanaAnnotation
+	query := grafanaAnnotationQuery{Range: dataRange}
+	query.Annotation.Query = "channel1"
+	post("/grafana/annotations", query, http.StatusOK, &annotations)
+	require.Len(t, annotations, 3)
+	require.Equal(t, "Stream started", annotations[0].Title)
+	require.Equal(t, []string{"channel1", "stream", "start"}, annotations[0].Tags)
+	require.Equal(t, dataRange.From.UnixMilli(), annotations[0].Time)
+	require.Equal(t, "toxic chat", annotations[1].Title)
+	require.Equal(t, []string{"channel1", "negative"}, annotations[1].Tags)
+	require.Equal(t, []string{"channel1", "stream", "end"}, annotations[2].Tags)
+	require.Equal(t, dataRange.From.Add(4*time.Minute).UnixMilli(), annotations[2].Time)
+
+	query.Annotation.Query = ""
+	post("/grafana/annotations", query, http.StatusOK, &annotations)
+	require.Len(t, annotations, 6)
+}
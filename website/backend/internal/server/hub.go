@@ -1,11 +1,15 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
@@ -14,12 +18,6 @@ import (
 )
 
 var (
-	upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
-
 	clientsGauge = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "broadcast_hub_clients_total",
 	})
@@ -27,23 +25,75 @@ var (
 	messagesCounter = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "broadcast_hub_messages_total",
 	})
+
+	droppedFramesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broadcast_hub_dropped_frames_total",
+	}, []string{"policy"})
+
+	evictedClientsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broadcast_hub_evicted_clients_total",
+	}, []string{"reason"})
+)
+
+const (
+	maxCommandSize = 4096
+	// Broadcast events kept to resume clients that reconnect
+	historySize = 256
+	// How long a closed client has to answer the close frame
+	closeGracePeriod = time.Second
 )
 
 type Client struct {
-	conn *websocket.Conn
-	send chan []byte
+	addr string
+	// Nil for clients that are not connected over WebSocket
+	conn  *websocket.Conn
+	queue *clientQueue
+
+	// Owned by the hub event loop
+	subscription *subscription
+	// Whether a snapshot was requested to replace dropped frames
+	pendingSnapshot bool
+}
+
+type clientCommand struct {
+	client  *Client
+	command command
+}
+
+type clientEvents struct {
+	client *Client
+	events []hubEvent
+}
+
+// Provides the current state of every stream to clients before they receive deltas
+type snapshotProvider interface {
+	sendSnapshot(ctx context.Context, client *Client) error
 }
 
 func (c *Client) String() string {
-	return fmt.Sprintf("Client - %v", c.conn.RemoteAddr())
+	return fmt.Sprintf("Client - %v", c.addr)
 }
 
 // Handles connected clients and broadcasts messages
 type broadcastHub struct {
-	clients    map[*Client]bool
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan []byte
+	clients   map[*Client]bool
+	commands  chan clientCommand
+	direct    chan clientEvents
+	broadcast chan hubEvent
+
+	snapshots snapshotProvider
+	config    hubConfig
+
+	// Last broadcast events, oldest first, and the sequence of the last one of each stream
+	history []hubEvent
+	latest  map[string]uint64
+
+	// Number of clients, readable without the lock
+	count atomic.Int64
+	// Closed when the hub stops accepting clients and events
+	done chan struct{}
+	// Connection handlers of the registered clients
+	handlers *sync.WaitGroup
 
 	mu *sync.Mutex
 
@@ -51,107 +101,440 @@ type broadcastHub struct {
 }
 
 func NewBroadcastHub(logger *zap.SugaredLogger) *broadcastHub {
+	config := loadHubConfig(logger)
+	logger.Infof("Broadcast hub %s", config)
+
 	return &broadcastHub{
-		clients:    make(map[*Client]bool),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan []byte),
-		mu:         &sync.Mutex{},
-		logger:     logger,
+		config:    config,
+		clients:   make(map[*Client]bool),
+		commands:  make(chan clientCommand),
+		direct:    make(chan clientEvents),
+		broadcast: make(chan hubEvent),
+		latest:    map[string]uint64{},
+		done:      make(chan struct{}),
+		handlers:  &sync.WaitGroup{},
+		mu:        &sync.Mutex{},
+		logger:    logger,
 	}
 }
 
-// Starts event loop
-func (h *broadcastHub) Start() {
+// Runs the event loop until the context is done, then closes every client connection
+func (h *broadcastHub) Run(ctx context.Context) {
+	defer h.shutdown()
+
 	for {
 		select {
-		case client := <-h.register:
+		case command := <-h.commands:
 			h.mu.Lock()
-			h.logger.Debug("Registering client", client)
-			h.clients[client] = true
-			clientsGauge.Inc()
+			if _, ok := h.clients[command.client]; ok {
+				h.handleCommand(command.client, command.command)
+			}
 			h.mu.Unlock()
 
-		case client := <-h.unregister:
+		case direct := <-h.direct:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				h.logger.Debug("Unregistering client", client)
-				delete(h.clients, client)
-				close(client.send)
-				clientsGauge.Dec()
+			if _, ok := h.clients[direct.client]; ok {
+				// Also sent without events when a requested snapshot failed, so deltas are queued again
+				direct.client.pendingSnapshot = false
+				for _, event := range direct.events {
+					if filtered, ok := direct.client.subscription.filter(event); ok {
+						if message := h.encode(filtered); message != nil {
+							h.trySend(direct.client, eventFrame(event, message))
+						}
+					}
+				}
 			}
 			h.mu.Unlock()
 
-		case message := <-h.broadcast:
+		case event := <-h.broadcast:
 			h.mu.Lock()
 			messagesCounter.Inc()
+			h.record(event)
+			// Clients with the same subscription share the encoded payload
+			payloads := map[string][]byte{}
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					delete(h.clients, client)
-					close(client.send)
+				key := client.subscription.key()
+				message, found := payloads[key]
+				if !found {
+					filtered, ok := client.subscription.filter(event)
+					if ok {
+						message = h.encode(filtered)
+					}
+					payloads[key] = message
 				}
+				if message == nil {
+					continue
+				}
+				h.trySend(client, eventFrame(event, message))
 			}
 			h.mu.Unlock()
+
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func (h *broadcastHub) Stop() {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
+// Sends a close frame to every client and waits for their connections to be closed
+func (h *broadcastHub) shutdown() {
 	h.logger.Info("Stopping broadcastHub")
 
+	h.mu.Lock()
+	close(h.done)
 	for client := range h.clients {
 		h.logger.Debugf("Closing connection for client: %s", client)
-		if err := client.conn.Close(); err != nil {
-			h.logger.Errorf("Error closing client connection: %v", err)
-		}
+		h.removeLocked(client)
+		client.queue.close(websocket.CloseGoingAway, "server shutting down")
 	}
+	h.mu.Unlock()
 
+	h.handlers.Wait()
 	h.logger.Info("broadcastHub stopped")
 }
 
+// Number of connected clients, safe to call from any goroutine
+func (h *broadcastHub) ClientCount() int {
+	return int(h.count.Load())
+}
+
+// Registers the client, unless the hub is stopped.
+// The client handler must call remove and then handlers.Done once finished.
+func (h *broadcastHub) add(client *Client) bool {
+	added, _ := h.addFrom(client, nil)
+	return added
+}
+
+// Registers the client and queues the events it missed since the position, the last sequence it got of each stream.
+// Returns whether it was resumed, otherwise it needs a snapshot.
+func (h *broadcastHub) addFrom(client *Client, position map[string]uint64) (added bool, resumed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	select {
+	case <-h.done:
+		return false, false
+	default:
+	}
+	h.logger.Debug("Registering client", client)
+	h.clients[client] = true
+	h.count.Add(1)
+	clientsGauge.Inc()
+	h.handlers.Add(1)
+
+	if position == nil {
+		return true, false
+	}
+	missed, ok := h.missed(client.subscription, position)
+	if !ok {
+		return true, false
+	}
+	for _, event := range missed {
+		if filtered, ok := client.subscription.filter(event); ok {
+			if message := h.encode(filtered); message != nil {
+				h.trySend(client, eventFrame(event, message))
+			}
+		}
+	}
+	return true, true
+}
+
+// Must be called with the lock held
+func (h *broadcastHub) record(event hubEvent) {
+	if len(h.history) == historySize {
+		h.history[0] = hubEvent{}
+		h.history = h.history[1:]
+	}
+	h.history = append(h.history, event)
+	h.latest[event.stream()] = max(h.latest[event.stream()], event.seq)
+}
+
+// Events of the subscribed streams after the position, or false if some of them are no longer in the history.
+// Must be called with the lock held.
+func (h *broadcastHub) missed(sub *subscription, position map[string]uint64) ([]hubEvent, bool) {
+	for _, stream := range events {
+		if !sub.allEvents && !sub.events[stream] {
+			continue
+		}
+		last, latest := position[stream], h.latest[stream]
+		if last == latest {
+			continue
+		}
+		if last > latest {
+			return nil, false
+		}
+		found := false
+		for _, event := range h.history {
+			if event.stream() == stream && event.seq == last+1 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	missed := []hubEvent{}
+	for _, event := range h.history {
+		if event.seq > position[event.stream()] {
+			missed = append(missed, event)
+		}
+	}
+	return missed, true
+}
+
+func (h *broadcastHub) remove(client *Client) {
+	h.mu.Lock()
+	if _, ok := h.clients[client]; ok {
+		h.logger.Debug("Unregistering client", client)
+		h.removeLocked(client)
+	}
+	h.mu.Unlock()
+	client.queue.close(0, "")
+}
+
+// Must be called with the lock held
+func (h *broadcastHub) removeLocked(client *Client) {
+	delete(h.clients, client)
+	h.count.Add(-1)
+	clientsGauge.Dec()
+}
+
+// Delivers the event to every client. Returns false if the hub is stopped.
+func (h *broadcastHub) send(event hubEvent) bool {
+	select {
+	case h.broadcast <- event:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Delivers the events to a single client. Returns false if the hub is stopped.
+func (h *broadcastHub) sendTo(client *Client, events []hubEvent) bool {
+	select {
+	case h.direct <- clientEvents{client: client, events: events}:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Applies a command of the client. Returns false if the hub is stopped.
+func (h *broadcastHub) command(client *Client, cmd command) bool {
+	select {
+	case h.commands <- clientCommand{client: client, command: cmd}:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Applies a subscription command and replies with the resulting subscription or the error
+func (h *broadcastHub) handleCommand(client *Client, cmd command) {
+	reply := Event{Event: "subscription"}
+	if err := client.subscription.apply(cmd); err != nil {
+		reply = Event{Event: "error", Data: err.Error()}
+	} else {
+		reply.Data = client.subscription.state()
+	}
+	if message := h.encode(reply); message != nil {
+		h.trySend(client, frame{data: message})
+	}
+}
+
+func (h *broadcastHub) encode(event Event) []byte {
+	bts, err := json.Marshal(event)
+	if err != nil {
+		h.logger.Errorf("Failed to encode %s event to json: %v", event.Event, err)
+		return nil
+	}
+	return bts
+}
+
+// Queues the frame, applying the drop policy when the client is not keeping up.
+// Must be called with the lock held.
+func (h *broadcastHub) trySend(client *Client, f frame) {
+	// The requested snapshot supersedes any delta sent before it
+	if client.pendingSnapshot && f.stream != "" {
+		droppedFramesCounter.WithLabelValues(string(coalesceSnapshot)).Inc()
+		return
+	}
+	if client.queue.push(f) {
+		return
+	}
+
+	switch h.config.dropPolicy {
+	case dropOldest:
+		dropped := client.queue.pushDroppingOldest(f)
+		droppedFramesCounter.WithLabelValues(string(dropOldest)).Add(float64(dropped))
+	case coalesceSnapshot:
+		if !hasSnapshot(f.stream) || h.snapshots == nil {
+			dropped := client.queue.pushDroppingOldest(f)
+			droppedFramesCounter.WithLabelValues(string(coalesceSnapshot)).Add(float64(dropped))
+			return
+		}
+		// The frame and the queued ones of its stream are replaced by a snapshot
+		dropped := client.queue.dropStream(f.stream) + 1
+		droppedFramesCounter.WithLabelValues(string(coalesceSnapshot)).Add(float64(dropped))
+		if !client.pendingSnapshot {
+			client.pendingSnapshot = true
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), h.config.writeTimeout)
+				defer cancel()
+				// The client finds the dropped deltas by their sequence and asks to resync
+				if err := h.snapshots.sendSnapshot(ctx, client); err != nil {
+					h.sendTo(client, nil)
+				}
+			}()
+		}
+	default:
+		h.logger.Debugf("Disconnecting slow client %s", client)
+		droppedFramesCounter.WithLabelValues(string(disconnect)).Inc()
+		h.evict(client, "slow")
+	}
+}
+
+// Removes the client, its writer sends the close frame.
+// Must be called with the lock held.
+func (h *broadcastHub) evict(client *Client, reason string) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	h.removeLocked(client)
+	evictedClientsCounter.WithLabelValues(reason).Inc()
+	client.queue.close(websocket.ClosePolicyViolation, "client too slow")
+}
+
 // Handles the WebSocket connection for a single client
-func handleClient(hub *broadcastHub, conn *websocket.Conn, logger *zap.SugaredLogger) {
-	client := &Client{conn: conn, send: make(chan []byte)}
-	hub.register <- client
+func handleClient(ctx context.Context, hub *broadcastHub, conn *websocket.Conn, logger *zap.SugaredLogger) {
+	client := &Client{
+		addr:         conn.RemoteAddr().String(),
+		conn:         conn,
+		queue:        newClientQueue(hub.config.queueSize),
+		subscription: newSubscription(),
+	}
+	if !hub.add(client) {
+		message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(hub.config.writeTimeout))
+		conn.Close()
+		return
+	}
 
+	written := make(chan struct{})
 	defer func() {
-		hub.unregister <- client
+		hub.remove(client)
 		err := conn.Close()
 		if err != nil && !errors.Is(err, net.ErrClosed) {
 			logger.Error("failed to close client connection: ", err)
 		}
+		<-written
+		hub.handlers.Done()
 	}()
 
-	// Start a goroutine to write messages to the client
+	// Any message, including pongs, keeps the client alive until it is being closed
+	keepAlive := func() error {
+		if client.queue.closing() {
+			return nil
+		}
+		return conn.SetReadDeadline(time.Now().Add(hub.config.idleTimeout))
+	}
+	conn.SetReadLimit(maxCommandSize)
+	keepAlive()
+	conn.SetPongHandler(func(string) error {
+		return keepAlive()
+	})
+
 	go func() {
-		for message := range client.send {
-			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				logger.Error("failed to send message to client: ", err)
-				return
+		writeClient(hub.config, client, logger)
+		close(written)
+	}()
+
+	if hub.snapshots != nil {
+		hub.snapshots.sendSnapshot(ctx, client)
+	}
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && !client.queue.closing() {
+				logger.Debugf("Client %s timed out", client)
+				evictedClientsCounter.WithLabelValues("idle").Inc()
 			}
+			return
 		}
-	}()
+		keepAlive()
+
+		var cmd command
+		if err := json.Unmarshal(message, &cmd); err != nil {
+			logger.Debugf("Ignoring invalid command from %s: %v", client, err)
+			continue
+		}
+		// Clients that missed a delta ask for the current state again
+		if cmd.Action != "resync" && !hub.command(client, cmd) {
+			return
+		}
+		if hub.snapshots != nil {
+			hub.snapshots.sendSnapshot(ctx, client)
+		}
+	}
+}
+
+// Writes queued frames and pings to the client until it is removed from the hub.
+// Only this goroutine writes to the connection.
+func writeClient(config hubConfig, client *Client, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(config.pingInterval)
+	defer ticker.Stop()
 
 	for {
-		// Read loop to keep the connection open
-		if _, _, err := conn.ReadMessage(); err != nil {
+		select {
+		case <-client.queue.ready:
+			for {
+				f, ok := client.queue.pop()
+				if !ok {
+					break
+				}
+				client.conn.SetWriteDeadline(time.Now().Add(config.writeTimeout))
+				if err := client.conn.WriteMessage(websocket.TextMessage, f.data); err != nil {
+					logger.Debugf("failed to send message to client %s: %v", client, err)
+					client.conn.Close()
+					return
+				}
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(config.writeTimeout))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				logger.Debugf("failed to ping client %s: %v", client, err)
+				client.conn.Close()
+				return
+			}
+		case <-client.queue.done:
+			code, reason := client.queue.closeReason()
+			if code == 0 {
+				return
+			}
+			// The reader ends when the client answers the close frame or after the grace period
+			message := websocket.FormatCloseMessage(code, reason)
+			if err := client.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(config.writeTimeout)); err != nil {
+				logger.Debugf("failed to send close frame to client %s: %v", client, err)
+				client.conn.Close()
+				return
+			}
+			client.conn.SetReadDeadline(time.Now().Add(closeGracePeriod))
 			return
 		}
 	}
 }
 
-func wsHandler(hub *broadcastHub, logger *zap.SugaredLogger) http.HandlerFunc {
+func wsHandler(hub *broadcastHub, origins originPolicy, logger *zap.SugaredLogger) http.HandlerFunc {
+	upgrader := websocket.Upgrader{CheckOrigin: origins.check}
 	return func(w http.ResponseWriter, r *http.Request) {
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			logger.Errorf("Failed to upgrade connection: %v", err)
 			return
 		}
-		handleClient(hub, conn, logger.Named("client-handler"))
+		handleClient(r.Context(), hub, conn, logger.Named("client-handler"))
 	}
 }
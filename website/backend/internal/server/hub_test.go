@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// Meant to be run with -race
+func TestHubManyClients(t *testing.T) {
+	hub := NewBroadcastHub(logger)
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	hubStopped := make(chan struct{})
+	go func() {
+		hub.Run(hubCtx)
+		close(hubStopped)
+	}()
+
+	server := httptest.NewServer(wsHandler(hub, originPolicy{}, logger))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	clients := 50
+	// Every few clients leave before the shutdown
+	leaving := func(i int) bool { return i%5 == 0 }
+
+	closeErrors := make([]error, clients)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < clients; i++ {
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer ws.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				ws.WriteJSON(command{Action: "subscribe", Channels: []string{"channel1"}})
+			}
+			for received := 0; ; received++ {
+				if leaving(i) && received == 5 {
+					ws.Close()
+					return
+				}
+				if _, _, err := ws.ReadMessage(); err != nil {
+					closeErrors[i] = err
+					return
+				}
+			}
+		}()
+	}
+	require.Eventually(t, func() bool { return hub.ClientCount() == clients }, 5*time.Second, 10*time.Millisecond)
+
+	broadcasters := &sync.WaitGroup{}
+	for b := 0; b < 4; b++ {
+		broadcasters.Add(1)
+		go func() {
+			defer broadcasters.Done()
+			for i := 0; i < 50; i++ {
+				hub.send(testEvent())
+				require.LessOrEqual(t, hub.ClientCount(), clients)
+			}
+		}()
+	}
+	broadcasters.Wait()
+	require.Eventually(t, func() bool { return hub.ClientCount() == clients-clients/5 }, 5*time.Second, 10*time.Millisecond)
+
+	stopHub()
+	select {
+	case <-hubStopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub did not stop")
+	}
+	wg.Wait()
+
+	require.Zero(t, hub.ClientCount())
+	require.False(t, hub.send(testEvent()))
+	for i, err := range closeErrors {
+		if leaving(i) {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr, "client %d", i)
+		require.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+		require.Equal(t, "server shutting down", closeErr.Text)
+	}
+
+	// Clients connecting after the shutdown are turned away
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer ws.Close()
+	_, _, err = ws.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "unexpected error: %v", err)
+}
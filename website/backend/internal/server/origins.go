@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Origins of the browsers allowed to open WebSockets, event streams and read the API.
+// Same origin requests and clients that send no Origin, like scripts, are always allowed.
+type originPolicy struct {
+	any     bool
+	allowed map[string]bool
+}
+
+// Reads the comma separated ALLOWED_ORIGINS, like "https://example.com,http://localhost:5173", or "*" to allow any
+func newOriginPolicy(logger *zap.SugaredLogger) originPolicy {
+	policy := originPolicy{allowed: map[string]bool{}}
+	value := os.Getenv("ALLOWED_ORIGINS")
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSpace(origin)
+		switch {
+		case origin == "":
+		case origin == "*":
+			policy.any = true
+		default:
+			parsed, err := url.Parse(origin)
+			if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Path != "" {
+				logger.Fatalf("Invalid origin %q in ALLOWED_ORIGINS, expected scheme://host[:port]", origin)
+			}
+			policy.allowed[strings.ToLower(origin)] = true
+		}
+	}
+	return policy
+}
+
+func (p originPolicy) check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.any || p.allowed[strings.ToLower(origin)] {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, r.Host)
+}
+
+// Rejects requests of other origins and adds the CORS headers that let the allowed ones read the responses
+func (p originPolicy) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !p.check(r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, X-API-Key, Last-Event-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
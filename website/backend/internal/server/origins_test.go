@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "https://Example.com, http://localhost:5173")
+	policy := newOriginPolicy(logger)
+
+	request := func(method, origin string) *http.Request {
+		r := httptest.NewRequest(method, "http://sentiment.test/api/v1/channels", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+	require.True(t, policy.check(request(http.MethodGet, "")))
+	require.True(t, policy.check(request(http.MethodGet, "http://sentiment.test")))
+	require.True(t, policy.check(request(http.MethodGet, "https://example.com")))
+	require.True(t, policy.check(request(http.MethodGet, "http://localhost:5173")))
+	require.False(t, policy.check(request(http.MethodGet, "https://evil.test")))
+	require.True(t, originPolicy{any: true}.check(request(http.MethodGet, "https://evil.test")))
+
+	handler := policy.cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	response := serve(request(http.MethodGet, "https://example.com"))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "https://example.com", response.Header().Get("Access-Control-Allow-Origin"))
+
+	response = serve(request(http.MethodGet, "https://evil.test"))
+	require.Equal(t, http.StatusForbidden, response.Code)
+
+	preflight := request(http.MethodOptions, "http://localhost:5173")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	response = serve(preflight)
+	require.Equal(t, http.StatusNoContent, response.Code)
+	require.Contains(t, response.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+
+	response = serve(request(http.MethodGet, ""))
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Empty(t, response.Header().Get("Access-Control-Allow-Origin"))
+}
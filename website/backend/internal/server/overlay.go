@@ -0,0 +1,118 @@
+package server
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"website/internal/service"
+
+	"go.uber.org/zap"
+)
+
+const defaultOverlayWindow = 5
+
+var (
+	//go:embed overlay.html
+	overlayPage     string
+	overlayTemplate = template.Must(template.New("overlay").Parse(overlayPage))
+)
+
+// Settings of the overlay page, from its URL parameters
+type overlayConfig struct {
+	Channel string
+	// dark or light text, the background is always transparent
+	Theme string
+	// Minutes of messages in the mood
+	Window int
+	// gauge, bar or text
+	Style string
+}
+
+// Registers the overlay page for browser sources and its JSON mood endpoint, which do not need an API key
+func registerOverlayRoutes(mux *http.ServeMux, resultsService *service.ResultsService, logger *zap.SugaredLogger) {
+	a := &api{resultsService: resultsService, logger: logger}
+	mux.HandleFunc("GET /overlay/{channel}", a.overlay)
+	mux.HandleFunc("GET /overlay/{channel}/mood", a.overlayMood)
+}
+
+func (a *api) overlay(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	config, err := parseOverlayConfig(channel, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := overlayTemplate.Execute(w, config); err != nil {
+		a.logger.Errorf("Failed to render overlay: %v", err)
+	}
+}
+
+func (a *api) overlayMood(w http.ResponseWriter, r *http.Request) {
+	channel, err := parseChannel(r)
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+	window, err := parseOverlayWindow(r.URL.Query())
+	if err != nil {
+		a.badRequest(w, err)
+		return
+	}
+
+	mood, err := a.resultsService.GetChannelMood(r.Context(), channel, window, time.Now())
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	a.writeJSON(w, http.StatusOK, mood)
+}
+
+func parseOverlayConfig(channel string, query url.Values) (overlayConfig, error) {
+	config := overlayConfig{Channel: channel, Theme: "dark", Style: "gauge"}
+	switch theme := query.Get("theme"); theme {
+	case "":
+	case "dark", "light":
+		config.Theme = theme
+	default:
+		return overlayConfig{}, fmt.Errorf("invalid theme %q, expected dark or light", theme)
+	}
+	switch style := query.Get("style"); style {
+	case "":
+	case "gauge", "bar", "text":
+		config.Style = style
+	default:
+		return overlayConfig{}, fmt.Errorf("invalid style %q, expected gauge, bar or text", style)
+	}
+
+	window, err := parseOverlayWindow(query)
+	if err != nil {
+		return overlayConfig{}, err
+	}
+	config.Window = window
+	return config, nil
+}
+
+// Parses the "window" parameter in minutes, up to the window of the event streams
+func parseOverlayWindow(query url.Values) (int, error) {
+	value := query.Get("window")
+	if value == "" {
+		return defaultOverlayWindow, nil
+	}
+	window, err := strconv.Atoi(value)
+	if err != nil || window < 1 || time.Duration(window)*time.Minute > maxWindow {
+		return 0, fmt.Errorf("invalid window %q, expected 1 to %d minutes", value, int(maxWindow.Minutes()))
+	}
+	return window, nil
+}
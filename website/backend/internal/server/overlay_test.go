@@ -0,0 +1,56 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOverlayConfig(t *testing.T) {
+	config, err := parseOverlayConfig("gaules", url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, overlayConfig{Channel: "gaules", Theme: "dark", Window: defaultOverlayWindow, Style: "gauge"}, config)
+
+	config, err = parseOverlayConfig("gaules", url.Values{"theme": {"light"}, "window": {"60"}, "style": {"bar"}})
+	require.NoError(t, err)
+	require.Equal(t, overlayConfig{Channel: "gaules", Theme: "light", Window: 60, Style: "bar"}, config)
+
+	for _, query := range []url.Values{
+		{"theme": {"blue"}},
+		{"style": {"pie"}},
+		{"window": {"0"}},
+		{"window": {"61"}},
+		{"window": {"five"}},
+	} {
+		_, err = parseOverlayConfig("gaules", query)
+		require.Error(t, err, query)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	mux := http.NewServeMux()
+	registerOverlayRoutes(mux, nil, logger)
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/overlay/gaules?theme=light&window=10&style=text", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	page, err := io.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(page), `<body class="light">`)
+	require.Contains(t, string(page), `channel: "gaules"`)
+	require.Contains(t, string(page), `window:  10 `)
+	require.Contains(t, string(page), `style: "text"`)
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/overlay/gaules?window=90", nil))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/overlay/not-a-channel!/mood", nil))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// What happens to a client whose queue is full
+type dropPolicy string
+
+const (
+	// Drops the oldest queued frame
+	dropOldest dropPolicy = "drop-oldest"
+	// Drops the queued frames of the stream and sends the client a new snapshot instead
+	coalesceSnapshot dropPolicy = "coalesce"
+	// Disconnects the client
+	disconnect dropPolicy = "disconnect"
+)
+
+type hubConfig struct {
+	queueSize    int
+	dropPolicy   dropPolicy
+	writeTimeout time.Duration
+	pingInterval time.Duration
+	// Clients that do not answer pings or send anything for longer are disconnected
+	idleTimeout time.Duration
+}
+
+func defaultHubConfig() hubConfig {
+	return hubConfig{
+		queueSize:    32,
+		dropPolicy:   coalesceSnapshot,
+		writeTimeout: 10 * time.Second,
+		pingInterval: 30 * time.Second,
+		idleTimeout:  60 * time.Second,
+	}
+}
+
+// Reads the WS_* environment variables, keeping the defaults of the missing ones
+func loadHubConfig(logger *zap.SugaredLogger) hubConfig {
+	config := defaultHubConfig()
+
+	if value, found := os.LookupEnv("WS_QUEUE_SIZE"); found {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 {
+			logger.Fatalf("Invalid WS_QUEUE_SIZE %q", value)
+		}
+		config.queueSize = size
+	}
+	if value, found := os.LookupEnv("WS_DROP_POLICY"); found {
+		switch policy := dropPolicy(value); policy {
+		case dropOldest, coalesceSnapshot, disconnect:
+			config.dropPolicy = policy
+		default:
+			logger.Fatalf("Invalid WS_DROP_POLICY %q, expected one of: %s, %s, %s", value, dropOldest, coalesceSnapshot, disconnect)
+		}
+	}
+	for name, duration := range map[string]*time.Duration{
+		"WS_WRITE_TIMEOUT": &config.writeTimeout,
+		"WS_PING_INTERVAL": &config.pingInterval,
+		"WS_IDLE_TIMEOUT":  &config.idleTimeout,
+	} {
+		if value, found := os.LookupEnv(name); found {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				logger.Fatalf("Invalid %s %q", name, value)
+			}
+			*duration = parsed
+		}
+	}
+	if config.pingInterval >= config.idleTimeout {
+		logger.Fatalf("WS_PING_INTERVAL must be shorter than WS_IDLE_TIMEOUT")
+	}
+
+	return config
+}
+
+// Encoded event waiting to be written to a client
+type frame struct {
+	// Stream of the event, empty for replies to commands
+	stream string
+	event  string
+	seq    uint64
+	data   []byte
+}
+
+func eventFrame(e hubEvent, data []byte) frame {
+	return frame{stream: e.stream(), event: e.event, seq: e.seq, data: data}
+}
+
+// Bounded queue of frames written to a client by its writer goroutine
+type clientQueue struct {
+	mu     *sync.Mutex
+	frames []frame
+	size   int
+	closed bool
+	// Close frame sent to the client, no frame is sent if the code is 0
+	closeCode int
+	closeText string
+
+	// Signaled when frames are added
+	ready chan struct{}
+	// Closed when the client is removed from the hub
+	done chan struct{}
+}
+
+func newClientQueue(size int) *clientQueue {
+	return &clientQueue{
+		mu:    &sync.Mutex{},
+		size:  size,
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+}
+
+// Adds the frame if there is room for it
+func (q *clientQueue) push(f frame) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || len(q.frames) >= q.size {
+		return false
+	}
+	q.frames = append(q.frames, f)
+	q.signal()
+	return true
+}
+
+// Adds the frame, dropping the oldest ones to make room. Returns how many were dropped.
+func (q *clientQueue) pushDroppingOldest(f frame) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0
+	}
+	dropped := 0
+	for len(q.frames) >= q.size {
+		q.frames = q.frames[1:]
+		dropped++
+	}
+	q.frames = append(q.frames, f)
+	q.signal()
+	return dropped
+}
+
+// Removes the queued frames of a stream. Returns how many were removed.
+func (q *clientQueue) dropStream(stream string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	kept := q.frames[:0]
+	for _, f := range q.frames {
+		if f.stream != stream {
+			kept = append(kept, f)
+		}
+	}
+	dropped := len(q.frames) - len(kept)
+	clear(q.frames[len(kept):])
+	q.frames = kept
+	return dropped
+}
+
+func (q *clientQueue) pop() (frame, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.frames) == 0 {
+		return frame{}, false
+	}
+	f := q.frames[0]
+	q.frames = q.frames[1:]
+	return f, true
+}
+
+// Discards the queued frames and stops the writer, which sends a close frame with the code if it is not 0
+func (q *clientQueue) close(code int, text string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		q.closeCode, q.closeText = code, text
+		q.frames = nil
+		close(q.done)
+	}
+}
+
+func (q *clientQueue) closing() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closed
+}
+
+func (q *clientQueue) closeReason() (int, string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closeCode, q.closeText
+}
+
+// Must be called with the lock held
+func (q *clientQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (c hubConfig) String() string {
+	return fmt.Sprintf("queue size: %d, drop policy: %s, write timeout: %s, ping interval: %s, idle timeout: %s",
+		c.queueSize, c.dropPolicy, c.writeTimeout, c.pingInterval, c.idleTimeout)
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientQueue(t *testing.T) {
+	q := newClientQueue(2)
+	require.True(t, q.push(frame{stream: "results", data: []byte("1")}))
+	require.True(t, q.push(frame{stream: "messages", data: []byte("2")}))
+	require.False(t, q.push(frame{stream: "results", data: []byte("3")}))
+
+	require.Equal(t, 1, q.pushDroppingOldest(frame{stream: "results", data: []byte("4")}))
+	require.Equal(t, 1, q.dropStream("results"))
+
+	f, ok := q.pop()
+	require.True(t, ok)
+	require.Equal(t, "2", string(f.data))
+	_, ok = q.pop()
+	require.False(t, ok)
+
+	q.close(websocket.CloseGoingAway, "bye")
+	require.True(t, q.closing())
+	require.False(t, q.push(frame{data: []byte("5")}))
+	require.Zero(t, q.pushDroppingOldest(frame{data: []byte("6")}))
+	select {
+	case <-q.done:
+	default:
+		t.Fatal("queue done channel was not closed")
+	}
+	q.close(0, "")
+	code, text := q.closeReason()
+	require.Equal(t, websocket.CloseGoingAway, code)
+	require.Equal(t, "bye", text)
+}
+
+type recordingSnapshots struct {
+	clients chan *Client
+	err     error
+}
+
+func (r *recordingSnapshots) sendSnapshot(ctx context.Context, client *Client) error {
+	r.clients <- client
+	return r.err
+}
+
+func TestHubDropPolicies(t *testing.T) {
+	full := func(policy dropPolicy) (*broadcastHub, *Client) {
+		hub := NewBroadcastHub(logger)
+		hub.config.queueSize = 2
+		hub.config.dropPolicy = policy
+		client := &Client{queue: newClientQueue(2), subscription: newSubscription()}
+		hub.clients[client] = true
+		hub.count.Add(1)
+		hub.trySend(client, frame{stream: "results", data: []byte("1")})
+		hub.trySend(client, frame{stream: "messages", data: []byte("2")})
+		return hub, client
+	}
+
+	hub, client := full(dropOldest)
+	hub.trySend(client, frame{stream: "results", data: []byte("3")})
+	f, _ := client.queue.pop()
+	require.Equal(t, "2", string(f.data))
+
+	hub, client = full(coalesceSnapshot)
+	snapshots := &recordingSnapshots{clients: make(chan *Client, 1)}
+	hub.snapshots = snapshots
+	hub.trySend(client, frame{stream: "results", data: []byte("3")})
+	hub.trySend(client, frame{stream: "results", data: []byte("4")})
+	require.Equal(t, client, <-snapshots.clients)
+	require.True(t, client.pendingSnapshot)
+	f, _ = client.queue.pop()
+	require.Equal(t, "2", string(f.data))
+	_, ok := client.queue.pop()
+	require.False(t, ok)
+	// Only one snapshot is requested until it is delivered
+	select {
+	case <-snapshots.clients:
+		t.Fatal("snapshot requested twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A failed snapshot stops dropping the deltas, so the client can find the gap and resync
+	hub, client = full(coalesceSnapshot)
+	snapshots = &recordingSnapshots{clients: make(chan *Client, 1), err: errors.New("database unavailable")}
+	hub.snapshots = snapshots
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
+	hub.mu.Lock()
+	hub.trySend(client, frame{stream: "results", data: []byte("3")})
+	hub.mu.Unlock()
+	require.Equal(t, client, <-snapshots.clients)
+	require.Eventually(t, func() bool {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		return !client.pendingSnapshot
+	}, time.Second, 10*time.Millisecond)
+	hub.mu.Lock()
+	hub.trySend(client, frame{stream: "messages", data: []byte("4")})
+	hub.mu.Unlock()
+	f, _ = client.queue.pop()
+	require.Equal(t, "2", string(f.data))
+	f, _ = client.queue.pop()
+	require.Equal(t, "4", string(f.data))
+}
+
+func TestHubEvictsSlowClients(t *testing.T) {
+	hub := NewBroadcastHub(logger)
+	hub.config.dropPolicy = disconnect
+
+	// Registers the client without a writer, so its queue is never drained
+	registered := make(chan *Client)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		require.NoError(t, err)
+		client := &Client{addr: conn.RemoteAddr().String(), conn: conn, queue: newClientQueue(1), subscription: newSubscription()}
+		require.True(t, hub.add(client))
+		registered <- client
+	}))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	defer ws.Close()
+	client := <-registered
+
+	hub.mu.Lock()
+	hub.trySend(client, frame{stream: "results", data: []byte("{}")})
+	hub.trySend(client, frame{stream: "results", data: []byte("{}")})
+	require.Empty(t, hub.clients)
+	hub.mu.Unlock()
+	go writeClient(hub.config, client, logger)
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = ws.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "unexpected error: %v", err)
+}
+
+func TestHubHeartbeat(t *testing.T) {
+	hub := NewBroadcastHub(logger)
+	hub.config.pingInterval = 50 * time.Millisecond
+	hub.config.idleTimeout = 200 * time.Millisecond
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
+
+	server := httptest.NewServer(wsHandler(hub, originPolicy{}, logger))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// Reading lets the default ping handler answer with pongs, which keeps the client alive
+	alive, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer alive.Close()
+	pings := 0
+	alive.SetPingHandler(func(data string) error {
+		pings++
+		return alive.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	alive.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, _, err = alive.ReadMessage()
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr) && netErr.Timeout(), "connection was closed: %v", err)
+	require.Greater(t, pings, 2)
+
+	// A client that never reads never answers pings and gets disconnected
+	idle, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer idle.Close()
+	time.Sleep(400 * time.Millisecond)
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = idle.ReadMessage()
+	require.Error(t, err)
+	require.False(t, errors.As(err, &netErr) && netErr.Timeout(), "connection was not closed")
+}
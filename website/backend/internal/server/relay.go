@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Representation of hub events published on the backplane
+type wireEvent struct {
+	Event    string                     `json:"event"`
+	Seq      uint64                     `json:"seq"`
+	Moment   time.Time                  `json:"moment"`
+	Channels map[string]json.RawMessage `json:"channels"`
+}
+
+func encodeHubEvent(e hubEvent) ([]byte, error) {
+	wire := wireEvent{
+		Event:    e.event,
+		Seq:      e.seq,
+		Moment:   e.moment,
+		Channels: make(map[string]json.RawMessage, len(e.channels)),
+	}
+	for channel, data := range e.channels {
+		bts, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		wire.Channels[channel] = bts
+	}
+	return json.Marshal(wire)
+}
+
+func decodeHubEvent(bts []byte) (hubEvent, error) {
+	var wire wireEvent
+	if err := json.Unmarshal(bts, &wire); err != nil {
+		return hubEvent{}, err
+	}
+	e := hubEvent{
+		event:    wire.Event,
+		seq:      wire.Seq,
+		moment:   wire.Moment,
+		channels: make(map[string]channelData, len(wire.Channels)),
+	}
+	for channel, raw := range wire.Channels {
+		var data channelData
+		var err error
+		switch e.stream() {
+		case "results":
+			var decoded averageResults
+			err = json.Unmarshal(raw, &decoded)
+			data = decoded
+		case "messages":
+			var decoded results
+			err = json.Unmarshal(raw, &decoded)
+			data = decoded
+		case "alert":
+			var decoded firedAlerts
+			err = json.Unmarshal(raw, &decoded)
+			data = decoded
+		case "trends":
+			var decoded channelTrends
+			err = json.Unmarshal(raw, &decoded)
+			data = decoded
+		default:
+			return hubEvent{}, fmt.Errorf("unknown event %q", e.event)
+		}
+		if err != nil {
+			return hubEvent{}, err
+		}
+		e.channels[channel] = data
+	}
+	return e, nil
+}
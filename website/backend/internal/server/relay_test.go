@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+	"time"
+	"website/internal/alerting"
+	"website/internal/trends"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubEventEncoding(t *testing.T) {
+	for _, event := range []hubEvent{
+		{
+			event:  "results_delta",
+			seq:    7,
+			moment: moment,
+			channels: map[string]channelData{
+				"channel0": averageResults{{Timestamp: moment, AveragePositiveSentiment: 0.5}},
+			},
+		},
+		{
+			event:  "messages",
+			seq:    3,
+			moment: moment,
+			channels: map[string]channelData{
+				"channel0": results{{MessageId: "msg-001", Timestamp: moment, Message: "hi"}},
+			},
+		},
+		{
+			event:  "alert",
+			seq:    1,
+			moment: moment,
+			channels: map[string]channelData{
+				"channel0": firedAlerts{{Rule: "negative", Channel: "channel0", Metric: alerting.MetricNegative, Timestamp: moment, Value: 0.6, Messages: 10}},
+			},
+		},
+		{
+			event:  "trends",
+			seq:    2,
+			moment: moment,
+			channels: map[string]channelData{
+				"channel0": channelTrends{
+					Channel: "channel0", From: moment.Add(-10 * time.Minute), To: moment, Messages: 4,
+					Unigrams: []trends.Term{{Term: "jogada", Messages: 3, AveragePositiveSentiment: 0.8}},
+					Bigrams:  []trends.Term{},
+					Rising:   []trends.Term{{Term: "jogada", Messages: 3, AveragePositiveSentiment: 0.8}},
+				},
+			},
+		},
+	} {
+		bts, err := encodeHubEvent(event)
+		require.NoError(t, err)
+
+		decoded, err := decodeHubEvent(bts)
+		require.NoError(t, err)
+		require.Equal(t, event.event, decoded.event)
+		require.Equal(t, event.seq, decoded.seq)
+		require.True(t, event.moment.Equal(decoded.moment))
+		require.Equal(t, event.channels, decoded.channels)
+	}
+
+	_, err := decodeHubEvent([]byte(`{"event":"unknown","channels":{"channel0":[]}}`))
+	require.Error(t, err)
+}
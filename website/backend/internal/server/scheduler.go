@@ -2,23 +2,80 @@ package server
 
 import (
 	"context"
-	"encoding/json"
+	"maps"
+	"sync"
 	"time"
+	"website/internal/alerting"
+	"website/internal/backplane"
+	"website/internal/database"
 	"website/internal/service"
 
 	"go.uber.org/zap"
 )
 
+const (
+	// How far behind the cursor new results are looked for, as transactions commit after the time they are stored at
+	deltaLookback = 10 * time.Second
+	deltaLimit    = 1000
+
+	// Times a snapshot is built while events are delivered during its queries
+	snapshotAttempts = 3
+
+	// Polling interval while insert notifications are being received
+	fallbackPollInterval = 10 * time.Second
+)
+
 type Event struct {
 	Event string `json:"event"`
-	Data  any    `json:"data"`
+	// Sequence number of the event stream, snapshots carry the sequence of the last delta they include
+	Seq  uint64 `json:"seq,omitempty"`
+	Data any    `json:"data"`
+}
+
+// Identifies a message of a channel, whatever the timestamp of its copies
+type messageKey struct {
+	channel   string
+	messageId string
 }
 
 type scheduler struct {
 	logger         *zap.SugaredLogger
 	hub            *broadcastHub
 	ticker         *time.Ticker
+	pollInterval   time.Duration
 	resultsService *service.ResultsService
+
+	// Insert notifications, nil when not listening
+	notifications <-chan []string
+	listening     <-chan bool
+
+	// Shares events with other replicas, only the leader queries and publishes deltas
+	backplane backplane.Backplane
+	leader    bool
+
+	// Alert rules evaluated by the leader, nil when not used
+	alertEngine *alerting.Engine
+	// Keeps the fired alerts, nil when they are not kept
+	alertStore *alerting.Store
+
+	// Trends broadcast by the leader, and the minute they were last sent
+	trends       trendsConfig
+	trendsMinute time.Time
+
+	// Guards seq and orders snapshots with the events delivered to the hub
+	mu *sync.Mutex
+	// Sequence of the last event of each stream delivered to the hub
+	seq map[string]uint64
+
+	// State of the deltas, only used by the scheduler goroutine
+	// Sequence of the last event of each stream published
+	published map[string]uint64
+	// Hour being sent to clients
+	hour time.Time
+	// Time the newest result sent was stored at, which unlike the message timestamp follows the ingest lag
+	cursor time.Time
+	// Messages sent inside the lookback window and when they were stored
+	seen map[messageKey]time.Time
 }
 
 func NewScheduler(
@@ -31,18 +88,67 @@ func NewScheduler(
 		logger:         logger,
 		hub:            hub,
 		ticker:         time.NewTicker(duration),
+		pollInterval:   duration,
 		resultsService: resultsService,
+		backplane:      backplane.NewLocalBackplane(),
+		mu:             &sync.Mutex{},
+		seq:            map[string]uint64{},
+		published:      map[string]uint64{},
+		seen:           map[messageKey]time.Time{},
 	}
 }
 
+// Publishes events through a backplane shared by every replica instead of only to the local hub
+func (s *scheduler) UseBackplane(backplane backplane.Backplane) {
+	s.backplane = backplane
+}
+
+// Sends deltas as soon as results are inserted, polling less often while notifications arrive
+func (s *scheduler) UseListener(listener *database.Listener) {
+	s.notifications = listener.Batches()
+	s.listening = listener.Listening()
+}
+
+// Broadcasts the trending terms of every channel each minute as "trends" events
+func (s *scheduler) UseTrends(config trendsConfig) {
+	s.trends = config
+}
+
+// Evaluates the alert rules every minute, saving the alerts that fire and broadcasting them as "alert" events
+func (s *scheduler) UseAlerts(engine *alerting.Engine, store *alerting.Store) {
+	s.alertEngine = engine
+	s.alertStore = store
+}
+
 func (s *scheduler) Start(ctx context.Context) {
 	s.logger.Info("Starting scheduler")
 
+	go s.relay(ctx)
+
 	for {
 		select {
+		case leader := <-s.backplane.Leadership():
+			s.logger.Infof("Leadership changed, leader: %v", leader)
+			s.leader = leader
+			// A new leader starts tracking deltas from the current state
+			s.hour = time.Time{}
+			if leader {
+				s.restoreAlerts(ctx)
+			}
 		case <-s.ticker.C:
-			s.sendLastResultsEvent(ctx)
-			s.sendLastMessagesEvent(ctx)
+			s.sendDeltaEvents(ctx)
+			s.evaluateAlerts(ctx, time.Now())
+			s.sendTrends(ctx, time.Now())
+		case channels := <-s.notifications:
+			s.logger.Debugf("Results inserted for channels: %v", channels)
+			s.sendDeltaEvents(ctx)
+		case listening := <-s.listening:
+			if listening {
+				s.ticker.Reset(fallbackPollInterval)
+			} else {
+				s.logger.Warnf("Not receiving insert notifications, polling every %s", s.pollInterval)
+				s.ticker.Reset(s.pollInterval)
+			}
 		case <-ctx.Done():
 			s.logger.Info("Stoping scheduler")
 			s.ticker.Stop()
@@ -51,46 +157,251 @@ func (s *scheduler) Start(ctx context.Context) {
 	}
 }
 
-func (s *scheduler) sendLastResultsEvent(ctx context.Context) {
-	if len(s.hub.clients) == 0 {
-		// logger.Debug("There are no clients to send messages")
+// Delivers the events published by any replica to the local hub
+func (s *scheduler) relay(ctx context.Context) {
+	for {
+		select {
+		case message := <-s.backplane.Messages():
+			event, err := decodeHubEvent(message)
+			if err != nil {
+				s.logger.Errorf("Failed to decode event from backplane: %v", err)
+				continue
+			}
+			s.mu.Lock()
+			s.seq[event.stream()] = max(s.seq[event.stream()], event.seq)
+			s.hub.send(event)
+			s.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Assigns the next sequence of the event stream and publishes it to every replica
+func (s *scheduler) publish(ctx context.Context, event hubEvent) {
+	s.mu.Lock()
+	delivered := s.seq[event.stream()]
+	s.mu.Unlock()
+
+	// Continues the sequence of the previous leader
+	s.published[event.stream()] = max(s.published[event.stream()], delivered) + 1
+	event.seq = s.published[event.stream()]
+
+	message, err := encodeHubEvent(event)
+	if err != nil {
+		s.logger.Errorf("Failed to encode %s event: %v", event.event, err)
+		return
+	}
+	if err := s.backplane.Publish(ctx, message); err != nil {
+		s.logger.Errorf("Failed to publish %s event: %v", event.event, err)
+	}
+}
+
+// Sends the current results and messages to a single client
+func (s *scheduler) sendSnapshot(ctx context.Context, client *Client) error {
+	for attempt := 1; ; attempt++ {
+		s.mu.Lock()
+		seq := maps.Clone(s.seq)
+		s.mu.Unlock()
+
+		// Queried without the lock, so events keep being delivered to the hub meanwhile
+		events, err := s.snapshotEvents(ctx, time.Now(), seq)
+		if err != nil {
+			s.logger.Errorf("Failed to build snapshot: %v", err)
+			return err
+		}
+
+		s.mu.Lock()
+		// The snapshot may miss events delivered during the queries, so it is built again.
+		// Past the attempts it is sent anyway, the client finds the gap by the sequence and resyncs.
+		if !maps.Equal(seq, s.seq) && attempt < snapshotAttempts {
+			s.mu.Unlock()
+			continue
+		}
+		s.hub.sendTo(client, events)
+		s.mu.Unlock()
+		return nil
+	}
+}
+
+// Builds the snapshot with the sequence of the last event of each stream it includes
+func (s *scheduler) snapshotEvents(ctx context.Context, now time.Time, seq map[string]uint64) ([]hubEvent, error) {
+	channelResults, err := s.resultsService.GetLastHourChannelAverageResults(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.resultsService.GetLastResults(ctx, 100, now)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsEvent := hubEvent{
+		event:    "results",
+		seq:      seq["results"],
+		moment:   now,
+		channels: make(map[string]channelData, len(channelResults)),
+	}
+	for channel, channelResult := range channelResults {
+		resultsEvent.channels[channel] = averageResults(channelResult)
+	}
+	messagesEvent := hubEvent{
+		event:    "messages",
+		seq:      seq["messages"],
+		moment:   now,
+		channels: make(map[string]channelData, len(messages)),
+	}
+	for channel, channelMessages := range messages {
+		messagesEvent.channels[channel] = results(channelMessages)
+	}
+
+	return []hubEvent{resultsEvent, messagesEvent}, nil
+}
+
+// Broadcasts the messages received since the last tick and the buckets they changed.
+// A new hour is broadcasted as a snapshot, so clients drop the previous one.
+func (s *scheduler) sendDeltaEvents(ctx context.Context) {
+	if !s.leader {
 		return
 	}
-	channelResults, err := s.resultsService.GetLastHourChannelAverageResults(ctx, time.Now())
+
+	now := time.Now()
+	hour, end := service.StartAndEndOfHour(now)
+
+	// Clients of other replicas are unknown, so a distributed leader always publishes
+	if !s.backplane.Distributed() && s.hub.ClientCount() == 0 {
+		// Clients receive a snapshot on connect, the state is rebuilt on the next tick with clients
+		s.hour = time.Time{}
+		return
+	}
+
+	if s.hour.IsZero() {
+		// Messages inside the lookback window may repeat the ones clients got in their snapshot
+		s.hour, s.cursor = hour, now
+		clear(s.seen)
+		return
+	}
+
+	if !s.hour.Equal(hour) {
+		// The sequence is assigned when publishing
+		events, err := s.snapshotEvents(ctx, now, nil)
+		if err != nil {
+			s.logger.Errorf("Failed to build snapshot: %v", err)
+			return
+		}
+		s.hour, s.cursor = hour, now
+		clear(s.seen)
+		for _, event := range events {
+			s.publish(ctx, event)
+		}
+		return
+	}
+
+	newMessages, err := s.resultsService.GetResultsSince(ctx, s.cursor.Add(-deltaLookback), deltaLimit)
 	if err != nil {
-		s.logger.Errorf("Failed to get last hour results: %v", err)
+		s.logger.Errorf("Failed to get new messages: %v", err)
 		return
 	}
-	resultsEvent := Event{
-		Event: "results",
-		Data:  channelResults,
+
+	messagesDelta := map[string]channelData{}
+	oldest := map[string]time.Time{}
+	for _, ingested := range newMessages {
+		if ingested.IngestedAt.After(s.cursor) {
+			s.cursor = ingested.IngestedAt
+		}
+		message, key := ingested.Result, messageKey{ingested.Channel, ingested.MessageId}
+		if _, found := s.seen[key]; found || message.Timestamp.Before(hour) {
+			continue
+		}
+		s.seen[key] = ingested.IngestedAt
+
+		// Messages are sent newest first, like in snapshots
+		channelMessages, _ := messagesDelta[message.Channel].(results)
+		messagesDelta[message.Channel] = append(results{message}, channelMessages...)
+		if t, found := oldest[message.Channel]; !found || message.Timestamp.Before(t) {
+			oldest[message.Channel] = message.Timestamp
+		}
+	}
+	for key, ingestedAt := range s.seen {
+		if ingestedAt.Before(s.cursor.Add(-deltaLookback)) {
+			delete(s.seen, key)
+		}
+	}
+	if len(messagesDelta) == 0 {
+		return
+	}
+
+	channels := make([]string, 0, len(oldest))
+	from := end
+	for channel, t := range oldest {
+		channels = append(channels, channel)
+		if t.Before(from) {
+			from = t
+		}
 	}
-	bts, err := json.Marshal(resultsEvent)
+	channelResults, err := s.resultsService.GetChannelsAverageResults(ctx, channels, from.Truncate(time.Minute), hour.Add(time.Hour))
 	if err != nil {
-		s.logger.Errorf("Failed to encode results to json: %v", err)
+		s.logger.Errorf("Failed to get updated results: %v", err)
 		return
 	}
-	s.hub.broadcast <- bts
+	resultsDelta := make(map[string]channelData, len(channelResults))
+	for channel, channelResult := range channelResults {
+		// Only the buckets changed by the new messages of the channel
+		resultsDelta[channel] = averageResults(channelResult).since(oldest[channel].Truncate(time.Minute))
+	}
+
+	s.publish(ctx, hubEvent{event: "results_delta", moment: now, channels: resultsDelta})
+	s.publish(ctx, hubEvent{event: "messages_delta", moment: now, channels: messagesDelta})
 }
 
-func (s *scheduler) sendLastMessagesEvent(ctx context.Context) {
-	if len(s.hub.clients) == 0 {
-		// logger.Debug("There are no clients to send messages")
+// Evaluates the alert rules for the last complete minute, once per minute and only on the leader.
+// Alerts that fire are delivered to the webhooks and published to every replica.
+func (s *scheduler) evaluateAlerts(ctx context.Context, now time.Time) {
+	if !s.leader || s.alertEngine == nil {
+		return
+	}
+	minute, from, due := s.alertEngine.Due(now)
+	if !due {
 		return
 	}
-	messages, err := s.resultsService.GetLastResults(ctx, 100, time.Now())
+
+	averages, err := s.resultsService.GetChannelsAverageResults(ctx, nil, from, minute.Add(time.Minute))
 	if err != nil {
-		s.logger.Errorf("Failed to get last messages: %v", err)
+		s.logger.Errorf("Failed to get results for alerts: %v", err)
+		return
+	}
+	alerts := s.alertEngine.Evaluate(minute, averages)
+	if s.alertStore != nil {
+		if err := s.alertStore.SaveState(ctx, s.alertEngine.State()); err != nil {
+			s.logger.Errorf("Failed to save alert state: %v", err)
+		}
+	}
+	if len(alerts) == 0 {
 		return
 	}
-	messagesEvent := Event{
-		Event: "messages",
-		Data:  messages,
+	if s.alertStore != nil {
+		if err := s.alertStore.Save(ctx, alerts); err != nil {
+			s.logger.Errorf("Failed to save alerts: %v", err)
+		}
+	}
+	s.alertEngine.Deliver(alerts)
+
+	channels := map[string]channelData{}
+	for _, alert := range alerts {
+		channelAlerts, _ := channels[alert.Channel].(firedAlerts)
+		channels[alert.Channel] = append(channelAlerts, alert)
+	}
+	s.publish(ctx, hubEvent{event: "alert", moment: now, channels: channels})
+}
+
+// Loads the state of the alert rules saved by the previous leader, so active alerts do not fire again
+func (s *scheduler) restoreAlerts(ctx context.Context) {
+	if s.alertEngine == nil || s.alertStore == nil {
+		return
 	}
-	bts, err := json.Marshal(messagesEvent)
+	states, err := s.alertStore.LoadState(ctx)
 	if err != nil {
-		s.logger.Errorf("Failed to encode last messages to json: %v", err)
+		s.logger.Errorf("Failed to load alert state: %v", err)
 		return
 	}
-	s.hub.broadcast <- bts
+	s.alertEngine.Restore(states)
 }
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/service"
+	"website/internal/testutils"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+type testEventPayload[T any] struct {
+	Event string         `json:"event"`
+	Seq   uint64         `json:"seq"`
+	Data  map[string][]T `json:"data"`
+}
+
+func TestSchedulerDeltas(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	t.Setenv("DATABASE_DSN", postgresContainer.MustConnectionString(ctx))
+	conn := database.NewDatabaseConnection(logger)
+
+	insert := func(messageId string, timestamp time.Time) {
+		_, err := conn.Exec(ctx, `INSERT INTO results
+			(channel, "user", "message_id", "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative)
+			VALUES ('channel0', 'user0', $1, $2, 'sample message', 0.8, 0.1, 0.1);`, messageId, timestamp)
+		require.NoError(t, err)
+	}
+	insert("msg-snapshot", time.Now())
+
+	hub := NewBroadcastHub(logger)
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
+
+	scheduler := NewScheduler(logger, hub, 50*time.Millisecond, service.NewResultsService(conn, logger))
+	hub.snapshots = scheduler
+	schedulerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go scheduler.Start(schedulerCtx)
+
+	server := httptest.NewServer(wsHandler(hub, originPolicy{}, logger))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	var resultsSnapshot testEventPayload[service.AverageResult]
+	require.NoError(t, ws.ReadJSON(&resultsSnapshot))
+	require.Equal(t, "results", resultsSnapshot.Event)
+	require.Len(t, resultsSnapshot.Data["channel0"], 1)
+
+	var messagesSnapshot testEventPayload[service.Result]
+	require.NoError(t, ws.ReadJSON(&messagesSnapshot))
+	require.Equal(t, "messages", messagesSnapshot.Event)
+	require.Len(t, messagesSnapshot.Data["channel0"], 1)
+
+	// Let the scheduler start tracking deltas
+	time.Sleep(200 * time.Millisecond)
+	insert("msg-delta", time.Now())
+	// Stored long after it was sent, like a message delayed by the pipeline
+	hour, _ := service.StartAndEndOfHour(time.Now())
+	insert("msg-late", hour)
+
+	// The messages may be split across deltas
+	deadline := time.Now().Add(5 * time.Second)
+	seq := messagesSnapshot.Seq
+	ids := []string{}
+	for !slices.Contains(ids, "msg-delta") || !slices.Contains(ids, "msg-late") {
+		require.True(t, time.Now().Before(deadline), "did not receive the deltas")
+		require.NoError(t, ws.SetReadDeadline(deadline))
+
+		var delta testEventPayload[service.Result]
+		require.NoError(t, ws.ReadJSON(&delta))
+		if delta.Event != "messages_delta" {
+			continue
+		}
+		require.Equal(t, seq+1, delta.Seq)
+		seq = delta.Seq
+		for _, message := range delta.Data["channel0"] {
+			ids = append(ids, message.MessageId)
+		}
+	}
+
+	// Resyncing sends both snapshots again
+	require.NoError(t, ws.WriteJSON(command{Action: "resync"}))
+	for _, expected := range []string{"results", "messages"} {
+		var snapshot testEventPayload[any]
+		for snapshot.Event != expected {
+			require.NoError(t, ws.ReadJSON(&snapshot))
+		}
+	}
+}
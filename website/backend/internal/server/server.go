@@ -3,27 +3,86 @@ package server
 import (
 	"context"
 	"net/http"
+	"os"
 	"time"
+	"website/internal/alerting"
+	"website/internal/auth"
+	"website/internal/backplane"
+	"website/internal/database"
+	"website/internal/exporter"
+	"website/internal/privacy"
 	"website/internal/service"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
-func Start(ctx context.Context, logger *zap.SugaredLogger, resultsService *service.ResultsService) {
+func Start(ctx context.Context, logger *zap.SugaredLogger, conn *pgxpool.Pool, resultsService *service.ResultsService) {
 	hub := NewBroadcastHub(logger.Named("broadcast-hub"))
-	go hub.Start()
-	defer hub.Stop()
+	hubStopped := make(chan struct{})
+	go func() {
+		hub.Run(ctx)
+		close(hubStopped)
+	}()
 
 	scheduler := NewScheduler(logger.Named("scheduler"), hub, 1*time.Second, resultsService)
+	hub.snapshots = scheduler
+
+	backplane := backplane.NewBackplane(conn, logger.Named("backplane"))
+	scheduler.UseBackplane(backplane)
+	go backplane.Start(ctx)
+
+	alertStore := alerting.NewStore(conn)
+	alertEngine := alerting.NewEngine(logger.Named("alerting"))
+	if alertEngine.Enabled() {
+		scheduler.UseAlerts(alertEngine, alertStore)
+		go alertEngine.Start(ctx)
+	}
+
+	exporter := exporter.NewExporter(resultsService, logger.Named("exporter"))
+	if exporter.Enabled() {
+		go exporter.Start(ctx)
+	}
+
+	scheduler.UseTrends(newTrendsConfig(logger))
+
+	listener := database.NewListener(conn, database.ResultsInsertedChannel, 100*time.Millisecond, logger.Named("listener"))
+	scheduler.UseListener(listener)
+	go listener.Start(ctx)
 	go scheduler.Start(ctx)
 
+	keys := auth.NewStore(conn)
+	var authenticator *auth.Authenticator
+	switch value := os.Getenv("API_AUTH"); value {
+	case "", "required":
+		authenticator = auth.NewAuthenticator(keys, logger.Named("auth"))
+	case "disabled":
+		// Only the read routes can be opened, the admin routes are not served at all
+		logger.Warn("API authentication is disabled, the read routes are public and the admin routes are not served")
+	default:
+		logger.Fatalf("Invalid API_AUTH %q, expected required or disabled", value)
+	}
+	origins := newOriginPolicy(logger)
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", wsHandler(hub, logger.Named("ws-handler")))
+	mux.HandleFunc("/ws", wsHandler(hub, origins, logger.Named("ws-handler")))
+	mux.HandleFunc("GET /events", sseHandler(hub, logger.Named("sse-handler")))
+	registerApiRoutes(mux, resultsService, keys, privacy.NewEraser(conn, logger.Named("privacy")), authenticator, logger.Named("api"))
+	registerGrafanaRoutes(mux, resultsService, alertStore, authenticator, logger.Named("grafana"))
+	registerOverlayRoutes(mux, resultsService, logger.Named("overlay"))
 	mux.Handle("/", http.FileServer(http.Dir("./public")))
-	mux.Handle("/metrics", promhttp.Handler())
 
-	server := &http.Server{Addr: ":8080", Handler: mux}
+	server := &http.Server{Addr: ":8080", Handler: origins.cors(mux)}
+
+	// Metrics are served on their own listener, which is not published
+	metricsAddr := ":9091"
+	if value, found := os.LookupEnv("METRICS_ADDR"); found {
+		metricsAddr = value
+	}
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{Addr: metricsAddr, Handler: metricsMux}
 
 	go func() {
 		logger.Info("WebSocket server started on :8080")
@@ -31,6 +90,12 @@ func Start(ctx context.Context, logger *zap.SugaredLogger, resultsService *servi
 			logger.Fatalf("Server failed: %v", err)
 		}
 	}()
+	go func() {
+		logger.Infof("Metrics server started on %s", metricsAddr)
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Metrics server failed: %v", err)
+		}
+	}()
 
 	<-ctx.Done()
 
@@ -41,4 +106,12 @@ func Start(ctx context.Context, logger *zap.SugaredLogger, resultsService *servi
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		logger.Fatalf("Server forced to shutdown: %v", err)
 	}
+	metricsServer.Shutdown(shutdownCtx)
+
+	// WebSocket connections are hijacked, so the server does not wait for them
+	select {
+	case <-hubStopped:
+	case <-shutdownCtx.Done():
+		logger.Warn("WebSocket clients did not close in time")
+	}
 }
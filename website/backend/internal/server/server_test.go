@@ -43,7 +43,7 @@ func TestServer(t *testing.T) {
 	serverCtx, cancel := context.WithCancel(ctx)
 	serverSignal := make(chan bool)
 	go func() {
-		Start(serverCtx, logger, service)
+		Start(serverCtx, logger, conn, service)
 		serverSignal <- true
 	}()
 
@@ -85,7 +85,7 @@ func TestServerEvents(t *testing.T) {
 	serverCtx, cancel := context.WithCancel(ctx)
 	serverSignal := make(chan bool)
 	go func() {
-		Start(serverCtx, logger, service)
+		Start(serverCtx, logger, conn, service)
 		serverSignal <- true
 	}()
 
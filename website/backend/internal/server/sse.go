@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Retry delay suggested to EventSource clients after the stream ends
+const sseRetry = 3 * time.Second
+
+// Streams the same events as the WebSocket to clients that cannot upgrade the connection
+func sseHandler(hub *broadcastHub, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, err := parseSubscription(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var position map[string]uint64
+		if lastEventId := r.Header.Get("Last-Event-ID"); lastEventId != "" {
+			if position, err = parsePosition(lastEventId); err != nil {
+				http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+		}
+
+		client := &Client{addr: r.RemoteAddr, queue: newClientQueue(hub.config.queueSize), subscription: sub}
+		added, resumed := hub.addFrom(client, position)
+		if !added {
+			http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() {
+			hub.remove(client)
+			hub.handlers.Done()
+		}()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		// Disables response buffering in nginx
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		controller := http.NewResponseController(w)
+		write := func(text string) error {
+			err := controller.SetWriteDeadline(time.Now().Add(hub.config.writeTimeout))
+			if err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+			if _, err := fmt.Fprint(w, text); err != nil {
+				return err
+			}
+			return controller.Flush()
+		}
+		if err := write(fmt.Sprintf("retry: %d\n\n", sseRetry.Milliseconds())); err != nil {
+			return
+		}
+
+		if !resumed {
+			position = map[string]uint64{}
+			if hub.snapshots != nil {
+				hub.snapshots.sendSnapshot(r.Context(), client)
+			}
+		}
+
+		ticker := time.NewTicker(hub.config.pingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-client.queue.ready:
+				for {
+					f, ok := client.queue.pop()
+					if !ok {
+						break
+					}
+					// Command replies are never queued for these clients, every frame belongs to a stream
+					position[f.stream] = f.seq
+					message := fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", formatPosition(position), f.event, f.data)
+					if err := write(message); err != nil {
+						logger.Debugf("failed to send event to client %s: %v", client, err)
+						return
+					}
+				}
+			case <-ticker.C:
+				if err := write(": heartbeat\n\n"); err != nil {
+					logger.Debugf("failed to send heartbeat to client %s: %v", client, err)
+					return
+				}
+			case <-client.queue.done:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// Builds the subscription from the comma separated "channels" and "events" and the "window" query parameters
+func parseSubscription(query url.Values) (*subscription, error) {
+	cmd := command{Action: "subscribe"}
+	if channels := query.Get("channels"); channels != "" {
+		cmd.Channels = strings.Split(channels, ",")
+	}
+	if events := query.Get("events"); events != "" {
+		cmd.Events = strings.Split(events, ",")
+	}
+	if window := query.Get("window"); window != "" {
+		minutes, err := strconv.Atoi(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q", window)
+		}
+		cmd.Window = minutes
+	}
+
+	sub := newSubscription()
+	if err := sub.apply(cmd); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Event ID holding the last sequence of every stream, like "messages:12,results:7"
+func formatPosition(position map[string]uint64) string {
+	streams := make([]string, 0, len(position))
+	for stream := range position {
+		streams = append(streams, stream)
+	}
+	slices.Sort(streams)
+
+	parts := make([]string, len(streams))
+	for i, stream := range streams {
+		parts[i] = fmt.Sprintf("%s:%d", stream, position[stream])
+	}
+	return strings.Join(parts, ",")
+}
+
+func parsePosition(id string) (map[string]uint64, error) {
+	position := map[string]uint64{}
+	for _, part := range strings.Split(id, ",") {
+		stream, value, found := strings.Cut(part, ":")
+		if !found || !slices.Contains(events, stream) {
+			return nil, fmt.Errorf("invalid stream position %q", part)
+		}
+		seq, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stream position %q", part)
+		}
+		position[stream] = seq
+	}
+	return position, nil
+}
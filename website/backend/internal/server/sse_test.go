@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"website/internal/service"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPosition(t *testing.T) {
+	position, err := parsePosition("results:7,messages:12")
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint64{"results": 7, "messages": 12}, position)
+	require.Equal(t, "messages:12,results:7", formatPosition(position))
+
+	for _, id := range []string{"results", "unknown:1", "results:-1", "results:1,"} {
+		_, err := parsePosition(id)
+		require.Error(t, err, id)
+	}
+}
+
+func TestParseSubscription(t *testing.T) {
+	sub, err := parseSubscription(map[string][]string{"channels": {"Channel1,channel2"}, "events": {"results"}, "window": {"15"}})
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"channels": []string{"channel1", "channel2"},
+		"events":   []string{"results"},
+		"window":   15,
+	}, sub.state())
+
+	sub, err = parseSubscription(nil)
+	require.NoError(t, err)
+	require.Equal(t, newSubscription(), sub)
+
+	for _, query := range []map[string][]string{
+		{"channels": {"bad channel"}},
+		{"events": {"unknown"}},
+		{"window": {"abc"}},
+		{"window": {"120"}},
+	} {
+		_, err := parseSubscription(query)
+		require.Error(t, err, query)
+	}
+}
+
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+type sseStream struct {
+	scanner *bufio.Scanner
+	// Heartbeats received before the last event
+	heartbeats int
+}
+
+// Reads lines until the next event, counting the heartbeat comments in between
+func (s *sseStream) next(t *testing.T) sseEvent {
+	var event sseEvent
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		switch {
+		case line == "" && event.event != "":
+			return event
+		case line == ": heartbeat":
+			s.heartbeats++
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	t.Fatalf("stream ended: %v", s.scanner.Err())
+	return event
+}
+
+func connectSSE(t *testing.T, url string, lastEventId string) (*sseStream, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	return &sseStream{scanner: bufio.NewScanner(resp.Body)}, func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+func TestSSE(t *testing.T) {
+	hub := NewBroadcastHub(logger)
+	hub.config.pingInterval = 20 * time.Millisecond
+	snapshots := &recordingSnapshots{clients: make(chan *Client, 10)}
+	hub.snapshots = snapshots
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
+
+	server := httptest.NewServer(sseHandler(hub, logger))
+	defer server.Close()
+	url := server.URL + "?channels=channel1&events=results"
+
+	delta := func(seq uint64) hubEvent {
+		event := testEvent()
+		event.event, event.seq = "results_delta", seq
+		return event
+	}
+
+	stream, disconnect := connectSSE(t, url, "")
+	<-snapshots.clients
+	require.Eventually(t, func() bool { return hub.ClientCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	hub.send(delta(1))
+	event := stream.next(t)
+	require.Equal(t, "results:1", event.id)
+	require.Equal(t, "results_delta", event.event)
+	var payload testEventPayload[service.AverageResult]
+	require.NoError(t, json.Unmarshal([]byte(event.data), &payload))
+	require.EqualValues(t, 1, payload.Seq)
+	require.Len(t, payload.Data, 1)
+	require.Len(t, payload.Data["channel1"], 1)
+
+	// Messages are not subscribed
+	messages := testEvent()
+	messages.event, messages.seq = "messages_delta", 1
+	hub.send(messages)
+	time.Sleep(50 * time.Millisecond)
+	hub.send(delta(2))
+	event = stream.next(t)
+	require.Equal(t, "results:2", event.id)
+	require.Greater(t, stream.heartbeats, 0)
+	disconnect()
+
+	require.Eventually(t, func() bool { return hub.ClientCount() == 0 }, time.Second, 10*time.Millisecond)
+	hub.send(delta(3))
+	hub.send(delta(4))
+
+	// Resuming replays the missed events without a snapshot
+	stream, disconnect = connectSSE(t, url, "results:2")
+	require.Equal(t, "results:3", stream.next(t).id)
+	require.Equal(t, "results:4", stream.next(t).id)
+	disconnect()
+	require.Empty(t, snapshots.clients)
+
+	// Positions the history does not cover get a snapshot instead
+	stream, disconnect = connectSSE(t, url, "results:100")
+	defer disconnect()
+	<-snapshots.clients
+	hub.send(delta(5))
+	require.Equal(t, "results:5", stream.next(t).id)
+
+	resp, err := http.Get(server.URL + "?events=unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"website/internal/alerting"
+	"website/internal/service"
+	"website/internal/trends"
+)
+
+const maxWindow = 60 * time.Minute
+
+var events = []string{"results", "messages", "alert", "trends"}
+
+// Payload of a single channel inside an event
+type channelData interface {
+	// Returns only the entries at or after t
+	since(t time.Time) channelData
+}
+
+type averageResults []service.AverageResult
+
+func (r averageResults) since(t time.Time) channelData {
+	filtered := averageResults{}
+	for _, result := range r {
+		if !result.Timestamp.Before(t) {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+type results []service.Result
+
+func (r results) since(t time.Time) channelData {
+	filtered := results{}
+	for _, result := range r {
+		if !result.Timestamp.Before(t) {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+type firedAlerts []alerting.Alert
+
+func (a firedAlerts) since(t time.Time) channelData {
+	filtered := firedAlerts{}
+	for _, alert := range a {
+		if !alert.Timestamp.Before(t) {
+			filtered = append(filtered, alert)
+		}
+	}
+	return filtered
+}
+
+// Trends of the window that ends at the event, kept whole by any subscription window
+type channelTrends trends.Trends
+
+func (t channelTrends) since(time.Time) channelData {
+	return t
+}
+
+// Event with its data keyed by channel, filtered for each client before being sent
+type hubEvent struct {
+	event    string
+	seq      uint64
+	moment   time.Time
+	channels map[string]channelData
+}
+
+// Whether dropped events of the stream can be replaced by a snapshot, alerts and trends have none
+func hasSnapshot(stream string) bool {
+	return stream == "results" || stream == "messages"
+}
+
+// Stream of an event, deltas are part of the same stream as their snapshots
+func (e hubEvent) stream() string {
+	return strings.TrimSuffix(e.event, "_delta")
+}
+
+// Command sent by clients over the socket to change what they receive
+type command struct {
+	Action   string   `json:"action"`
+	Channels []string `json:"channels"`
+	Events   []string `json:"events"`
+	// Minutes of data before the event moment to receive
+	Window int `json:"window"`
+}
+
+type subscription struct {
+	allChannels bool
+	channels    map[string]bool
+	allEvents   bool
+	events      map[string]bool
+	window      time.Duration
+}
+
+// Clients start subscribed to every channel and event
+func newSubscription() *subscription {
+	return &subscription{
+		allChannels: true,
+		channels:    map[string]bool{},
+		allEvents:   true,
+		events:      map[string]bool{},
+	}
+}
+
+// Subscribing to channels or events replaces the default "all" with the given ones.
+// Unsubscribing without channels or events removes everything.
+func (s *subscription) apply(cmd command) error {
+	channels := make([]string, 0, len(cmd.Channels))
+	for _, channel := range cmd.Channels {
+		if !channelPattern.MatchString(channel) {
+			return fmt.Errorf("invalid channel %q", channel)
+		}
+		channels = append(channels, strings.ToLower(channel))
+	}
+	for _, event := range cmd.Events {
+		if !slices.Contains(events, event) {
+			return fmt.Errorf("invalid event %q, expected one of: %s", event, strings.Join(events, ", "))
+		}
+	}
+	if cmd.Window < 0 || time.Duration(cmd.Window)*time.Minute > maxWindow {
+		return fmt.Errorf("invalid window %d, expected up to %d minutes", cmd.Window, int(maxWindow.Minutes()))
+	}
+
+	switch cmd.Action {
+	case "subscribe":
+		if len(channels) > 0 {
+			s.allChannels = false
+		}
+		for _, channel := range channels {
+			s.channels[channel] = true
+		}
+		if len(cmd.Events) > 0 {
+			s.allEvents = false
+		}
+		for _, event := range cmd.Events {
+			s.events[event] = true
+		}
+		if cmd.Window > 0 {
+			s.window = time.Duration(cmd.Window) * time.Minute
+		}
+	case "unsubscribe":
+		if len(channels) == 0 && len(cmd.Events) == 0 {
+			s.allChannels, s.allEvents = false, false
+			clear(s.channels)
+			clear(s.events)
+			return nil
+		}
+		if len(channels) > 0 && s.allChannels {
+			return errors.New("cannot unsubscribe from a channel while subscribed to all channels")
+		}
+		if len(cmd.Events) > 0 && s.allEvents {
+			return errors.New("cannot unsubscribe from an event while subscribed to all events")
+		}
+		for _, channel := range channels {
+			delete(s.channels, channel)
+		}
+		for _, event := range cmd.Events {
+			delete(s.events, event)
+		}
+	default:
+		return fmt.Errorf("invalid action %q, expected subscribe or unsubscribe", cmd.Action)
+	}
+	return nil
+}
+
+// Identifies subscriptions that receive the same payloads
+func (s *subscription) key() string {
+	var b strings.Builder
+	if s.allChannels {
+		b.WriteString("*")
+	} else {
+		b.WriteString(strings.Join(sortedKeys(s.channels), ","))
+	}
+	b.WriteString("|")
+	if s.allEvents {
+		b.WriteString("*")
+	} else {
+		b.WriteString(strings.Join(sortedKeys(s.events), ","))
+	}
+	fmt.Fprintf(&b, "|%d", s.window)
+	return b.String()
+}
+
+// Returns the part of the event matching the subscription, or false if the event is not subscribed
+func (s *subscription) filter(e hubEvent) (Event, bool) {
+	if !s.allEvents && !s.events[e.stream()] {
+		return Event{}, false
+	}
+	data := make(map[string]channelData, len(e.channels))
+	for channel, channelData := range e.channels {
+		if !s.allChannels && !s.channels[channel] {
+			continue
+		}
+		if s.window > 0 {
+			channelData = channelData.since(e.moment.Add(-s.window))
+		}
+		data[channel] = channelData
+	}
+	return Event{Event: e.event, Seq: e.seq, Data: data}, true
+}
+
+// Current state of a subscription, sent back to clients after each command
+func (s *subscription) state() map[string]any {
+	channels, events := sortedKeys(s.channels), sortedKeys(s.events)
+	if s.allChannels {
+		channels = []string{"*"}
+	}
+	if s.allEvents {
+		events = []string{"*"}
+	}
+	return map[string]any{
+		"channels": channels,
+		"events":   events,
+		"window":   int(s.window.Minutes()),
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"website/internal/service"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+var moment = time.Date(2024, 12, 1, 14, 30, 0, 0, time.UTC)
+
+func testEvent() hubEvent {
+	return hubEvent{
+		event:  "results",
+		moment: moment,
+		channels: map[string]channelData{
+			"channel0": averageResults{
+				{Timestamp: moment.Add(-20 * time.Minute)},
+				{Timestamp: moment.Add(-5 * time.Minute)},
+			},
+			"channel1": averageResults{
+				{Timestamp: moment.Add(-1 * time.Minute)},
+			},
+		},
+	}
+}
+
+func TestSubscriptionDefaults(t *testing.T) {
+	s := newSubscription()
+
+	event, ok := s.filter(testEvent())
+	require.True(t, ok)
+	require.Len(t, event.Data, 2)
+	require.Len(t, event.Data.(map[string]channelData)["channel0"], 2)
+}
+
+func TestSubscriptionApply(t *testing.T) {
+	s := newSubscription()
+
+	require.NoError(t, s.apply(command{Action: "subscribe", Channels: []string{"Channel0"}, Window: 10}))
+	event, ok := s.filter(testEvent())
+	require.True(t, ok)
+	data := event.Data.(map[string]channelData)
+	require.Len(t, data, 1)
+	require.Len(t, data["channel0"], 1, "only entries inside the window")
+
+	require.NoError(t, s.apply(command{Action: "subscribe", Events: []string{"messages"}}))
+	_, ok = s.filter(testEvent())
+	require.False(t, ok)
+
+	require.NoError(t, s.apply(command{Action: "subscribe", Events: []string{"results"}}))
+	require.NoError(t, s.apply(command{Action: "unsubscribe", Channels: []string{"channel0"}}))
+	event, ok = s.filter(testEvent())
+	require.True(t, ok)
+	require.Empty(t, event.Data)
+
+	require.NoError(t, s.apply(command{Action: "unsubscribe"}))
+	_, ok = s.filter(testEvent())
+	require.False(t, ok)
+}
+
+func TestSubscriptionApplyErrors(t *testing.T) {
+	for name, cmd := range map[string]command{
+		"action":             {Action: "listen"},
+		"channel":            {Action: "subscribe", Channels: []string{"bad channel"}},
+		"event":              {Action: "subscribe", Events: []string{"everything"}},
+		"window":             {Action: "subscribe", Window: 61},
+		"unsubscribe from *": {Action: "unsubscribe", Channels: []string{"channel0"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newSubscription()
+			require.Error(t, s.apply(cmd))
+		})
+	}
+}
+
+func TestSubscriptionKey(t *testing.T) {
+	a, b := newSubscription(), newSubscription()
+	require.Equal(t, a.key(), b.key())
+
+	require.NoError(t, a.apply(command{Action: "subscribe", Channels: []string{"b", "a"}}))
+	require.NoError(t, b.apply(command{Action: "subscribe", Channels: []string{"a", "b"}}))
+	require.Equal(t, a.key(), b.key())
+
+	require.NoError(t, b.apply(command{Action: "subscribe", Window: 5}))
+	require.NotEqual(t, a.key(), b.key())
+}
+
+func TestHubSubscription(t *testing.T) {
+	hub := NewBroadcastHub(logger)
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
+
+	server := httptest.NewServer(wsHandler(hub, originPolicy{}, logger))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	require.NoError(t, ws.WriteJSON(command{Action: "subscribe", Channels: []string{"channel1"}}))
+	var reply Event
+	require.NoError(t, ws.ReadJSON(&reply))
+	require.Equal(t, "subscription", reply.Event)
+
+	require.NoError(t, ws.WriteJSON(command{Action: "subscribe", Events: []string{"unknown"}}))
+	require.NoError(t, ws.ReadJSON(&reply))
+	require.Equal(t, "error", reply.Event)
+
+	hub.send(testEvent())
+
+	var event struct {
+		Event string                             `json:"event"`
+		Data  map[string][]service.AverageResult `json:"data"`
+	}
+	require.NoError(t, ws.ReadJSON(&event))
+	require.Equal(t, "results", event.Event)
+	require.Len(t, event.Data, 1)
+	require.Len(t, event.Data["channel1"], 1)
+}
+
+func TestSubscriptionDeltas(t *testing.T) {
+	s := newSubscription()
+	require.NoError(t, s.apply(command{Action: "subscribe", Events: []string{"results"}}))
+
+	delta := testEvent()
+	delta.event, delta.seq = "results_delta", 3
+	event, ok := s.filter(delta)
+	require.True(t, ok)
+	require.Equal(t, "results_delta", event.Event)
+	require.EqualValues(t, 3, event.Seq)
+
+	delta.event = "messages_delta"
+	_, ok = s.filter(delta)
+	require.False(t, ok)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTrendsWindow = 10 * time.Minute
+	defaultTrendsLimit  = 20
+	maxTrendsLimit      = 100
+)
+
+// Trends broadcast by the leader every minute
+type trendsConfig struct {
+	// Window of the trends, 0 disables them
+	window time.Duration
+	// Terms of each list
+	limit int
+}
+
+// Reads TRENDS_WINDOW, in whole minutes up to an hour, and TRENDS_LIMIT
+func newTrendsConfig(logger *zap.SugaredLogger) trendsConfig {
+	config := trendsConfig{window: defaultTrendsWindow, limit: defaultTrendsLimit}
+	if value, found := os.LookupEnv("TRENDS_WINDOW"); found {
+		window, err := time.ParseDuration(value)
+		if err != nil || window < 0 || window > maxWindow || window%time.Minute != 0 {
+			logger.Fatalf("Invalid TRENDS_WINDOW %q, expected whole minutes up to %s, or 0 to disable", value, maxWindow)
+		}
+		config.window = window
+	}
+	if value, found := os.LookupEnv("TRENDS_LIMIT"); found {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxTrendsLimit {
+			logger.Fatalf("Invalid TRENDS_LIMIT %q, expected 1 to %d", value, maxTrendsLimit)
+		}
+		config.limit = limit
+	}
+	return config
+}
+
+// Broadcasts the trends of every channel over the complete minutes of the window, once per minute and only on the leader
+func (s *scheduler) sendTrends(ctx context.Context, now time.Time) {
+	if !s.leader || s.trends.window == 0 {
+		return
+	}
+	minute := now.Truncate(time.Minute)
+	if !minute.After(s.trendsMinute) {
+		return
+	}
+	if !s.backplane.Distributed() && s.hub.ClientCount() == 0 {
+		return
+	}
+	s.trendsMinute = minute
+
+	trends, err := s.resultsService.GetChannelsTrends(ctx, nil, minute.Add(-s.trends.window), minute, s.trends.limit)
+	if err != nil {
+		s.logger.Errorf("Failed to get trends: %v", err)
+		return
+	}
+	channels := make(map[string]channelData, len(trends))
+	for channel, channelTrend := range trends {
+		channels[channel] = channelTrends(channelTrend)
+	}
+	s.publish(ctx, hubEvent{event: "trends", moment: now, channels: channels})
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTrendsConfig(t *testing.T) {
+	require.Equal(t, trendsConfig{window: defaultTrendsWindow, limit: defaultTrendsLimit}, newTrendsConfig(logger))
+
+	t.Setenv("TRENDS_WINDOW", "30m")
+	t.Setenv("TRENDS_LIMIT", "5")
+	require.Equal(t, trendsConfig{window: 30 * time.Minute, limit: 5}, newTrendsConfig(logger))
+
+	t.Setenv("TRENDS_WINDOW", "0")
+	require.Zero(t, newTrendsConfig(logger).window)
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Rollup tables kept by the rollup job, coarsest first
+var rollupTables = []struct {
+	table  string
+	bucket Bucket
+}{
+	{table: "results_rollup_1d", bucket: BucketDay},
+	{table: "results_rollup_1h", bucket: BucketHour},
+	{table: "results_rollup_1m", bucket: BucketMinute},
+}
+
+// Coarsest rollup table with buckets that fit in the requested bucket and the [from, to) range, false if there is none
+func pickRollup(from, to time.Time, bucket Bucket) (string, bool) {
+	for _, rollup := range rollupTables {
+		unit := rollup.bucket.Duration()
+		if unit > bucket.Duration() {
+			continue
+		}
+		if from.UTC().Truncate(unit).Equal(from) && to.UTC().Truncate(unit).Equal(to) {
+			return rollup.table, true
+		}
+	}
+	return "", false
+}
+
+// Averages grouped by channel and bucket in the [from, to) range, of every channel when channels is nil.
+// Each result weighs one minus its bot score, buckets of bots only average to 0.
+// Results not in the rollups yet are read from the results table.
+func (s *ResultsService) averageResults(ctx context.Context, channels []string, from, to time.Time, bucket Bucket) ([]AverageResult, error) {
+	query, args := averagesQuery(channels, from, to, bucket)
+	rows, err := s.conn.Query(ctx, query, args)
+	if err != nil {
+		s.logger.Error(err)
+		return nil, err
+	}
+
+	results, err := pgx.CollectRows(rows, pgx.RowToStructByName[AverageResult])
+	if err != nil {
+		s.logger.Error(err)
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Query of the averages of averageResults, reading the coarsest rollup that fits
+func averagesQuery(channels []string, from, to time.Time, bucket Bucket) (string, pgx.NamedArgs) {
+	args := pgx.NamedArgs{
+		"channels": channels,
+		"from":     from,
+		"to":       to,
+		"bucket":   string(bucket),
+	}
+
+	query := `
+SELECT
+    DATE_TRUNC(@bucket, "timestamp", 'UTC') AS "minute_timestamp",
+    channel,
+    COUNT(*) AS messages,
+    COALESCE(SUM(sentiment_positive * (1 - bot_score)) / NULLIF(SUM(1 - bot_score), 0), 0) AS avg_sentiment_positive,
+    COALESCE(SUM(sentiment_neutral * (1 - bot_score)) / NULLIF(SUM(1 - bot_score), 0), 0) AS avg_sentiment_neutral,
+    COALESCE(SUM(sentiment_negative * (1 - bot_score)) / NULLIF(SUM(1 - bot_score), 0), 0) AS avg_sentiment_negative
+FROM
+    results
+WHERE
+    (@channels::VARCHAR[] IS NULL OR channel = ANY(@channels)) AND "timestamp" >= @from AND "timestamp" < @to
+GROUP BY
+    "minute_timestamp", channel
+ORDER BY
+    "minute_timestamp" ASC;
+`
+	if table, found := pickRollup(from, to, bucket); found {
+		query = `
+SELECT
+    DATE_TRUNC(@bucket, bucket, 'UTC') AS "minute_timestamp",
+    channel,
+    SUM(messages)::bigint AS messages,
+    COALESCE(SUM(sum_positive) / NULLIF(SUM(sum_weight), 0), 0) AS avg_sentiment_positive,
+    COALESCE(SUM(sum_neutral) / NULLIF(SUM(sum_weight), 0), 0) AS avg_sentiment_neutral,
+    COALESCE(SUM(sum_negative) / NULLIF(SUM(sum_weight), 0), 0) AS avg_sentiment_negative
+FROM (
+    SELECT
+        channel, bucket, messages, sum_weight, sum_positive, sum_neutral, sum_negative
+    FROM
+        ` + table + `
+    WHERE
+        (@channels::VARCHAR[] IS NULL OR channel = ANY(@channels)) AND bucket >= @from AND bucket < @to
+    UNION ALL
+    SELECT
+        channel, "timestamp", 1, 1 - bot_score, sentiment_positive * (1 - bot_score), sentiment_neutral * (1 - bot_score), sentiment_negative * (1 - bot_score)
+    FROM
+        results
+    WHERE
+        ingested_at > (SELECT watermark FROM rollup_watermarks WHERE name = 'results')
+        AND (@channels::VARCHAR[] IS NULL OR channel = ANY(@channels)) AND "timestamp" >= @from AND "timestamp" < @to
+) AS buckets
+GROUP BY
+    "minute_timestamp", channel
+ORDER BY
+    "minute_timestamp" ASC;
+`
+	}
+
+	return query, args
+}
+
+func byChannel(results []AverageResult) map[string][]AverageResult {
+	result := make(map[string][]AverageResult)
+	for _, r := range results {
+		result[r.Channel] = append(result[r.Channel], r)
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/rollup"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestPickRollup(t *testing.T) {
+	day := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		from, to time.Time
+		bucket   Bucket
+		table    string
+	}{
+		{day, day.Add(48 * time.Hour), BucketDay, "results_rollup_1d"},
+		{day, day.Add(48 * time.Hour), BucketHour, "results_rollup_1h"},
+		{day.Add(time.Hour), day.Add(48 * time.Hour), BucketDay, "results_rollup_1h"},
+		{day.Add(time.Minute), day.Add(time.Hour), BucketHour, "results_rollup_1m"},
+		{day, day.Add(time.Hour), BucketMinute, "results_rollup_1m"},
+		{day.Add(time.Second), day.Add(time.Hour), BucketMinute, ""},
+	}
+	for _, c := range cases {
+		table, found := pickRollup(c.from, c.to, c.bucket)
+		require.Equal(t, c.table != "", found)
+		require.Equal(t, c.table, table, "%s to %s by %s", c.from, c.to, c.bucket)
+	}
+
+	// Same instant in another time zone
+	table, found := pickRollup(day.In(time.FixedZone("UTC-3", -3*60*60)), day.Add(24*time.Hour), BucketDay)
+	require.True(t, found)
+	require.Equal(t, "results_rollup_1d", table)
+}
+
+func TestAverageResultsRollup(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	require.NoError(t, testutils.PopulateDatabase(dsn, 3, 4))
+
+	t.Setenv("DATABASE_DSN", dsn)
+	t.Setenv("ROLLUP_LAG", "0s")
+	conn := database.NewDatabaseConnection(logger)
+	service := NewResultsService(conn, logger)
+
+	day := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	query := func() map[Bucket][]AverageResult {
+		averages := map[Bucket][]AverageResult{}
+		for _, bucket := range []Bucket{BucketMinute, BucketHour, BucketDay} {
+			results, err := service.GetChannelAverageResults(ctx, "channel1", day, day.Add(24*time.Hour), bucket)
+			require.NoError(t, err)
+			averages[bucket] = results
+		}
+		return averages
+	}
+
+	// Before the rollup runs the results come from the results table
+	before := query()
+	require.Len(t, before[BucketMinute], 4)
+	require.Len(t, before[BucketHour], 1)
+	require.Len(t, before[BucketDay], 1)
+
+	_, err = rollup.NewJob(conn, logger).Run(ctx)
+	require.NoError(t, err)
+	rolledUp := query()
+	for bucket, results := range before {
+		require.Len(t, rolledUp[bucket], len(results))
+		for i, result := range results {
+			require.True(t, result.Timestamp.Equal(rolledUp[bucket][i].Timestamp))
+			require.InDelta(t, result.AveragePositiveSentiment, rolledUp[bucket][i].AveragePositiveSentiment, 1e-9)
+			require.InDelta(t, result.AverageNeutralSentiment, rolledUp[bucket][i].AverageNeutralSentiment, 1e-9)
+			require.InDelta(t, result.AverageNegativeSentiment, rolledUp[bucket][i].AverageNegativeSentiment, 1e-9)
+		}
+	}
+
+	// Results inserted after the rollup are merged with it
+	_, err = conn.Exec(ctx, `INSERT INTO results
+		(channel, "user", "message_id", "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative)
+		VALUES ('channel1', 'user1', 'msg-new', '2024-12-01T14:00:30Z', 'new message', 0.2, 0.4, 0.4);`)
+	require.NoError(t, err)
+	after := query()
+	require.InDelta(t, 0.5, after[BucketMinute][0].AveragePositiveSentiment, 1e-9)
+	require.InDelta(t, (0.8*4+0.2)/5, after[BucketDay][0].AveragePositiveSentiment, 1e-9)
+
+	// Results weigh one minus their bot score, before and after the rollup
+	_, err = conn.Exec(ctx, `INSERT INTO results
+		(channel, "user", "message_id", "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative, bot_score)
+		VALUES ('channel1', 'bot1', 'msg-bot', '2024-12-01T14:00:40Z', 'bot message', 0, 0.5, 0.5, 0.5);`)
+	require.NoError(t, err)
+	for range 2 {
+		weighted := query()
+		require.EqualValues(t, 3, weighted[BucketMinute][0].Messages)
+		require.InDelta(t, 1/2.5, weighted[BucketMinute][0].AveragePositiveSentiment, 1e-9)
+		require.InDelta(t, (0.8*4+0.2)/5.5, weighted[BucketDay][0].AveragePositiveSentiment, 1e-9)
+		_, err = rollup.NewJob(conn, logger).Run(ctx)
+		require.NoError(t, err)
+	}
+}
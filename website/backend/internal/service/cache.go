@@ -0,0 +1,96 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultCacheTTL = 30 * time.Second
+	// Expired entries are removed once the cache reaches this size, and every entry if none expired
+	maxCacheEntries = 1000
+)
+
+var cacheCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "results_cache_requests_total",
+}, []string{"result"})
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+// Query results kept in memory for a short time, so repeated dashboard requests do not scan the results again
+type ttlCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+func (c *ttlCache) get(key string, now time.Time) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[key]
+	if !found || !now.Before(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *ttlCache) set(key string, value any, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCacheEntries {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.ttl)}
+}
+
+// Drops every entry
+func (c *ttlCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// Drops every cached response, so results changed outside of the ingest are not served from the cache
+func (s *ResultsService) ClearCache() {
+	if s.cache != nil {
+		s.cache.purge()
+	}
+}
+
+// Returns the cached value of the key, or loads and caches it. Errors are not cached.
+func cached[T any](c *ttlCache, key string, load func() (T, error)) (T, error) {
+	if c == nil || c.ttl == 0 {
+		return load()
+	}
+	if value, found := c.get(key, time.Now()); found {
+		cacheCounter.WithLabelValues("hit").Inc()
+		return value.(T), nil
+	}
+	cacheCounter.WithLabelValues("miss").Inc()
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	c.set(key, value, time.Now())
+	return value, nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLCache(t *testing.T) {
+	cache := newTTLCache(time.Minute)
+	now := time.Now()
+
+	cache.set("key", 1, now)
+	value, found := cache.get("key", now.Add(59*time.Second))
+	require.True(t, found)
+	require.Equal(t, 1, value)
+	_, found = cache.get("key", now.Add(time.Minute))
+	require.False(t, found)
+
+	// Expired entries make room for new ones
+	cache = newTTLCache(time.Minute)
+	for i := range maxCacheEntries {
+		cache.set(fmt.Sprint(i), i, now)
+	}
+	cache.set("new", 1, now.Add(time.Minute))
+	require.Len(t, cache.entries, 1)
+
+	// The cache does not grow past its size when no entry expired
+	for i := range 2 * maxCacheEntries {
+		cache.set(fmt.Sprint(i), i, now)
+	}
+	require.LessOrEqual(t, len(cache.entries), maxCacheEntries)
+}
+
+func TestCached(t *testing.T) {
+	loads := 0
+	load := func() (int, error) {
+		loads++
+		return loads, nil
+	}
+
+	cache := newTTLCache(time.Minute)
+	for range 3 {
+		value, err := cached(cache, "key", load)
+		require.NoError(t, err)
+		require.Equal(t, 1, value)
+	}
+
+	_, err := cached(cache, "failing", func() (int, error) { return 0, errors.New("failed") })
+	require.Error(t, err)
+	_, found := cache.get("failing", time.Now())
+	require.False(t, found)
+
+	// A nil cache always loads
+	value, err := cached(nil, "key", load)
+	require.NoError(t, err)
+	require.Equal(t, 2, value)
+}
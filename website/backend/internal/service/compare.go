@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Fewer minutes with values in both channels than this leave the correlations unknown
+const minCorrelationPoints = 3
+
+type ComparisonMetric string
+
+const (
+	CompareMessages ComparisonMetric = "messages"
+	ComparePositive ComparisonMetric = "positive"
+	CompareNeutral  ComparisonMetric = "neutral"
+	CompareNegative ComparisonMetric = "negative"
+	// Positive minus negative average
+	CompareIndex ComparisonMetric = "index"
+)
+
+func ParseComparisonMetric(value string) (ComparisonMetric, error) {
+	switch metric := ComparisonMetric(value); metric {
+	case CompareMessages, ComparePositive, CompareNeutral, CompareNegative, CompareIndex:
+		return metric, nil
+	}
+	return "", fmt.Errorf("invalid metric %q, expected one of: messages, positive, neutral, negative, index", value)
+}
+
+func (m ComparisonMetric) value(r AverageResult) float64 {
+	switch m {
+	case CompareMessages:
+		return float64(r.Messages)
+	case ComparePositive:
+		return r.AveragePositiveSentiment
+	case CompareNeutral:
+		return r.AverageNeutralSentiment
+	case CompareNegative:
+		return r.AverageNegativeSentiment
+	default:
+		return r.AveragePositiveSentiment - r.AverageNegativeSentiment
+	}
+}
+
+// Per minute series of several channels on the same time grid and how each pair of them relates
+type Comparison struct {
+	Metric     ComparisonMetric `json:"metric"`
+	Timestamps []time.Time      `json:"timestamps"`
+	// Value of each channel at each timestamp, null for sentiment in minutes without messages
+	Series map[string][]*float64 `json:"series"`
+	Pairs  []ChannelPair         `json:"pairs"`
+}
+
+// Relation of the series of channel A to those of channel B, over the minutes both have values
+type ChannelPair struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	// A minus B at each timestamp
+	Difference     []*float64 `json:"difference"`
+	MeanDifference *float64   `json:"mean_difference"`
+	Points         int        `json:"points"`
+	// Pearson correlation of the series
+	Correlation *float64 `json:"correlation"`
+	// Minutes B trails A by at the highest cross-correlation, negative when B leads
+	Lag            *int     `json:"lag"`
+	LagCorrelation *float64 `json:"lag_correlation"`
+}
+
+// Compares the per minute metric of the channels in the [from, to) range, with lags of up to maxLag minutes
+func (s *ResultsService) GetChannelsComparison(ctx context.Context, channels []string, from, to time.Time, metric ComparisonMetric, maxLag int) (Comparison, error) {
+	from, to = from.Truncate(time.Minute), to.Truncate(time.Minute)
+	key := fmt.Sprintf("comparison|%s|%d|%d|%s|%d", strings.Join(channels, ","), from.UnixNano(), to.UnixNano(), metric, maxLag)
+	return cached(s.cache, key, func() (Comparison, error) {
+		averages, err := s.averageResults(ctx, channels, from, to, BucketMinute)
+		if err != nil {
+			return Comparison{}, err
+		}
+		return compare(channels, from, to, metric, maxLag, byChannel(averages)), nil
+	})
+}
+
+func compare(channels []string, from, to time.Time, metric ComparisonMetric, maxLag int, averages map[string][]AverageResult) Comparison {
+	comparison := Comparison{Metric: metric, Timestamps: []time.Time{}, Series: map[string][]*float64{}, Pairs: []ChannelPair{}}
+	index := map[time.Time]int{}
+	for t := from; t.Before(to); t = t.Add(time.Minute) {
+		index[t] = len(comparison.Timestamps)
+		comparison.Timestamps = append(comparison.Timestamps, t)
+	}
+
+	for _, channel := range channels {
+		series := make([]*float64, len(comparison.Timestamps))
+		// Minutes without messages had none, but their sentiment is unknown
+		if metric == CompareMessages {
+			for i := range series {
+				series[i] = new(float64)
+			}
+		}
+		for _, average := range averages[channel] {
+			if i, found := index[average.Timestamp.UTC()]; found {
+				value := metric.value(average)
+				series[i] = &value
+			}
+		}
+		comparison.Series[channel] = series
+	}
+
+	for i, a := range channels {
+		for _, b := range channels[i+1:] {
+			comparison.Pairs = append(comparison.Pairs, comparePair(a, b, comparison.Series[a], comparison.Series[b], maxLag))
+		}
+	}
+	return comparison
+}
+
+func comparePair(a, b string, seriesA, seriesB []*float64, maxLag int) ChannelPair {
+	pair := ChannelPair{A: a, B: b, Difference: make([]*float64, len(seriesA))}
+	sum := 0.0
+	for i := range seriesA {
+		if seriesA[i] != nil && seriesB[i] != nil {
+			difference := *seriesA[i] - *seriesB[i]
+			pair.Difference[i] = &difference
+			sum += difference
+			pair.Points++
+		}
+	}
+	if pair.Points > 0 {
+		mean := sum / float64(pair.Points)
+		pair.MeanDifference = &mean
+	}
+	pair.Correlation = pearson(seriesA, seriesB, 0)
+
+	for lag := -maxLag; lag <= maxLag; lag++ {
+		correlation := pearson(seriesA, seriesB, lag)
+		if correlation == nil {
+			continue
+		}
+		// Ties keep the shortest lag
+		if pair.LagCorrelation == nil || *correlation > *pair.LagCorrelation ||
+			(*correlation == *pair.LagCorrelation && abs(lag) < abs(*pair.Lag)) {
+			pair.Lag, pair.LagCorrelation = &lag, correlation
+		}
+	}
+	return pair
+}
+
+// Pearson correlation of a[t] and b[t+lag] over the minutes both have values, nil when it is unknown
+func pearson(a, b []*float64, lag int) *float64 {
+	var n, sumA, sumB, sumAA, sumBB, sumAB float64
+	for i := range a {
+		j := i + lag
+		if j < 0 || j >= len(b) || a[i] == nil || b[j] == nil {
+			continue
+		}
+		x, y := *a[i], *b[j]
+		n++
+		sumA += x
+		sumB += y
+		sumAA += x * x
+		sumBB += y * y
+		sumAB += x * y
+	}
+	if n < minCorrelationPoints {
+		return nil
+	}
+	covariance := sumAB - sumA*sumB/n
+	variance := (sumAA - sumA*sumA/n) * (sumBB - sumB*sumB/n)
+	// Constant series do not correlate with anything
+	if variance <= 1e-12 {
+		return nil
+	}
+	correlation := math.Max(-1, math.Min(1, covariance/math.Sqrt(variance)))
+	return &correlation
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
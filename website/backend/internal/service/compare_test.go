@@ -0,0 +1,72 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func values(values ...float64) []*float64 {
+	series := make([]*float64, len(values))
+	for i, value := range values {
+		if value >= 0 {
+			series[i] = &value
+		}
+	}
+	return series
+}
+
+func TestPearson(t *testing.T) {
+	a := values(1, 2, 3, 4, 5)
+	require.InDelta(t, 1, *pearson(a, values(2, 4, 6, 8, 10), 0), 1e-9)
+	require.InDelta(t, -1, *pearson(a, values(5, 4, 3, 2, 1), 0), 1e-9)
+
+	// Minutes without a value in either series are left out
+	require.InDelta(t, 1, *pearson(a, values(2, -1, 6, 8, 10), 0), 1e-9)
+	require.Nil(t, pearson(a, values(-1, -1, -1, 8, 10), 0))
+	// Constant series
+	require.Nil(t, pearson(a, values(3, 3, 3, 3, 3), 0))
+
+	// b[t+1] follows a[t]
+	require.InDelta(t, 1, *pearson(values(1, 5, 2, 8, 3, 0), values(0, 1, 5, 2, 8, 3), 1), 1e-9)
+}
+
+func TestCompare(t *testing.T) {
+	from := time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC)
+	averages := map[string][]AverageResult{}
+	// The chat of "late" reacts two minutes after the one of "early", and more negatively
+	early := []float64{0.1, 0.1, 0.6, 0.2, 0.1, 0.5, 0.1, 0.3, 0.1, 0.1}
+	for i, negative := range early {
+		minute := from.Add(time.Duration(i) * time.Minute)
+		averages["early"] = append(averages["early"], AverageResult{Channel: "early", Timestamp: minute, Messages: 10, AverageNegativeSentiment: negative})
+		if i+2 < len(early) {
+			averages["late"] = append(averages["late"], AverageResult{Channel: "late", Timestamp: minute.Add(2 * time.Minute), Messages: 5, AverageNegativeSentiment: negative + 0.1})
+		}
+	}
+
+	comparison := compare([]string{"early", "late", "quiet"}, from, from.Add(10*time.Minute), CompareNegative, 5, averages)
+	require.Len(t, comparison.Timestamps, 10)
+	require.Nil(t, comparison.Series["late"][0])
+	require.InDelta(t, 0.7, *comparison.Series["late"][4], 1e-9)
+	require.Len(t, comparison.Pairs, 3)
+
+	pair := comparison.Pairs[0]
+	require.Equal(t, "early", pair.A)
+	require.Equal(t, "late", pair.B)
+	require.Equal(t, 8, pair.Points)
+	require.Nil(t, pair.Difference[1])
+	require.InDelta(t, 0.4, *pair.Difference[2], 1e-9)
+	require.Equal(t, 2, *pair.Lag)
+	require.InDelta(t, 1, *pair.LagCorrelation, 1e-9)
+
+	// No minutes in common with a quiet channel
+	require.Zero(t, comparison.Pairs[1].Points)
+	require.Nil(t, comparison.Pairs[1].Correlation)
+	require.Nil(t, comparison.Pairs[1].Lag)
+
+	// Quiet minutes have no messages
+	comparison = compare([]string{"early", "quiet"}, from, from.Add(10*time.Minute), CompareMessages, 0, averages)
+	require.Equal(t, 0.0, *comparison.Series["quiet"][0])
+	require.InDelta(t, 10, *comparison.Pairs[0].MeanDifference, 1e-9)
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Rows fetched from the export cursor at a time
+const exportBatchSize = 1000
+
+// Calls fn with every result of the channel in the [from, to) range, oldest first
+func (s *ResultsService) ExportResults(ctx context.Context, channel string, from, to time.Time, fn func(Result) error) error {
+	return s.StreamResults(ctx, []string{channel}, from, to, fn)
+}
+
+// Calls fn with every result of the channels, or of every channel when there are none, in the [from, to) range, oldest first
+func (s *ResultsService) StreamResults(ctx context.Context, channels []string, from, to time.Time, fn func(Result) error) error {
+	return exportRows(ctx, s, `
+SELECT
+    channel, "user", message, message_id, "timestamp", sentiment_positive, sentiment_neutral, sentiment_negative, moderated
+FROM
+    results
+WHERE
+    (COALESCE(cardinality(@channels::VARCHAR[]), 0) = 0 OR channel = ANY(@channels)) AND "timestamp" >= @from AND "timestamp" < @to
+ORDER BY
+    "timestamp" ASC, message_id ASC
+`, pgx.NamedArgs{"channels": channels, "from": from, "to": to}, fn)
+}
+
+// Calls fn with the averages of every bucket of the channel in the [from, to) range, oldest first
+func (s *ResultsService) ExportAverageResults(ctx context.Context, channel string, from, to time.Time, bucket Bucket, fn func(AverageResult) error) error {
+	query, args := averagesQuery([]string{channel}, from, to, bucket)
+	return exportRows(ctx, s, query, args, fn)
+}
+
+// Reads the rows of the query in batches from a server side cursor, so memory does not grow with the range.
+// The cursor lives in a read only transaction that is rolled back when done.
+func exportRows[T any](ctx context.Context, s *ResultsService, query string, args pgx.NamedArgs, fn func(T) error) error {
+	tx, err := s.conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		s.logger.Error(err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DECLARE export NO SCROLL CURSOR FOR "+query, args); err != nil {
+		s.logger.Error(err)
+		return err
+	}
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM export", exportBatchSize)
+	for {
+		rows, err := tx.Query(ctx, fetch)
+		if err != nil {
+			s.logger.Error(err)
+			return err
+		}
+		batch, err := pgx.CollectRows(rows, pgx.RowToStructByName[T])
+		if err != nil {
+			s.logger.Error(err)
+			return err
+		}
+		for _, row := range batch {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+	}
+}
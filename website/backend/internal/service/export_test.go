@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+
+	// More than a batch, one message per minute
+	messages := exportBatchSize + 500
+	err = testutils.PopulateDatabase(dsn, 2, messages)
+	require.NoError(t, err)
+
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+
+	service := NewResultsService(conn, logger)
+	from, to := now, now.Add(48*time.Hour)
+
+	var results []Result
+	err = service.ExportResults(ctx, "channel0", from, to, func(result Result) error {
+		results = append(results, result)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, results, messages)
+	for i, result := range results {
+		require.Equal(t, "channel0", result.Channel)
+		if i > 0 {
+			require.True(t, results[i-1].Timestamp.Before(result.Timestamp))
+		}
+	}
+
+	var averages []AverageResult
+	err = service.ExportAverageResults(ctx, "channel0", from, to, BucketHour, func(average AverageResult) error {
+		averages = append(averages, average)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, averages, (messages+59)/60)
+	require.EqualValues(t, 60, averages[0].Messages)
+
+	// Errors of the callback stop the export
+	stop := errors.New("stop")
+	calls := 0
+	err = service.ExportResults(ctx, "channel0", from, to, func(Result) error {
+		calls++
+		return stop
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 1, calls)
+}
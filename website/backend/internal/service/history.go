@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type Bucket string
+
+const (
+	BucketMinute Bucket = "minute"
+	BucketHour   Bucket = "hour"
+	BucketDay    Bucket = "day"
+)
+
+func ParseBucket(value string) (Bucket, error) {
+	switch bucket := Bucket(value); bucket {
+	case BucketMinute, BucketHour, BucketDay:
+		return bucket, nil
+	}
+	return "", fmt.Errorf("invalid bucket %q, expected one of: minute, hour, day", value)
+}
+
+// Duration of a single bucket
+func (b Bucket) Duration() time.Duration {
+	switch b {
+	case BucketHour:
+		return time.Hour
+	case BucketDay:
+		return 24 * time.Hour
+	default:
+		return time.Minute
+	}
+}
+
+type Channel struct {
+	Channel     string    `json:"channel" db:"channel"`
+	Messages    int64     `json:"messages" db:"messages"`
+	LastMessage time.Time `json:"last_message" db:"last_message"`
+}
+
+type ChannelSummary struct {
+	Channel  string `json:"channel" db:"channel"`
+	Messages int64  `json:"messages" db:"messages"`
+	Users    int64  `json:"users" db:"users"`
+	// Messages removed by Twitch moderators, counted in messages
+	Moderated                int64      `json:"moderated" db:"moderated"`
+	FirstMessage             *time.Time `json:"first_message" db:"first_message"`
+	LastMessage              *time.Time `json:"last_message" db:"last_message"`
+	AveragePositiveSentiment *float64   `json:"avg_sentiment_positive" db:"avg_sentiment_positive"`
+	AverageNeutralSentiment  *float64   `json:"avg_sentiment_neutral" db:"avg_sentiment_neutral"`
+	AverageNegativeSentiment *float64   `json:"avg_sentiment_negative" db:"avg_sentiment_negative"`
+}
+
+type ResultsPage struct {
+	Results    []Result `json:"results"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// Position of a result inside the ("timestamp", message_id) descending order used for pagination
+type Cursor struct {
+	Timestamp time.Time
+	MessageId string
+}
+
+func (c Cursor) Encode() string {
+	raw := c.Timestamp.UTC().Format(time.RFC3339Nano) + "|" + c.MessageId
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(value string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	timestamp, messageId, found := strings.Cut(string(raw), "|")
+	if !found || len(messageId) == 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Timestamp: t, MessageId: messageId}, nil
+}
+
+func (s *ResultsService) GetChannels(ctx context.Context) ([]Channel, error) {
+	rows, err := s.conn.Query(ctx, `
+SELECT
+    channel,
+    COUNT(*) AS messages,
+    MAX("timestamp") AS last_message
+FROM
+    results
+GROUP BY
+    channel
+ORDER BY
+    channel ASC;
+`)
+	if err != nil {
+		s.logger.Error(err)
+		return nil, err
+	}
+
+	channels, err := pgx.CollectRows(rows, pgx.RowToStructByName[Channel])
+	if err != nil {
+		s.logger.Error(err)
+		return nil, err
+	}
+
+	return channels, nil
+}
+
+// Averages of a channel grouped by bucket in the [from, to) range
+func (s *ResultsService) GetChannelAverageResults(ctx context.Context, channel string, from, to time.Time, bucket Bucket) ([]AverageResult, error) {
+	return s.averageResults(ctx, []string{channel}, from, to, bucket)
+}
+
+// Results of a channel in the [from, to) range, newest first, continuing after cursor when it is not nil
+func (s *ResultsService) GetChannelResults(ctx context.Context, channel string, from, to time.Time, limit int64, cursor *Cursor) (ResultsPage, error) {
+	args := pgx.NamedArgs{
+		"channel": channel,
+		"from":    from,
+		"to":      to,
+		"limit":   limit + 1,
+	}
+	condition := ""
+	if cursor != nil {
+		condition = `AND ("timestamp", message_id) < (@cursor_timestamp, @cursor_message_id)`
+		args["cursor_timestamp"] = cursor.Timestamp
+		args["cursor_message_id"] = cursor.MessageId
+	}
+
+	rows, err := s.conn.Query(ctx, `
+SELECT
+    channel, "user", message, message_id, "timestamp", sentiment_positive, sentiment_neutral, sentiment_negative, moderated
+FROM
+    results
+WHERE
+    channel = @channel AND "timestamp" >= @from AND "timestamp" < @to `+condition+`
+ORDER BY
+    "timestamp" DESC, message_id DESC
+LIMIT @limit;
+`, args)
+	if err != nil {
+		s.logger.Error(err)
+		return ResultsPage{}, err
+	}
+
+	results, err := pgx.CollectRows(rows, pgx.RowToStructByName[Result])
+	if err != nil {
+		s.logger.Error(err)
+		return ResultsPage{}, err
+	}
+
+	page := ResultsPage{Results: results}
+	if int64(len(results)) > limit {
+		page.Results = results[:limit]
+		last := page.Results[limit-1]
+		page.NextCursor = Cursor{Timestamp: last.Timestamp, MessageId: last.MessageId}.Encode()
+	}
+
+	return page, nil
+}
+
+// Summary of the channel in the [from, to) range, its averages weigh each result by one minus its bot score
+func (s *ResultsService) GetChannelSummary(ctx context.Context, channel string, from, to time.Time) (ChannelSummary, error) {
+	rows, err := s.conn.Query(ctx, `
+SELECT
+    $1::VARCHAR AS channel,
+    COUNT(*) AS messages,
+    COUNT(DISTINCT "user") AS users,
+    COUNT(*) FILTER (WHERE moderated) AS moderated,
+    MIN("timestamp") AS first_message,
+    MAX("timestamp") AS last_message,
+    SUM(sentiment_positive * (1 - bot_score)) / NULLIF(SUM(1 - bot_score), 0) AS avg_sentiment_positive,
+    SUM(sentiment_neutral * (1 - bot_score)) / NULLIF(SUM(1 - bot_score), 0) AS avg_sentiment_neutral,
+    SUM(sentiment_negative * (1 - bot_score)) / NULLIF(SUM(1 - bot_score), 0) AS avg_sentiment_negative
+FROM
+    results
+WHERE
+    channel = $1 AND "timestamp" >= $2 AND "timestamp" < $3;
+`, channel, from, to)
+	if err != nil {
+		s.logger.Error(err)
+		return ChannelSummary{}, err
+	}
+
+	summary, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[ChannelSummary])
+	if err != nil {
+		s.logger.Error(err)
+		return ChannelSummary{}, err
+	}
+
+	return summary, nil
+}
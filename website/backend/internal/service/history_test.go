@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestCursor(t *testing.T) {
+	cursor := Cursor{Timestamp: now.Add(90 * time.Second), MessageId: "msg-0001"}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	require.NoError(t, err)
+	require.True(t, cursor.Timestamp.Equal(decoded.Timestamp))
+	require.Equal(t, cursor.MessageId, decoded.MessageId)
+
+	for _, value := range []string{"", "%%%", "bm8tc2VwYXJhdG9y", "MjAyNHw"} {
+		_, err = DecodeCursor(value)
+		require.ErrorIs(t, err, ErrInvalidCursor, value)
+	}
+}
+
+func TestParseBucket(t *testing.T) {
+	bucket, err := ParseBucket("hour")
+	require.NoError(t, err)
+	require.Equal(t, BucketHour, bucket)
+	require.Equal(t, time.Hour, bucket.Duration())
+
+	_, err = ParseBucket("week")
+	require.Error(t, err)
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+
+	databaseChannels := 3
+	messagesPerChannel := 4
+	err = testutils.PopulateDatabase(dsn, databaseChannels, messagesPerChannel)
+	require.NoError(t, err)
+
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+
+	service := NewResultsService(conn, logger)
+	from, to := now, now.Add(time.Hour)
+
+	channels, err := service.GetChannels(ctx)
+	require.NoError(t, err)
+	require.Len(t, channels, databaseChannels)
+	require.EqualValues(t, messagesPerChannel, channels[0].Messages)
+
+	averages, err := service.GetChannelAverageResults(ctx, "channel0", from, to, BucketMinute)
+	require.NoError(t, err)
+	require.Len(t, averages, messagesPerChannel)
+
+	averages, err = service.GetChannelAverageResults(ctx, "channel0", from, to, BucketHour)
+	require.NoError(t, err)
+	require.Len(t, averages, 1)
+
+	var results []Result
+	var cursor *Cursor
+	for {
+		page, err := service.GetChannelResults(ctx, "channel0", from, to, 3, cursor)
+		require.NoError(t, err)
+		results = append(results, page.Results...)
+		if page.NextCursor == "" {
+			break
+		}
+		next, err := DecodeCursor(page.NextCursor)
+		require.NoError(t, err)
+		cursor = &next
+	}
+	require.Len(t, results, messagesPerChannel)
+	for i := 1; i < len(results); i++ {
+		require.True(t, results[i-1].Timestamp.After(results[i].Timestamp))
+	}
+
+	summary, err := service.GetChannelSummary(ctx, "channel0", from, to)
+	require.NoError(t, err)
+	require.EqualValues(t, messagesPerChannel, summary.Messages)
+	require.EqualValues(t, 1, summary.Users)
+	require.InDelta(t, 0.8, *summary.AveragePositiveSentiment, 0.0001)
+
+	summary, err = service.GetChannelSummary(ctx, "unknown", from, to)
+	require.NoError(t, err)
+	require.Zero(t, summary.Messages)
+	require.Nil(t, summary.LastMessage)
+}
+
+func TestDuplicateResults(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+
+	// Redelivered copies of a message with another timestamp are not stored, so the reads count it once
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+	messagesPerChannel := 3
+	require.NoError(t, testutils.PopulateDatabase(dsn, 1, messagesPerChannel))
+	_, err = conn.Exec(ctx, `INSERT INTO results
+		(channel, "user", message_id, "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative)
+		SELECT channel, "user", message_id, "timestamp" + INTERVAL '1 second', message, sentiment_positive, sentiment_neutral, sentiment_negative
+		FROM results`)
+	require.NoError(t, err)
+
+	service := NewResultsService(conn, logger)
+	from, to := now, now.Add(time.Hour)
+
+	channels, err := service.GetChannels(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, messagesPerChannel, channels[0].Messages)
+
+	summary, err := service.GetChannelSummary(ctx, "channel0", from, to)
+	require.NoError(t, err)
+	require.EqualValues(t, messagesPerChannel, summary.Messages)
+
+	page, err := service.GetChannelResults(ctx, "channel0", from, to, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, page.Results, messagesPerChannel)
+
+	since, err := service.GetResultsSince(ctx, from.Add(-time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, since, messagesPerChannel)
+
+	last, err := service.GetLastResults(ctx, 10, now)
+	require.NoError(t, err)
+	require.Len(t, last["channel0"], messagesPerChannel)
+
+	averages, err := service.GetChannelAverageResults(ctx, "channel0", from, to, BucketHour)
+	require.NoError(t, err)
+	require.Len(t, averages, 1)
+}
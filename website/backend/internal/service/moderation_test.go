@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestModeratedResults(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+
+	// A tombstone stored before its result redacts the result as it is stored
+	_, err = conn.Exec(ctx, `
+INSERT INTO moderated_messages (channel, message_id, action, moderated_at)
+VALUES ('channel0', 'msg-0000', 'delete', NOW()), ('channel0', 'unknown', 'ban', NOW());
+`)
+	require.NoError(t, err)
+	require.NoError(t, testutils.PopulateDatabase(dsn, 2, 4))
+
+	// and one stored after redacts the stored result
+	_, err = conn.Exec(ctx, `
+INSERT INTO moderated_messages (channel, message_id, action, moderated_at)
+VALUES ('channel0', 'msg-0001', 'timeout', NOW());
+`)
+	require.NoError(t, err)
+
+	service := NewResultsService(conn, logger)
+	page, err := service.GetChannelResults(ctx, "channel0", now, now.Add(time.Hour), 10, nil)
+	require.NoError(t, err)
+	require.Len(t, page.Results, 4)
+	moderated := map[string]bool{}
+	for _, result := range page.Results {
+		if result.Moderated {
+			require.Empty(t, result.Message)
+			moderated[result.MessageId] = true
+		} else {
+			require.NotEmpty(t, result.Message)
+		}
+	}
+	require.Equal(t, map[string]bool{"msg-0000": true, "msg-0001": true}, moderated)
+
+	// Moderated messages are not shown live
+	last, err := service.GetLastResults(ctx, 100, now)
+	require.NoError(t, err)
+	require.Len(t, last["channel0"], 2)
+	require.Len(t, last["channel1"], 4)
+	since, err := service.GetResultsSince(ctx, now.Add(-time.Minute), 100)
+	require.NoError(t, err)
+	require.Len(t, since, 6)
+
+	summary, err := service.GetChannelSummary(ctx, "channel0", now, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.EqualValues(t, 4, summary.Messages)
+	require.EqualValues(t, 2, summary.Moderated)
+}
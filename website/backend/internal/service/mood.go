@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type MoodLabel string
+
+const (
+	MoodPositive MoodLabel = "positive"
+	MoodNeutral  MoodLabel = "neutral"
+	MoodNegative MoodLabel = "negative"
+	// No messages in the window
+	MoodQuiet MoodLabel = "quiet"
+)
+
+// Sentiment of the recent messages of a channel, weighted by the messages of each minute
+type Mood struct {
+	Channel                  string  `json:"channel"`
+	Window                   int     `json:"window"`
+	Messages                 int64   `json:"messages"`
+	AveragePositiveSentiment float64 `json:"avg_sentiment_positive"`
+	AverageNeutralSentiment  float64 `json:"avg_sentiment_neutral"`
+	AverageNegativeSentiment float64 `json:"avg_sentiment_negative"`
+	// Positive minus negative sentiment, from -1 to 1
+	Score     float64   `json:"score"`
+	Mood      MoodLabel `json:"mood"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Mood of a channel in the window of minutes before the end of the minute of now
+func (s *ResultsService) GetChannelMood(ctx context.Context, channel string, window int, now time.Time) (Mood, error) {
+	from, to := moodRange(window, now)
+	key := fmt.Sprintf("mood|%s|%d|%d", channel, from.UnixNano(), to.UnixNano())
+	mood, err := cached(s.cache, key, func() (Mood, error) {
+		averages, err := s.averageResults(ctx, []string{channel}, from, to, BucketMinute)
+		if err != nil {
+			return Mood{}, err
+		}
+		return moodOf(channel, window, averages), nil
+	})
+	mood.UpdatedAt = now
+	return mood, err
+}
+
+// Mood of each of the channels in the window of minutes before the end of the minute of now, without caching
+func (s *ResultsService) GetChannelsMood(ctx context.Context, channels []string, window int, now time.Time) (map[string]Mood, error) {
+	from, to := moodRange(window, now)
+	averages, err := s.averageResults(ctx, channels, from, to, BucketMinute)
+	if err != nil {
+		return nil, err
+	}
+	channelAverages := byChannel(averages)
+
+	moods := make(map[string]Mood, len(channels))
+	for _, channel := range channels {
+		mood := moodOf(channel, window, channelAverages[channel])
+		mood.UpdatedAt = now
+		moods[channel] = mood
+	}
+	return moods, nil
+}
+
+func moodRange(window int, now time.Time) (time.Time, time.Time) {
+	to := now.Truncate(time.Minute).Add(time.Minute)
+	return to.Add(-time.Duration(window) * time.Minute), to
+}
+
+func moodOf(channel string, window int, averages []AverageResult) Mood {
+	mood := Mood{Channel: channel, Window: window, Mood: MoodQuiet}
+	for _, average := range averages {
+		mood.Messages += average.Messages
+		mood.AveragePositiveSentiment += average.AveragePositiveSentiment * float64(average.Messages)
+		mood.AverageNeutralSentiment += average.AverageNeutralSentiment * float64(average.Messages)
+		mood.AverageNegativeSentiment += average.AverageNegativeSentiment * float64(average.Messages)
+	}
+	if mood.Messages == 0 {
+		return mood
+	}
+	mood.AveragePositiveSentiment /= float64(mood.Messages)
+	mood.AverageNeutralSentiment /= float64(mood.Messages)
+	mood.AverageNegativeSentiment /= float64(mood.Messages)
+	mood.Score = mood.AveragePositiveSentiment - mood.AverageNegativeSentiment
+
+	switch {
+	case mood.AveragePositiveSentiment >= mood.AverageNeutralSentiment && mood.AveragePositiveSentiment >= mood.AverageNegativeSentiment:
+		mood.Mood = MoodPositive
+	case mood.AverageNegativeSentiment >= mood.AverageNeutralSentiment:
+		mood.Mood = MoodNegative
+	default:
+		mood.Mood = MoodNeutral
+	}
+	return mood
+}
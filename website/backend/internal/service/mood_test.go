@@ -0,0 +1,30 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoodOf(t *testing.T) {
+	minute := time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC)
+
+	mood := moodOf("gaules", 5, nil)
+	require.Equal(t, Mood{Channel: "gaules", Window: 5, Mood: MoodQuiet}, mood)
+
+	// Busier minutes weigh more
+	mood = moodOf("gaules", 5, []AverageResult{
+		{Timestamp: minute, Messages: 1, AveragePositiveSentiment: 0.9, AverageNeutralSentiment: 0.1},
+		{Timestamp: minute.Add(time.Minute), Messages: 3, AveragePositiveSentiment: 0.1, AverageNeutralSentiment: 0.1, AverageNegativeSentiment: 0.8},
+	})
+	require.EqualValues(t, 4, mood.Messages)
+	require.InDelta(t, 0.3, mood.AveragePositiveSentiment, 1e-9)
+	require.InDelta(t, 0.1, mood.AverageNeutralSentiment, 1e-9)
+	require.InDelta(t, 0.6, mood.AverageNegativeSentiment, 1e-9)
+	require.InDelta(t, -0.3, mood.Score, 1e-9)
+	require.Equal(t, MoodNegative, mood.Mood)
+
+	mood = moodOf("gaules", 5, []AverageResult{{Timestamp: minute, Messages: 2, AveragePositiveSentiment: 0.3, AverageNeutralSentiment: 0.5, AverageNegativeSentiment: 0.2}})
+	require.Equal(t, MoodNeutral, mood.Mood)
+}
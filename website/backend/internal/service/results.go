@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"os"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -12,18 +13,31 @@ import (
 type ResultsService struct {
 	conn   *pgxpool.Pool
 	logger *zap.SugaredLogger
+	// Caches the responses of the slower queries, nil disables it
+	cache *ttlCache
 }
 
 func NewResultsService(conn *pgxpool.Pool, logger *zap.SugaredLogger) *ResultsService {
+	cacheTTL := defaultCacheTTL
+	if value, found := os.LookupEnv("RESULTS_CACHE_TTL"); found {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			logger.Fatalf("Invalid RESULTS_CACHE_TTL %q", value)
+		}
+		cacheTTL = ttl
+	}
+
 	return &ResultsService{
 		conn:   conn,
 		logger: logger,
+		cache:  newTTLCache(cacheTTL),
 	}
 }
 
 type AverageResult struct {
 	Channel                  string    `json:"-" db:"channel"`
 	Timestamp                time.Time `json:"timestamp" db:"minute_timestamp"`
+	Messages                 int64     `json:"messages" db:"messages"`
 	AveragePositiveSentiment float64   `json:"avg_sentiment_positive" db:"avg_sentiment_positive"`
 	AverageNeutralSentiment  float64   `json:"avg_sentiment_neutral" db:"avg_sentiment_neutral"`
 	AverageNegativeSentiment float64   `json:"avg_sentiment_negative" db:"avg_sentiment_negative"`
@@ -38,39 +52,47 @@ type Result struct {
 	PositiveSentiment float64   `json:"sentiment_positive" db:"sentiment_positive"`
 	NeutralSentiment  float64   `json:"sentiment_neutral" db:"sentiment_neutral"`
 	NegativeSentiment float64   `json:"sentiment_negative" db:"sentiment_negative"`
+	// Removed by a Twitch moderator, the message is redacted
+	Moderated bool `json:"moderated" db:"moderated"`
 }
 
 func (s *ResultsService) GetLastHourChannelAverageResults(ctx context.Context, moment time.Time) (map[string][]AverageResult, error) {
-	start, end := startAndEndOfHour(moment)
+	start, _ := StartAndEndOfHour(moment)
+
+	results, err := s.averageResults(ctx, nil, start, start.Add(time.Hour), BucketMinute)
+	if err != nil {
+		return nil, err
+	}
+
+	return byChannel(results), nil
+}
+
+func (s *ResultsService) GetLastResults(ctx context.Context, limit int64, moment time.Time) (map[string][]Result, error) {
+	start, end := StartAndEndOfHour(moment)
 
 	rows, err := s.conn.Query(ctx, `
-SELECT 
-    DATE_TRUNC('minute', "timestamp") AS "minute_timestamp",
-    channel,
-    AVG(sentiment_positive) AS avg_sentiment_positive,
-    AVG(sentiment_neutral) AS avg_sentiment_neutral,
-    AVG(sentiment_negative) AS avg_sentiment_negative
+SELECT
+    channel, "user", message, message_id, "timestamp", sentiment_positive, sentiment_neutral, sentiment_negative, moderated
 FROM 
     results
 WHERE 
-    "timestamp" BETWEEN $1 AND $2
-GROUP BY
-    "minute_timestamp", channel
+    "timestamp" BETWEEN $1 AND $2 AND NOT moderated
 ORDER BY
-    "minute_timestamp" ASC;
-`, start, end)
+    "timestamp" DESC, channel, message_id
+LIMIT $3;
+`, start, end, limit)
 	if err != nil {
 		s.logger.Error(err)
 		return nil, err
 	}
 
-	databaseResults, err := pgx.CollectRows(rows, pgx.RowToStructByName[AverageResult])
+	databaseResults, err := pgx.CollectRows(rows, pgx.RowToStructByName[Result])
 	if err != nil {
 		s.logger.Error(err)
 		return nil, err
 	}
 
-	result := make(map[string][]AverageResult)
+	result := make(map[string][]Result)
 	for _, r := range databaseResults {
 		result[r.Channel] = append(result[r.Channel], r)
 	}
@@ -78,45 +100,51 @@ ORDER BY
 	return result, nil
 }
 
-func (s *ResultsService) GetLastResults(ctx context.Context, limit int64, moment time.Time) (map[string][]Result, error) {
-	start, end := startAndEndOfHour(moment)
+// Result with the moment it was stored
+type IngestedResult struct {
+	Result
+	IngestedAt time.Time `db:"ingested_at"`
+}
 
+// Results stored after since, in the order they were stored
+func (s *ResultsService) GetResultsSince(ctx context.Context, since time.Time, limit int64) ([]IngestedResult, error) {
 	rows, err := s.conn.Query(ctx, `
-SELECT 
-    *
-FROM 
+SELECT
+    channel, "user", message, message_id, "timestamp", sentiment_positive, sentiment_neutral, sentiment_negative, moderated, ingested_at
+FROM
     results
-WHERE 
-    "timestamp" BETWEEN $1 AND $2
+WHERE
+    ingested_at > $1 AND NOT moderated
 ORDER BY
-    "timestamp" DESC
-LIMIT $3;
-`, start, end, limit)
+    ingested_at ASC, channel, message_id
+LIMIT $2;
+`, since, limit)
 	if err != nil {
 		s.logger.Error(err)
 		return nil, err
 	}
 
-	databaseResults, err := pgx.CollectRows(rows, pgx.RowToStructByName[Result])
+	results, err := pgx.CollectRows(rows, pgx.RowToStructByName[IngestedResult])
 	if err != nil {
 		s.logger.Error(err)
 		return nil, err
 	}
 
-	result := make(map[string][]Result)
-	for _, r := range databaseResults {
-		result[r.Channel] = append(result[r.Channel], r)
+	return results, nil
+}
+
+// Per minute averages of the given channels in the [from, to) range
+func (s *ResultsService) GetChannelsAverageResults(ctx context.Context, channels []string, from, to time.Time) (map[string][]AverageResult, error) {
+	results, err := s.averageResults(ctx, channels, from, to, BucketMinute)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	return byChannel(results), nil
 }
 
-func startAndEndOfHour(t time.Time) (time.Time, time.Time) {
-	// Start of the hour: Set minutes, seconds, and nanoseconds to zero
+// Start of the hour of t and its last nanosecond
+func StartAndEndOfHour(t time.Time) (time.Time, time.Time) {
 	start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
-
-	// End of the hour: Add 59 minutes, 59 seconds, and 999999999 nanoseconds to the start
-	end := start.Add(59*time.Minute + 59*time.Second + 999999999*time.Nanosecond)
-
-	return start, end
+	return start, start.Add(time.Hour - time.Nanosecond)
 }
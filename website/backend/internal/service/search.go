@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Private use characters marking the matches in the headlines, chat messages do not contain them
+const (
+	matchStart = "\uE000"
+	matchEnd   = "\uE001"
+)
+
+type SearchQuery struct {
+	// Web search syntax: words, "quoted phrases", or and -excluded words
+	Text string
+	// Empty to search every channel
+	Channel string
+	// Empty to search messages of every user
+	User string
+	From time.Time
+	To   time.Time
+	// Minimum sentiment scores, 0 does not filter
+	MinPositive float64
+	MinNeutral  float64
+	MinNegative float64
+	Limit       int64
+	// Continues after the cursor when it is not nil
+	Cursor *Cursor
+}
+
+// Part of a message, matching the search or not
+type Fragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+type SearchResult struct {
+	Channel string `json:"channel"`
+	Result
+	// Message split in the fragments that match the search and those that do not
+	Highlight []Fragment `json:"highlight"`
+}
+
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// Messages matching the full-text query in the [from, to) range, newest first
+func (s *ResultsService) Search(ctx context.Context, query SearchQuery) (SearchPage, error) {
+	args := pgx.NamedArgs{
+		"text":         query.Text,
+		"channel":      query.Channel,
+		"user":         query.User,
+		"from":         query.From,
+		"to":           query.To,
+		"min_positive": query.MinPositive,
+		"min_neutral":  query.MinNeutral,
+		"min_negative": query.MinNegative,
+		"limit":        query.Limit + 1,
+		"options":      "StartSel=" + matchStart + ", StopSel=" + matchEnd + ", HighlightAll=true",
+	}
+	condition := ""
+	if query.Cursor != nil {
+		condition = `AND ("timestamp", message_id) < (@cursor_timestamp, @cursor_message_id)`
+		args["cursor_timestamp"] = query.Cursor.Timestamp
+		args["cursor_message_id"] = query.Cursor.MessageId
+	}
+
+	rows, err := s.conn.Query(ctx, `
+SELECT
+    channel, "user", message, message_id, "timestamp", sentiment_positive, sentiment_neutral, sentiment_negative, moderated,
+    ts_headline('simple', message, query, @options) AS headline
+FROM
+    results,
+    websearch_to_tsquery('simple', @text) AS query
+WHERE
+    search_vector @@ query
+    AND (@channel = '' OR channel = @channel)
+    AND (@user = '' OR "user" = @user)
+    AND "timestamp" >= @from AND "timestamp" < @to
+    AND sentiment_positive >= @min_positive
+    AND sentiment_neutral >= @min_neutral
+    AND sentiment_negative >= @min_negative `+condition+`
+ORDER BY
+    "timestamp" DESC, message_id DESC
+LIMIT @limit;
+`, args)
+	if err != nil {
+		s.logger.Error(err)
+		return SearchPage{}, err
+	}
+
+	type searchRow struct {
+		Result
+		Headline string `db:"headline"`
+	}
+	matches, err := pgx.CollectRows(rows, pgx.RowToStructByName[searchRow])
+	if err != nil {
+		s.logger.Error(err)
+		return SearchPage{}, err
+	}
+
+	page := SearchPage{Results: []SearchResult{}}
+	for _, match := range matches {
+		page.Results = append(page.Results, SearchResult{
+			Channel:   match.Channel,
+			Result:    match.Result,
+			Highlight: fragments(match.Headline),
+		})
+	}
+	if int64(len(page.Results)) > query.Limit {
+		page.Results = page.Results[:query.Limit]
+		last := page.Results[query.Limit-1]
+		page.NextCursor = Cursor{Timestamp: last.Timestamp, MessageId: last.MessageId}.Encode()
+	}
+
+	return page, nil
+}
+
+// Splits a headline at its match markers
+func fragments(headline string) []Fragment {
+	result := []Fragment{}
+	for headline != "" {
+		before, rest, found := strings.Cut(headline, matchStart)
+		if before != "" {
+			result = append(result, Fragment{Text: before})
+		}
+		if !found {
+			break
+		}
+		match, after, _ := strings.Cut(rest, matchEnd)
+		if match != "" {
+			result = append(result, Fragment{Text: match, Match: true})
+		}
+		headline = after
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestFragments(t *testing.T) {
+	require.Equal(t, []Fragment{}, fragments(""))
+	require.Equal(t, []Fragment{{Text: "no matches"}}, fragments("no matches"))
+	require.Equal(t, []Fragment{
+		{Text: "I want a "},
+		{Text: "refund", Match: true},
+		{Text: " "},
+		{Text: "now", Match: true},
+	}, fragments("I want a "+matchStart+"refund"+matchEnd+" "+matchStart+"now"+matchEnd))
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	require.NoError(t, testutils.PopulateDatabase(dsn, 2, 2))
+
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+	for i, message := range []string{"Where is my REFUND?", "refund refund refund", "no refunds here", "streamer asked for a refund"} {
+		_, err := conn.Exec(ctx, `INSERT INTO results
+			(channel, "user", "message_id", "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative)
+			VALUES ('channel1', $1, $2, $3, $4, 0.1, 0.2, $5);`,
+			[]string{"user1", "user2"}[i%2], "search-"+string(rune('a'+i)), now.Add(time.Duration(i)*time.Minute), message, 0.1*float64(i+1))
+		require.NoError(t, err)
+	}
+
+	service := NewResultsService(conn, logger)
+	query := SearchQuery{Text: "refund", From: now, To: now.Add(time.Hour), Limit: 2}
+
+	page, err := service.Search(ctx, query)
+	require.NoError(t, err)
+	require.Len(t, page.Results, 2)
+	require.Equal(t, "search-d", page.Results[0].MessageId)
+	require.Equal(t, "channel1", page.Results[0].Channel)
+	require.Equal(t, []Fragment{{Text: "streamer asked for a "}, {Text: "refund", Match: true}}, page.Results[0].Highlight)
+	require.NotEmpty(t, page.NextCursor)
+
+	cursor, err := DecodeCursor(page.NextCursor)
+	require.NoError(t, err)
+	query.Cursor = &cursor
+	page, err = service.Search(ctx, query)
+	require.NoError(t, err)
+	require.Len(t, page.Results, 1)
+	require.Equal(t, "search-a", page.Results[0].MessageId)
+	require.Equal(t, []Fragment{{Text: "Where is my "}, {Text: "REFUND", Match: true}, {Text: "?"}}, page.Results[0].Highlight)
+	require.Empty(t, page.NextCursor)
+
+	page, err = service.Search(ctx, SearchQuery{Text: "refund", User: "user2", From: now, To: now.Add(time.Hour), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Results, 2)
+
+	page, err = service.Search(ctx, SearchQuery{Text: "refund", MinNegative: 0.35, From: now, To: now.Add(time.Hour), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Results, 1)
+
+	page, err = service.Search(ctx, SearchQuery{Text: `"asked for" -streamer`, From: now, To: now.Add(time.Hour), Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page.Results)
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Silence in the chat of a channel after which its stream is considered over
+const streamGap = 30 * time.Minute
+
+type StreamEventKind string
+
+const (
+	StreamStarted StreamEventKind = "start"
+	StreamEnded   StreamEventKind = "end"
+)
+
+// Start or end of a stream, inferred from the chat activity since the pipeline doesn't record them
+type StreamEvent struct {
+	Channel string          `json:"channel"`
+	Kind    StreamEventKind `json:"kind"`
+	// Minute of the first message of the stream, or of the last one
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Stream events of the channels in the [from, to) range, of every channel when channels is nil, ordered by timestamp.
+// A stream starts with the first message after streamGap of silence, and ends with the last message before it, once the silence lasted until now.
+func (s *ResultsService) GetStreamEvents(ctx context.Context, channels []string, from, to, now time.Time) ([]StreamEvent, error) {
+	// Minutes around the range tell if its first and last messages follow or precede a silence
+	end := to.Add(streamGap)
+	if now.Before(end) {
+		end = now
+	}
+	if !end.After(from.Add(-streamGap)) {
+		return []StreamEvent{}, nil
+	}
+	averages, err := s.averageResults(ctx, channels, from.Add(-streamGap), end, BucketMinute)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []StreamEvent{}
+	for channel, channelAverages := range byChannel(averages) {
+		minutes := make([]time.Time, 0, len(channelAverages))
+		for _, average := range channelAverages {
+			minutes = append(minutes, average.Timestamp)
+		}
+		for _, event := range streamEvents(channel, minutes, end) {
+			if !event.Timestamp.Before(from) && event.Timestamp.Before(to) {
+				events = append(events, event)
+			}
+		}
+	}
+	slices.SortFunc(events, func(a, b StreamEvent) int {
+		if c := a.Timestamp.Compare(b.Timestamp); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Channel, b.Channel)
+	})
+	return events, nil
+}
+
+// Stream events of the ordered minutes with messages of a channel, read until end.
+// The first minute starts a stream, and the last one ends it only if the silence after it lasted until end.
+func streamEvents(channel string, minutes []time.Time, end time.Time) []StreamEvent {
+	events := []StreamEvent{}
+	for i, minute := range minutes {
+		if i == 0 || minute.Sub(minutes[i-1].Add(time.Minute)) >= streamGap {
+			events = append(events, StreamEvent{Channel: channel, Kind: StreamStarted, Timestamp: minute})
+		}
+		next := end
+		if i+1 < len(minutes) {
+			next = minutes[i+1]
+		}
+		if next.Sub(minute.Add(time.Minute)) >= streamGap {
+			events = append(events, StreamEvent{Channel: channel, Kind: StreamEnded, Timestamp: minute})
+		}
+	}
+	return events
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamEvents(t *testing.T) {
+	minute := time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return minute.Add(time.Duration(minutes) * time.Minute) }
+
+	require.Empty(t, streamEvents("gaules", nil, at(60)))
+
+	// Silences shorter than streamGap don't split a stream, and the last one hasn't ended before end
+	events := streamEvents("gaules", []time.Time{at(0), at(1), at(20), at(60), at(61)}, at(80))
+	require.Equal(t, []StreamEvent{
+		{Channel: "gaules", Kind: StreamStarted, Timestamp: at(0)},
+		{Channel: "gaules", Kind: StreamEnded, Timestamp: at(20)},
+		{Channel: "gaules", Kind: StreamStarted, Timestamp: at(60)},
+	}, events)
+
+	events = streamEvents("gaules", []time.Time{at(0)}, at(31))
+	require.Equal(t, []StreamEvent{
+		{Channel: "gaules", Kind: StreamStarted, Timestamp: at(0)},
+		{Channel: "gaules", Kind: StreamEnded, Timestamp: at(0)},
+	}, events)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"website/internal/trends"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Top terms of the channels, or of every channel with messages when there are none, in the [from, to) window,
+// with the rising terms compared with the window of the same length before it
+func (s *ResultsService) GetChannelsTrends(ctx context.Context, channels []string, from, to time.Time, limit int) (map[string]trends.Trends, error) {
+	key := fmt.Sprintf("trends|%s|%d|%d|%d", strings.Join(channels, ","), from.UnixNano(), to.UnixNano(), limit)
+	return cached(s.cache, key, func() (map[string]trends.Trends, error) {
+		previousFrom := from.Add(-to.Sub(from))
+		current, previous := map[string]*trends.Counter{}, map[string]*trends.Counter{}
+		for _, channel := range channels {
+			current[channel], previous[channel] = trends.NewCounter(), trends.NewCounter()
+		}
+
+		err := s.streamTrendResults(ctx, channels, previousFrom, to, func(result trendResult) error {
+			counters := current
+			if result.Timestamp.Before(from) {
+				counters = previous
+			}
+			counter, found := counters[result.Channel]
+			if !found {
+				counter = trends.NewCounter()
+				counters[result.Channel] = counter
+			}
+			counter.Add(result.Message, result.Emotes, result.PositiveSentiment, result.NeutralSentiment, result.NegativeSentiment)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		channelTrends := make(map[string]trends.Trends, len(current))
+		for channel, counter := range current {
+			before, found := previous[channel]
+			if !found {
+				before = trends.NewCounter()
+			}
+			channelTrends[channel] = trends.Compute(channel, from, to, counter, before, limit)
+		}
+		return channelTrends, nil
+	})
+}
+
+// Text and emotes of a result, what its terms are counted from
+type trendResult struct {
+	Channel           string    `db:"channel"`
+	Message           string    `db:"message"`
+	Emotes            []string  `db:"emotes"`
+	MessageId         string    `db:"message_id"`
+	Timestamp         time.Time `db:"timestamp"`
+	PositiveSentiment float64   `db:"sentiment_positive"`
+	NeutralSentiment  float64   `db:"sentiment_neutral"`
+	NegativeSentiment float64   `db:"sentiment_negative"`
+}
+
+// Calls fn with the results of the channels in the [from, to) range like StreamResults, with their emotes.
+// Emotes of moderated messages are left out like their text, and each message is counted once whatever the timestamp of its copies.
+func (s *ResultsService) streamTrendResults(ctx context.Context, channels []string, from, to time.Time, fn func(trendResult) error) error {
+	return exportRows(ctx, s, `
+SELECT
+    channel, message, emotes, message_id, "timestamp", sentiment_positive, sentiment_neutral, sentiment_negative
+FROM (
+    SELECT DISTINCT ON (channel, message_id)
+        channel, message, CASE WHEN moderated THEN '{}' ELSE emotes END AS emotes, message_id, "timestamp", sentiment_positive, sentiment_neutral, sentiment_negative
+    FROM
+        results
+    WHERE
+        (COALESCE(cardinality(@channels::VARCHAR[]), 0) = 0 OR channel = ANY(@channels)) AND "timestamp" >= @from AND "timestamp" < @to
+    ORDER BY
+        channel, message_id, ingested_at
+) AS results
+ORDER BY
+    "timestamp" ASC, message_id ASC
+`, pgx.NamedArgs{"channels": channels, "from": from, "to": to}, fn)
+}
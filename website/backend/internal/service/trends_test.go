@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestGetChannelsTrends(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	// One "sample message" per minute from 14:00 to 14:04
+	require.NoError(t, testutils.PopulateDatabase(dsn, 2, 5))
+
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+	service := NewResultsService(conn, logger)
+	from := time.Date(2024, 12, 1, 14, 3, 0, 0, time.UTC)
+
+	channelTrends, err := service.GetChannelsTrends(ctx, nil, from, from.Add(3*time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, channelTrends, 2)
+
+	trends := channelTrends["channel0"]
+	require.EqualValues(t, 2, trends.Messages)
+	require.Len(t, trends.Unigrams, 2)
+	require.Equal(t, "message", trends.Unigrams[0].Term)
+	require.EqualValues(t, 3, trends.Unigrams[0].PreviousMessages)
+	require.Equal(t, "sample message", trends.Bigrams[0].Term)
+	require.InDelta(t, 0.8, trends.Bigrams[0].AveragePositiveSentiment, 1e-9)
+	require.Empty(t, trends.Rising)
+
+	// Emotes removed from the text by the chat reader are terms too
+	_, err = conn.Exec(ctx, `UPDATE results SET emotes = '{KEKW}' WHERE channel = 'channel1';`)
+	require.NoError(t, err)
+	channelTrends, err = service.GetChannelsTrends(ctx, []string{"channel1", "unknown"}, from, from.Add(3*time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, channelTrends, 2)
+	require.Zero(t, channelTrends["unknown"].Messages)
+	terms := []string{}
+	for _, term := range channelTrends["channel1"].Unigrams {
+		terms = append(terms, term.Term)
+	}
+	require.Contains(t, terms, "kekw")
+}
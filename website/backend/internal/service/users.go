@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type LeaderboardOrder string
+
+const (
+	ByMessages LeaderboardOrder = "messages"
+	ByPositive LeaderboardOrder = "positive"
+	ByNegative LeaderboardOrder = "negative"
+)
+
+func ParseLeaderboardOrder(value string) (LeaderboardOrder, error) {
+	switch order := LeaderboardOrder(value); order {
+	case ByMessages, ByPositive, ByNegative:
+		return order, nil
+	}
+	return "", fmt.Errorf("invalid order %q, expected one of: messages, positive, negative", value)
+}
+
+// Messages and sentiment of a user in a range
+type SentimentStats struct {
+	Messages                 int64     `json:"messages" db:"messages"`
+	FirstSeen                time.Time `json:"first_seen" db:"first_seen"`
+	LastSeen                 time.Time `json:"last_seen" db:"last_seen"`
+	AveragePositiveSentiment float64   `json:"avg_sentiment_positive" db:"avg_sentiment_positive"`
+	AverageNeutralSentiment  float64   `json:"avg_sentiment_neutral" db:"avg_sentiment_neutral"`
+	AverageNegativeSentiment float64   `json:"avg_sentiment_negative" db:"avg_sentiment_negative"`
+}
+
+type UserStats struct {
+	User string `json:"user" db:"user"`
+	SentimentStats
+}
+
+type UserChannelStats struct {
+	Channel string `json:"channel" db:"channel"`
+	SentimentStats
+}
+
+type UserProfile struct {
+	User string `json:"user"`
+	// First and last message of the user in any channel and time, nil if the user never sent one
+	FirstSeen *time.Time `json:"first_seen"`
+	LastSeen  *time.Time `json:"last_seen"`
+	// Statistics of each channel in the range, most active first
+	Channels []UserChannelStats `json:"channels"`
+	// Averages of each channel grouped by bucket in the range
+	History map[string][]AverageResult `json:"history"`
+}
+
+// Users of a channel in the [from, to) range with at least minMessages messages, ordered by the leaderboard order
+func (s *ResultsService) GetLeaderboard(ctx context.Context, channel string, from, to time.Time, order LeaderboardOrder, minMessages, limit int64) ([]UserStats, error) {
+	key := fmt.Sprintf("leaderboard|%s|%d|%d|%s|%d|%d", channel, from.UnixNano(), to.UnixNano(), order, minMessages, limit)
+	return cached(s.cache, key, func() ([]UserStats, error) {
+		orderBy := "messages DESC"
+		switch order {
+		case ByPositive:
+			orderBy = "avg_sentiment_positive DESC, messages DESC"
+		case ByNegative:
+			orderBy = "avg_sentiment_negative DESC, messages DESC"
+		}
+
+		rows, err := s.conn.Query(ctx, `
+SELECT
+    "user",
+    COUNT(*) AS messages,
+    MIN("timestamp") AS first_seen,
+    MAX("timestamp") AS last_seen,
+    AVG(sentiment_positive) AS avg_sentiment_positive,
+    AVG(sentiment_neutral) AS avg_sentiment_neutral,
+    AVG(sentiment_negative) AS avg_sentiment_negative
+FROM
+    results
+WHERE
+    channel = $1 AND "timestamp" >= $2 AND "timestamp" < $3
+GROUP BY
+    "user"
+HAVING
+    COUNT(*) >= $4
+ORDER BY
+    `+orderBy+`, "user" ASC
+LIMIT $5;
+`, channel, from, to, minMessages, limit)
+		if err != nil {
+			s.logger.Error(err)
+			return nil, err
+		}
+
+		users, err := pgx.CollectRows(rows, pgx.RowToStructByName[UserStats])
+		if err != nil {
+			s.logger.Error(err)
+			return nil, err
+		}
+
+		return users, nil
+	})
+}
+
+// Sentiment of a user across channels in the [from, to) range, grouped by bucket
+func (s *ResultsService) GetUserProfile(ctx context.Context, user string, from, to time.Time, bucket Bucket) (UserProfile, error) {
+	key := fmt.Sprintf("profile|%s|%d|%d|%s", user, from.UnixNano(), to.UnixNano(), bucket)
+	return cached(s.cache, key, func() (UserProfile, error) {
+		profile := UserProfile{User: user}
+		err := s.conn.QueryRow(ctx, `SELECT MIN("timestamp"), MAX("timestamp") FROM results WHERE "user" = $1;`, user).
+			Scan(&profile.FirstSeen, &profile.LastSeen)
+		if err != nil {
+			s.logger.Error(err)
+			return UserProfile{}, err
+		}
+
+		rows, err := s.conn.Query(ctx, `
+SELECT
+    channel,
+    COUNT(*) AS messages,
+    MIN("timestamp") AS first_seen,
+    MAX("timestamp") AS last_seen,
+    AVG(sentiment_positive) AS avg_sentiment_positive,
+    AVG(sentiment_neutral) AS avg_sentiment_neutral,
+    AVG(sentiment_negative) AS avg_sentiment_negative
+FROM
+    results
+WHERE
+    "user" = $1 AND "timestamp" >= $2 AND "timestamp" < $3
+GROUP BY
+    channel
+ORDER BY
+    messages DESC, channel ASC;
+`, user, from, to)
+		if err != nil {
+			s.logger.Error(err)
+			return UserProfile{}, err
+		}
+		if profile.Channels, err = pgx.CollectRows(rows, pgx.RowToStructByName[UserChannelStats]); err != nil {
+			s.logger.Error(err)
+			return UserProfile{}, err
+		}
+
+		rows, err = s.conn.Query(ctx, `
+SELECT
+    DATE_TRUNC($4, "timestamp", 'UTC') AS "minute_timestamp",
+    channel,
+    COUNT(*) AS messages,
+    AVG(sentiment_positive) AS avg_sentiment_positive,
+    AVG(sentiment_neutral) AS avg_sentiment_neutral,
+    AVG(sentiment_negative) AS avg_sentiment_negative
+FROM
+    results
+WHERE
+    "user" = $1 AND "timestamp" >= $2 AND "timestamp" < $3
+GROUP BY
+    "minute_timestamp", channel
+ORDER BY
+    "minute_timestamp" ASC;
+`, user, from, to, string(bucket))
+		if err != nil {
+			s.logger.Error(err)
+			return UserProfile{}, err
+		}
+		history, err := pgx.CollectRows(rows, pgx.RowToStructByName[AverageResult])
+		if err != nil {
+			s.logger.Error(err)
+			return UserProfile{}, err
+		}
+		profile.History = byChannel(history)
+
+		return profile, nil
+	})
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"website/internal/database"
+	"website/internal/testutils"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseLeaderboardOrder(t *testing.T) {
+	order, err := ParseLeaderboardOrder("negative")
+	require.NoError(t, err)
+	require.Equal(t, ByNegative, order)
+
+	_, err = ParseLeaderboardOrder("neutral")
+	require.Error(t, err)
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := testutils.StartPostgresContainer()
+	require.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, postgresContainer)
+
+	dsn := postgresContainer.MustConnectionString(ctx)
+	t.Setenv("DATABASE_DSN", dsn)
+	conn := database.NewDatabaseConnection(logger)
+
+	messages := []struct {
+		channel  string
+		user     string
+		minutes  int
+		positive float64
+	}{
+		{"channel1", "happy", 0, 0.9},
+		{"channel1", "happy", 1, 0.7},
+		{"channel1", "grumpy", 2, 0.1},
+		{"channel1", "grumpy", 3, 0.1},
+		{"channel1", "grumpy", 4, 0.1},
+		{"channel2", "happy", 70, 0.5},
+	}
+	for i, m := range messages {
+		_, err := conn.Exec(ctx, `INSERT INTO results
+			(channel, "user", "message_id", "timestamp", message, sentiment_positive, sentiment_neutral, sentiment_negative)
+			VALUES ($1, $2, $3, $4, 'message', $5, 0, $6);`,
+			m.channel, m.user, "users-"+string(rune('a'+i)), now.Add(time.Duration(m.minutes)*time.Minute), m.positive, 1-m.positive)
+		require.NoError(t, err)
+	}
+
+	service := NewResultsService(conn, logger)
+	from, to := now, now.Add(time.Hour)
+
+	users, err := service.GetLeaderboard(ctx, "channel1", from, to, ByMessages, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	require.Equal(t, "grumpy", users[0].User)
+	require.EqualValues(t, 3, users[0].Messages)
+	require.True(t, users[0].FirstSeen.Equal(now.Add(2*time.Minute)))
+
+	users, err = service.GetLeaderboard(ctx, "channel1", from, to, ByPositive, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, "happy", users[0].User)
+	require.InDelta(t, 0.8, users[0].AveragePositiveSentiment, 1e-9)
+
+	users, err = service.GetLeaderboard(ctx, "channel1", from, to, ByNegative, 3, 10)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, "grumpy", users[0].User)
+
+	profile, err := service.GetUserProfile(ctx, "happy", from, now.Add(2*time.Hour), BucketHour)
+	require.NoError(t, err)
+	require.True(t, profile.FirstSeen.Equal(now))
+	require.True(t, profile.LastSeen.Equal(now.Add(70*time.Minute)))
+	require.Len(t, profile.Channels, 2)
+	require.Equal(t, "channel1", profile.Channels[0].Channel)
+	require.EqualValues(t, 2, profile.Channels[0].Messages)
+	require.Len(t, profile.History["channel1"], 1)
+	require.Len(t, profile.History["channel2"], 1)
+
+	// Cached results are returned until they expire
+	_, err = conn.Exec(ctx, `DELETE FROM results WHERE "user" = 'happy'`)
+	require.NoError(t, err)
+	profile, err = service.GetUserProfile(ctx, "happy", from, now.Add(2*time.Hour), BucketHour)
+	require.NoError(t, err)
+	require.Len(t, profile.Channels, 2)
+
+	profile, err = service.GetUserProfile(ctx, "unknown", from, to, BucketHour)
+	require.NoError(t, err)
+	require.Nil(t, profile.FirstSeen)
+	require.Empty(t, profile.Channels)
+}
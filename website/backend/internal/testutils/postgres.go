@@ -3,15 +3,17 @@ package testutils
 import (
 	"context"
 	"fmt"
-	"path/filepath"
 	"time"
+	"website/internal/migrate"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"go.uber.org/zap"
 )
 
+// Starts a Postgres container with every migration applied
 func StartPostgresContainer() (*postgres.PostgresContainer, error) {
 	databaseName := "twitchsentimentanalysis"
 	username := "user"
@@ -20,7 +22,6 @@ func StartPostgresContainer() (*postgres.PostgresContainer, error) {
 	postgresContainer, err := postgres.Run(
 		context.Background(),
 		"postgres:16.6-alpine3.20",
-		postgres.WithInitScripts(filepath.Join("../../../../message-analyzer/database.sql")),
 		postgres.WithDatabase(databaseName),
 		postgres.WithUsername(username),
 		postgres.WithPassword(password),
@@ -29,8 +30,21 @@ func StartPostgresContainer() (*postgres.PostgresContainer, error) {
 				WithOccurrence(2).
 				WithStartupTimeout(5*time.Second)),
 	)
+	if err != nil {
+		return postgresContainer, err
+	}
+
+	conn, err := pgxpool.New(context.Background(), postgresContainer.MustConnectionString(context.Background()))
+	if err != nil {
+		return postgresContainer, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := migrate.New(conn, zap.NewNop().Sugar()).Up(context.Background()); err != nil {
+		return postgresContainer, fmt.Errorf("failed to migrate database: %w", err)
+	}
 
-	return postgresContainer, err
+	return postgresContainer, nil
 }
 
 func PopulateDatabase(dsn string, channelsCount int, messagesPerChannel int) error {
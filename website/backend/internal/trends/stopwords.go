@@ -0,0 +1,34 @@
+package trends
+
+import "strings"
+
+// Stop words of the languages of the tracked channels, with the spellings common in chat
+var stopWordLists = map[string]string{
+	"en": `
+a about above after again against all am an and any are as at be because been before being below between both but by
+can could did do does doing down during each few for from further had has have having he her here hers herself him
+himself his how i if in into is it its itself just me more most my myself no nor not now of off on once only or other
+our ours ourselves out over own same she should so some such than that the their theirs them themselves then there
+these they this those through to too under until up very was we were what when where which while who whom why will
+with would you your yours yourself yourselves im its dont doesnt didnt isnt wasnt cant wont thats theres youre ive
+ill id hes shes were theyre lets u ur ya yeah yes oh ok okay like get got go going just really also still even
+`,
+	"pt": `
+a ao aos aquela aquelas aquele aqueles aquilo as até com como da das de dela delas dele deles depois do dos e ela
+elas ele eles em entre era eram essa essas esse esses esta estas este estes eu foi fomos for foram há isso isto já
+lhe lhes mais mas me mesmo meu meus minha minhas muito na não nas nem no nos nós nossa nossas nosso nossos num numa o
+os ou para pela pelas pelo pelos por qual quando que quem se sem ser será seu seus só sua suas também te tem têm ter
+teu tua tu um uma umas uns você vocês vos está estão estava tá ta to tô né ne pra pro pras pros q vc vcs oq tbm tb
+nao eh é ai aí aqui la lá so sim entao então agora ja vai vou cara mano tipo
+`,
+}
+
+var stopWords = func() map[string]bool {
+	words := map[string]bool{}
+	for _, list := range stopWordLists {
+		for _, word := range strings.Fields(list) {
+			words[word] = true
+		}
+	}
+	return words
+}()
@@ -0,0 +1,56 @@
+package trends
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Splits a chat message into lowercase words, in order.
+// Mentions and links are left out, and letters repeated more than twice are cut to two, so "kkkkkk" and "kkk" are the same word.
+// Twitch emotes are removed from the text by the chat reader and counted apart, emote names typed without the emote are words.
+func Tokenize(message string) []string {
+	words := []string{}
+	for _, field := range strings.Fields(message) {
+		lower := strings.ToLower(field)
+		if strings.HasPrefix(lower, "@") || strings.Contains(lower, "://") || strings.HasPrefix(lower, "www.") {
+			continue
+		}
+		for _, word := range strings.FieldsFunc(lower, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			words = append(words, collapseRepeats(word))
+		}
+	}
+	return words
+}
+
+func collapseRepeats(word string) string {
+	var b strings.Builder
+	var last rune
+	repeats := 0
+	for _, r := range word {
+		if r == last {
+			repeats++
+		} else {
+			last, repeats = r, 1
+		}
+		if repeats <= 2 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Whether the word says something about the chat: not a stop word, a single letter or a number
+func meaningful(word string) bool {
+	if utf8.RuneCountInString(word) < 2 || stopWords[word] {
+		return false
+	}
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
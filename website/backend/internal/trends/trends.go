@@ -0,0 +1,137 @@
+package trends
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// Fewer messages than this do not make a term top
+	minTermMessages = 2
+	// Fewer messages than this in the window do not make a term rising
+	minRisingMessages = 3
+)
+
+// Term of a window, with the messages that have it and their sentiment
+type Term struct {
+	// Word, or two words separated by a space
+	Term                     string  `json:"term"`
+	Messages                 int64   `json:"messages"`
+	AveragePositiveSentiment float64 `json:"avg_sentiment_positive"`
+	AverageNeutralSentiment  float64 `json:"avg_sentiment_neutral"`
+	AverageNegativeSentiment float64 `json:"avg_sentiment_negative"`
+	// Messages with the term in the window before
+	PreviousMessages int64 `json:"previous_messages"`
+}
+
+// What the chat of a channel talks about in the [from, to) window
+type Trends struct {
+	Channel  string    `json:"channel"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Messages int64     `json:"messages"`
+	// Most frequent words and pairs of words, by messages that have them
+	Unigrams []Term `json:"unigrams"`
+	Bigrams  []Term `json:"bigrams"`
+	// Terms that grew the most from the window before
+	Rising []Term `json:"rising"`
+}
+
+type termStats struct {
+	messages                    int64
+	positive, neutral, negative float64
+}
+
+// Counts the messages that have each term of a window
+type Counter struct {
+	messages int64
+	terms    map[string]*termStats
+}
+
+func NewCounter() *Counter {
+	return &Counter{terms: map[string]*termStats{}}
+}
+
+// Counts the terms of the message and its emotes once each, so repeated words do not outweigh other messages.
+// Emotes are terms by their lowercase name, "KEKW" and "kekw" are counted together.
+func (c *Counter) Add(message string, emotes []string, positive, neutral, negative float64) {
+	c.messages++
+	words := Tokenize(message)
+	seen := map[string]bool{}
+	for _, emote := range emotes {
+		if emote != "" {
+			seen[strings.ToLower(emote)] = true
+		}
+	}
+	for i, word := range words {
+		if !meaningful(word) {
+			continue
+		}
+		seen[word] = true
+		if i+1 < len(words) && meaningful(words[i+1]) && words[i+1] != word {
+			seen[word+" "+words[i+1]] = true
+		}
+	}
+
+	for term := range seen {
+		stats, found := c.terms[term]
+		if !found {
+			stats = &termStats{}
+			c.terms[term] = stats
+		}
+		stats.messages++
+		stats.positive += positive
+		stats.neutral += neutral
+		stats.negative += negative
+	}
+}
+
+// Top limit unigrams, bigrams and rising terms of the window counted by current, compared with the one counted by previous
+func Compute(channel string, from, to time.Time, current, previous *Counter, limit int) Trends {
+	trends := Trends{Channel: channel, From: from, To: to, Messages: current.messages, Unigrams: []Term{}, Bigrams: []Term{}, Rising: []Term{}}
+	for term, stats := range current.terms {
+		if stats.messages < minTermMessages {
+			continue
+		}
+		t := Term{
+			Term:                     term,
+			Messages:                 stats.messages,
+			AveragePositiveSentiment: stats.positive / float64(stats.messages),
+			AverageNeutralSentiment:  stats.neutral / float64(stats.messages),
+			AverageNegativeSentiment: stats.negative / float64(stats.messages),
+		}
+		if before, found := previous.terms[term]; found {
+			t.PreviousMessages = before.messages
+		}
+
+		if strings.Contains(term, " ") {
+			trends.Bigrams = append(trends.Bigrams, t)
+		} else {
+			trends.Unigrams = append(trends.Unigrams, t)
+		}
+		if t.Messages >= minRisingMessages && t.Messages > t.PreviousMessages {
+			trends.Rising = append(trends.Rising, t)
+		}
+	}
+
+	byMessages := func(a, b Term) int {
+		return cmp.Or(cmp.Compare(b.Messages, a.Messages), cmp.Compare(a.Term, b.Term))
+	}
+	slices.SortFunc(trends.Unigrams, byMessages)
+	slices.SortFunc(trends.Bigrams, byMessages)
+	// By growth, smoothed so terms new to the window do not divide by zero
+	slices.SortFunc(trends.Rising, func(a, b Term) int {
+		return cmp.Or(cmp.Compare(growth(b), growth(a)), byMessages(a, b))
+	})
+
+	trends.Unigrams = trends.Unigrams[:min(limit, len(trends.Unigrams))]
+	trends.Bigrams = trends.Bigrams[:min(limit, len(trends.Bigrams))]
+	trends.Rising = trends.Rising[:min(limit, len(trends.Rising))]
+	return trends
+}
+
+func growth(t Term) float64 {
+	return float64(t.Messages+1) / float64(t.PreviousMessages+1)
+}
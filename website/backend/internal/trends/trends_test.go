@@ -0,0 +1,97 @@
+package trends
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	require.Equal(t, []string{"que", "jogada", "kk", "kekw"}, Tokenize("QUE JOGADA kkkkkkk KEKW"))
+	require.Equal(t, []string{"gg", "wp", "noo"}, Tokenize("@gaules gg,wp! https://clips.twitch.tv/abc noooooo"))
+	require.Equal(t, []string{"não", "acredito"}, Tokenize("Não acredito..."))
+	require.Empty(t, Tokenize("   "))
+}
+
+func TestMeaningful(t *testing.T) {
+	require.True(t, meaningful("jogada"))
+	require.True(t, meaningful("kekw"))
+	require.True(t, meaningful("1v5"))
+	require.False(t, meaningful("the"))
+	require.False(t, meaningful("não"))
+	require.False(t, meaningful("x"))
+	require.False(t, meaningful("2024"))
+}
+
+func TestCountEmotes(t *testing.T) {
+	from := time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC)
+
+	current := NewCounter()
+	current.Add("que jogada", []string{"KEKW", "KEKW"}, 0.8, 0.2, 0)
+	current.Add("absurdo", []string{"kekw"}, 0.6, 0.4, 0)
+
+	// Emotes are terms by their lowercase name, once per message
+	trends := Compute("gaules", from, from.Add(10*time.Minute), current, NewCounter(), 10)
+	require.Len(t, trends.Unigrams, 1)
+	require.Equal(t, "kekw", trends.Unigrams[0].Term)
+	require.EqualValues(t, 2, trends.Unigrams[0].Messages)
+	require.InDelta(t, 0.7, trends.Unigrams[0].AveragePositiveSentiment, 1e-9)
+}
+
+func TestCompute(t *testing.T) {
+	from := time.Date(2024, 12, 1, 14, 0, 0, 0, time.UTC)
+
+	previous := NewCounter()
+	previous.Add("que jogada", nil, 0.9, 0.1, 0)
+	previous.Add("que jogada absurda", nil, 0.9, 0.1, 0)
+	previous.Add("bom dia chat", nil, 0.5, 0.5, 0)
+
+	current := NewCounter()
+	current.Add("que jogada", nil, 0.8, 0.2, 0)
+	current.Add("que jogada jogada JOGADA", nil, 0.6, 0.4, 0)
+	current.Add("que jogada", nil, 0.4, 0.6, 0)
+	current.Add("roubo roubo", nil, 0, 0.2, 0.8)
+	current.Add("que roubo", nil, 0, 0.4, 0.6)
+	current.Add("isso foi roubo", nil, 0, 0.3, 0.7)
+	current.Add("bom dia", nil, 0.5, 0.5, 0)
+
+	trends := Compute("gaules", from, from.Add(10*time.Minute), current, previous, 10)
+	require.Equal(t, "gaules", trends.Channel)
+	require.EqualValues(t, 7, trends.Messages)
+
+	// Words are counted once per message, "dia" and "bom dia" only appear once
+	require.Len(t, trends.Unigrams, 2)
+	require.Equal(t, "jogada", trends.Unigrams[0].Term)
+	require.EqualValues(t, 3, trends.Unigrams[0].Messages)
+	require.EqualValues(t, 2, trends.Unigrams[0].PreviousMessages)
+	require.InDelta(t, 0.6, trends.Unigrams[0].AveragePositiveSentiment, 1e-9)
+	require.Equal(t, "roubo", trends.Unigrams[1].Term)
+	require.InDelta(t, 0.7, trends.Unigrams[1].AverageNegativeSentiment, 1e-9)
+
+	// Repeated words do not make pairs
+	require.Empty(t, trends.Bigrams)
+
+	// New terms rise above terms that were already there
+	require.Len(t, trends.Rising, 2)
+	require.Equal(t, "roubo", trends.Rising[0].Term)
+	require.Equal(t, "jogada", trends.Rising[1].Term)
+
+	trends = Compute("gaules", from, from.Add(10*time.Minute), current, previous, 1)
+	require.Len(t, trends.Unigrams, 1)
+	require.Len(t, trends.Rising, 1)
+}
+
+func TestBigrams(t *testing.T) {
+	current := NewCounter()
+	for range 3 {
+		current.Add("que jogada absurda do coldzera", nil, 0.9, 0.1, 0)
+	}
+	trends := Compute("gaules", time.Time{}, time.Time{}, current, NewCounter(), 10)
+	terms := []string{}
+	for _, term := range trends.Bigrams {
+		terms = append(terms, term.Term)
+	}
+	// Stop words break pairs
+	require.Equal(t, []string{"jogada absurda"}, terms)
+}
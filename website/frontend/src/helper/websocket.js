@@ -6,7 +6,7 @@ export function connect(onMessageCallback) {
     clearTimeout(connectInternal)
   }
   const socket = new WebSocket(url);
-  socket.addEventListener("message", onMessageCallback);
+  socket.addEventListener("message", (event) => onMessageCallback(event, socket));
   socket.addEventListener("error", (error) => {
     console.error(error);
     socket.close()